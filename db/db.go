@@ -10,19 +10,12 @@ import (
 
 var DB *sql.DB
 
+// InitDB opens the connection pool. The schema is owned by the embedded
+// migrations (see MigrateUp and the migrate subcommand).
 func InitDB() {
 	var err error
 	DB, err = sql.Open("postgres", os.Getenv("DATABASE_URL"))
 	if err != nil {
 		log.Fatal("DB connection error:", err)
 	}
-
-	_, err = DB.Exec(`CREATE TABLE IF NOT EXISTS subscribers (
-        id SERIAL PRIMARY KEY,
-        email TEXT UNIQUE NOT NULL,
-        subscribed_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
-    )`)
-	if err != nil {
-		log.Fatal("DB init error:", err)
-	}
 }
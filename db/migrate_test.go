@@ -0,0 +1,63 @@
+package database
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestMigrationsLoad(t *testing.T) {
+	migrations, err := Migrations()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, m := range migrations {
+		if m.Version != i+1 {
+			t.Errorf("migration %04d_%s: want version %d, versions must not skip", m.Version, m.Name, i+1)
+		}
+		if m.Down == "" {
+			t.Errorf("migration %04d_%s has no down script", m.Version, m.Name)
+		}
+	}
+}
+
+var (
+	sqlComment   = regexp.MustCompile(`--[^\n]*`)
+	guardedBlock = regexp.MustCompile(`(?s)DO \$\$.*?\$\$;`)
+	createStmt   = regexp.MustCompile(`(?i)\bCREATE\s+(?:UNIQUE\s+)?(?:TABLE|INDEX)\s+(\S+\s+\S+\s+\S+)`)
+	addColumn    = regexp.MustCompile(`(?i)\bADD\s+COLUMN\s+([^,;]*)`)
+	destructive  = regexp.MustCompile(`(?i)\b(DROP|RENAME|ALTER\s+COLUMN)\b`)
+)
+
+// Up migrations only add to the schema, so older builds keep working on a
+// migrated database, and each statement is a no-op on a database that
+// already has what it creates.
+func TestMigrationsAreAdditive(t *testing.T) {
+	migrations, err := Migrations()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, m := range migrations {
+		script := sqlComment.ReplaceAllString(m.Up, "")
+		// Guarded blocks adopt the old InitDB schema; see 0001.
+		script = guardedBlock.ReplaceAllString(script, "")
+
+		for _, match := range createStmt.FindAllStringSubmatch(script, -1) {
+			if !strings.EqualFold(strings.Join(strings.Fields(match[1]), " "), "IF NOT EXISTS") {
+				t.Errorf("migration %04d_%s: %q must use IF NOT EXISTS", m.Version, m.Name, match[0])
+			}
+		}
+		for _, match := range addColumn.FindAllStringSubmatch(script, -1) {
+			column := strings.ToUpper(strings.Join(strings.Fields(match[1]), " "))
+			if !strings.HasPrefix(column, "IF NOT EXISTS ") {
+				t.Errorf("migration %04d_%s: ADD COLUMN %s must use IF NOT EXISTS", m.Version, m.Name, match[1])
+			}
+			if strings.Contains(column, "NOT NULL") && !strings.Contains(column, "DEFAULT") {
+				t.Errorf("migration %04d_%s: NOT NULL column %s needs a DEFAULT for older code to insert rows", m.Version, m.Name, match[1])
+			}
+		}
+		if match := destructive.FindString(script); match != "" {
+			t.Errorf("migration %04d_%s: up script uses %s", m.Version, m.Name, match)
+		}
+	}
+}
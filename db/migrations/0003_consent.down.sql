@@ -0,0 +1,11 @@
+ALTER TABLE email_verifications
+    DROP COLUMN IF EXISTS consent_source,
+    DROP COLUMN IF EXISTS consent_user_agent,
+    DROP COLUMN IF EXISTS consent_ip;
+
+ALTER TABLE subscribers
+    DROP COLUMN IF EXISTS confirmed_at,
+    DROP COLUMN IF EXISTS consented_at,
+    DROP COLUMN IF EXISTS consent_source,
+    DROP COLUMN IF EXISTS consent_user_agent,
+    DROP COLUMN IF EXISTS consent_ip;
@@ -0,0 +1,12 @@
+-- Consent evidence for compliance, carried from verification to subscriber.
+ALTER TABLE subscribers
+    ADD COLUMN IF NOT EXISTS consent_ip TEXT,
+    ADD COLUMN IF NOT EXISTS consent_user_agent TEXT,
+    ADD COLUMN IF NOT EXISTS consent_source TEXT,
+    ADD COLUMN IF NOT EXISTS consented_at TIMESTAMPTZ,
+    ADD COLUMN IF NOT EXISTS confirmed_at TIMESTAMPTZ;
+
+ALTER TABLE email_verifications
+    ADD COLUMN IF NOT EXISTS consent_ip TEXT,
+    ADD COLUMN IF NOT EXISTS consent_user_agent TEXT,
+    ADD COLUMN IF NOT EXISTS consent_source TEXT;
@@ -0,0 +1,6 @@
+DROP TABLE IF EXISTS suppressions;
+
+ALTER TABLE subscribers
+    DROP COLUMN IF EXISTS unsubscribe_reason,
+    DROP COLUMN IF EXISTS unsubscribed_at,
+    DROP COLUMN IF EXISTS status;
@@ -0,0 +1,12 @@
+-- Subscriber status instead of hard deletes, plus the global suppression list.
+ALTER TABLE subscribers
+    ADD COLUMN IF NOT EXISTS status TEXT NOT NULL DEFAULT 'active',
+    ADD COLUMN IF NOT EXISTS unsubscribed_at TIMESTAMPTZ,
+    ADD COLUMN IF NOT EXISTS unsubscribe_reason TEXT;
+
+CREATE TABLE IF NOT EXISTS suppressions (
+    email TEXT PRIMARY KEY,
+    reason TEXT NOT NULL,
+    detail TEXT,
+    created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+);
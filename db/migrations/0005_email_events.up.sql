@@ -0,0 +1,14 @@
+-- Delivery feedback (bounces, complaints, ...) from provider webhooks.
+CREATE TABLE IF NOT EXISTS email_events (
+    id BIGSERIAL PRIMARY KEY,
+    email TEXT NOT NULL,
+    event TEXT NOT NULL,
+    campaign_id BIGINT REFERENCES campaigns(id) ON DELETE SET NULL,
+    message_id TEXT NOT NULL DEFAULT '',
+    reason TEXT,
+    occurred_at TIMESTAMPTZ NOT NULL,
+    created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (email, event, message_id, occurred_at)
+);
+
+CREATE INDEX IF NOT EXISTS idx_email_events_email ON email_events(lower(email), event);
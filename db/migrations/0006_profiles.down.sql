@@ -0,0 +1,9 @@
+ALTER TABLE email_verifications
+    DROP COLUMN IF EXISTS attributes,
+    DROP COLUMN IF EXISTS last_name,
+    DROP COLUMN IF EXISTS first_name;
+
+ALTER TABLE subscribers
+    DROP COLUMN IF EXISTS attributes,
+    DROP COLUMN IF EXISTS last_name,
+    DROP COLUMN IF EXISTS first_name;
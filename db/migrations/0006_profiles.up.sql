@@ -0,0 +1,11 @@
+-- Optional names and free-form custom fields (company, role, ...) for
+-- personalization. Pending verifications carry them until confirmed.
+ALTER TABLE subscribers
+    ADD COLUMN IF NOT EXISTS first_name TEXT,
+    ADD COLUMN IF NOT EXISTS last_name TEXT,
+    ADD COLUMN IF NOT EXISTS attributes JSONB NOT NULL DEFAULT '{}';
+
+ALTER TABLE email_verifications
+    ADD COLUMN IF NOT EXISTS first_name TEXT,
+    ADD COLUMN IF NOT EXISTS last_name TEXT,
+    ADD COLUMN IF NOT EXISTS attributes JSONB NOT NULL DEFAULT '{}';
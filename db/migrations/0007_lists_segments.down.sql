@@ -0,0 +1,16 @@
+ALTER TABLE campaigns
+    DROP COLUMN IF EXISTS segment_id,
+    DROP COLUMN IF EXISTS list_id;
+
+DROP TABLE IF EXISTS segments;
+
+ALTER TABLE email_verifications
+    DROP COLUMN IF EXISTS lists;
+
+DROP INDEX IF EXISTS idx_subscribers_tags;
+
+ALTER TABLE subscribers
+    DROP COLUMN IF EXISTS tags;
+
+DROP TABLE IF EXISTS list_members;
+DROP TABLE IF EXISTS lists;
@@ -0,0 +1,40 @@
+-- Named lists, subscriber tags and saved segments for targeted sends.
+CREATE TABLE IF NOT EXISTS lists (
+    id BIGSERIAL PRIMARY KEY,
+    slug TEXT UNIQUE NOT NULL,
+    name TEXT NOT NULL,
+    description TEXT NOT NULL DEFAULT '',
+    -- Only public lists can be joined from the subscribe endpoints.
+    public BOOLEAN NOT NULL DEFAULT FALSE,
+    created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS list_members (
+    list_id BIGINT NOT NULL REFERENCES lists(id) ON DELETE CASCADE,
+    subscriber_id INT NOT NULL REFERENCES subscribers(id) ON DELETE CASCADE,
+    created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (list_id, subscriber_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_list_members_subscriber ON list_members(subscriber_id);
+
+ALTER TABLE subscribers
+    ADD COLUMN IF NOT EXISTS tags TEXT[] NOT NULL DEFAULT '{}';
+
+CREATE INDEX IF NOT EXISTS idx_subscribers_tags ON subscribers USING GIN (tags);
+
+-- Lists chosen at signup, joined once the address is confirmed.
+ALTER TABLE email_verifications
+    ADD COLUMN IF NOT EXISTS lists TEXT[] NOT NULL DEFAULT '{}';
+
+CREATE TABLE IF NOT EXISTS segments (
+    id BIGSERIAL PRIMARY KEY,
+    slug TEXT UNIQUE NOT NULL,
+    name TEXT NOT NULL,
+    filter TEXT NOT NULL,
+    created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+);
+
+ALTER TABLE campaigns
+    ADD COLUMN IF NOT EXISTS list_id BIGINT REFERENCES lists(id) ON DELETE SET NULL,
+    ADD COLUMN IF NOT EXISTS segment_id BIGINT REFERENCES segments(id) ON DELETE SET NULL;
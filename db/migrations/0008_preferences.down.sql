@@ -0,0 +1,11 @@
+ALTER TABLE campaigns
+    DROP COLUMN IF EXISTS digest;
+
+DROP INDEX IF EXISTS idx_email_verifications_purpose;
+
+ALTER TABLE email_verifications
+    DROP COLUMN IF EXISTS purpose;
+
+ALTER TABLE subscribers
+    DROP COLUMN IF EXISTS paused_until,
+    DROP COLUMN IF EXISTS frequency;
@@ -0,0 +1,14 @@
+-- Self-service preferences: how often to receive issues and pausing.
+ALTER TABLE subscribers
+    ADD COLUMN IF NOT EXISTS frequency TEXT NOT NULL DEFAULT 'every',
+    ADD COLUMN IF NOT EXISTS paused_until TIMESTAMPTZ;
+
+-- Verification tokens also authenticate the preference center.
+ALTER TABLE email_verifications
+    ADD COLUMN IF NOT EXISTS purpose TEXT NOT NULL DEFAULT 'subscribe';
+
+CREATE INDEX IF NOT EXISTS idx_email_verifications_purpose ON email_verifications(email, purpose);
+
+-- Digest campaigns go only to subscribers on the monthly digest.
+ALTER TABLE campaigns
+    ADD COLUMN IF NOT EXISTS digest BOOLEAN NOT NULL DEFAULT FALSE;
@@ -0,0 +1,6 @@
+DELETE FROM campaigns WHERE status = 'draft';
+
+DROP TABLE IF EXISTS campaign_revisions;
+
+ALTER TABLE campaigns
+    DROP COLUMN IF EXISTS revision;
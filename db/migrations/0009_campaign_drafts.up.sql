@@ -0,0 +1,18 @@
+-- Campaign drafts: Markdown content saved as immutable revisions until the
+-- campaign is sent.
+ALTER TABLE campaigns
+    ADD COLUMN IF NOT EXISTS revision INT NOT NULL DEFAULT 0;
+
+CREATE TABLE IF NOT EXISTS campaign_revisions (
+    campaign_id BIGINT NOT NULL REFERENCES campaigns(id) ON DELETE CASCADE,
+    revision INT NOT NULL,
+    subject TEXT NOT NULL,
+    preheader TEXT NOT NULL DEFAULT '',
+    body TEXT NOT NULL,
+    -- The target is kept by slug so old revisions survive list changes.
+    list_slug TEXT NOT NULL DEFAULT '',
+    segment_slug TEXT NOT NULL DEFAULT '',
+    digest BOOLEAN NOT NULL DEFAULT FALSE,
+    created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (campaign_id, revision)
+);
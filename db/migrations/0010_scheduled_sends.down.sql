@@ -0,0 +1,7 @@
+UPDATE campaigns SET status = 'draft' WHERE status = 'scheduled';
+
+DROP INDEX IF EXISTS idx_campaigns_scheduled;
+
+ALTER TABLE campaigns
+    DROP COLUMN IF EXISTS time_zone,
+    DROP COLUMN IF EXISTS scheduled_at;
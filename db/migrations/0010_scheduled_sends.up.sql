@@ -0,0 +1,6 @@
+-- Scheduled campaigns, started by the scheduler once scheduled_at has passed.
+ALTER TABLE campaigns
+    ADD COLUMN IF NOT EXISTS scheduled_at TIMESTAMPTZ,
+    ADD COLUMN IF NOT EXISTS time_zone TEXT NOT NULL DEFAULT '';
+
+CREATE INDEX IF NOT EXISTS idx_campaigns_scheduled ON campaigns(scheduled_at) WHERE status = 'scheduled';
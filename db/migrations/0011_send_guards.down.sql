@@ -0,0 +1,7 @@
+DROP INDEX IF EXISTS idx_campaigns_content_hash;
+
+ALTER TABLE campaigns
+    DROP COLUMN IF EXISTS queued_at,
+    DROP COLUMN IF EXISTS content_hash;
+
+DROP TABLE IF EXISTS idempotency_keys;
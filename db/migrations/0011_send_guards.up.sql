@@ -0,0 +1,17 @@
+-- Guards against double sends: /send responses recorded by Idempotency-Key,
+-- and a content hash to spot the same issue going to the same audience.
+CREATE TABLE IF NOT EXISTS idempotency_keys (
+    key TEXT PRIMARY KEY,
+    request_hash TEXT NOT NULL,
+    -- NULL while the request is still being handled.
+    status INT,
+    response BYTEA,
+    campaign_id BIGINT REFERENCES campaigns(id) ON DELETE SET NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+ALTER TABLE campaigns
+    ADD COLUMN IF NOT EXISTS content_hash TEXT,
+    ADD COLUMN IF NOT EXISTS queued_at TIMESTAMPTZ;
+
+CREATE INDEX IF NOT EXISTS idx_campaigns_content_hash ON campaigns(content_hash, queued_at);
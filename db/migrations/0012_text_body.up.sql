@@ -0,0 +1,2 @@
+-- Plain-text alternative of a campaign, rendered from its Markdown.
+ALTER TABLE campaigns ADD COLUMN IF NOT EXISTS text_body TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,2 @@
+-- Theme a draft is rendered with; empty means the default theme.
+ALTER TABLE campaign_revisions ADD COLUMN IF NOT EXISTS theme TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,2 @@
+ALTER TABLE campaign_revisions DROP COLUMN IF EXISTS utm_campaign;
+ALTER TABLE campaign_revisions DROP COLUMN IF EXISTS tags;
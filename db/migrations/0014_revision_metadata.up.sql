@@ -0,0 +1,3 @@
+-- Labels for organising drafts, and the utm_campaign added to their links.
+ALTER TABLE campaign_revisions ADD COLUMN IF NOT EXISTS tags TEXT[] NOT NULL DEFAULT '{}';
+ALTER TABLE campaign_revisions ADD COLUMN IF NOT EXISTS utm_campaign TEXT NOT NULL DEFAULT '';
@@ -0,0 +1 @@
+ALTER TABLE campaign_revisions DROP COLUMN IF EXISTS trusted_html;
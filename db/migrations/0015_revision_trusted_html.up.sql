@@ -0,0 +1,2 @@
+-- Drafts whose raw HTML is sent as written rather than sanitized.
+ALTER TABLE campaign_revisions ADD COLUMN IF NOT EXISTS trusted_html BOOLEAN NOT NULL DEFAULT false;
@@ -0,0 +1,2 @@
+-- Scheduled campaigns sent with force skip the duplicate check when they start.
+ALTER TABLE campaigns ADD COLUMN IF NOT EXISTS forced BOOLEAN NOT NULL DEFAULT false;
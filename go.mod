@@ -1,16 +1,20 @@
 module github.com/pixperk/newsletter
 
-go 1.23
+go 1.23.0
 
 require (
+	github.com/BurntSushi/toml v1.6.0
+	github.com/andybalholm/cascadia v1.3.3
 	github.com/gomarkdown/markdown v0.0.0-20250311123330-531bef5e742b
 	github.com/joho/godotenv v1.5.1
 	github.com/lib/pq v1.10.9
+	github.com/yuin/goldmark v1.7.12
+	github.com/yuin/goldmark-highlighting v0.0.0-20220208100518-594be1970594
+	golang.org/x/net v0.43.0
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
 	github.com/alecthomas/chroma v0.10.0 // indirect
 	github.com/dlclark/regexp2 v1.4.0 // indirect
-	github.com/yuin/goldmark v1.7.12 // indirect
-	github.com/yuin/goldmark-highlighting v0.0.0-20220208100518-594be1970594 // indirect
 )
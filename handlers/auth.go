@@ -0,0 +1,20 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"os"
+)
+
+// requireSecret checks the admin X-Secret header against SEND_SECRET and
+// writes a 401 when it does not match. An unset SEND_SECRET locks the
+// admin endpoints instead of opening them.
+func requireSecret(w http.ResponseWriter, r *http.Request) bool {
+	secret := os.Getenv("SEND_SECRET")
+	got := r.Header.Get("X-Secret")
+	if secret == "" || subtle.ConstantTimeCompare([]byte(got), []byte(secret)) != 1 {
+		SendJSON(w, http.StatusUnauthorized, JSONResponse{Error: "Unauthorized - invalid or missing X-Secret header"})
+		return false
+	}
+	return true
+}
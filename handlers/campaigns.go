@@ -0,0 +1,143 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/pixperk/newsletter/store"
+)
+
+// CampaignStatus is the progress report returned for a campaign.
+type CampaignStatus struct {
+	ID        int64     `json:"id"`
+	Subject   string    `json:"subject"`
+	List      string    `json:"list,omitempty"`
+	Segment   string    `json:"segment,omitempty"`
+	Digest    bool      `json:"digest,omitempty"`
+	Revision  int       `json:"revision,omitempty"`
+	Status    string    `json:"status"`
+	CreatedAt time.Time `json:"created_at"`
+	// ScheduledAt is shown in TimeZone, the zone it was scheduled in.
+	ScheduledAt *time.Time      `json:"scheduled_at,omitempty"`
+	TimeZone    string          `json:"time_zone,omitempty"`
+	StartedAt   *time.Time      `json:"started_at,omitempty"`
+	FinishedAt  *time.Time      `json:"finished_at,omitempty"`
+	Queued      int             `json:"queued"`
+	Sent        int             `json:"sent"`
+	Failed      int             `json:"failed"`
+	Skipped     int             `json:"skipped"`
+	Errors      []DeliveryError `json:"errors,omitempty"`
+	// ErrorsOmitted counts the failed deliveries beyond those in Errors.
+	ErrorsOmitted int `json:"errors_omitted,omitempty"`
+}
+
+type DeliveryError struct {
+	Email string `json:"email"`
+	Error string `json:"error"`
+}
+
+func newCampaignStatus(c *store.Campaign) *CampaignStatus {
+	status := &CampaignStatus{
+		ID:         c.ID,
+		Subject:    c.Subject,
+		List:       c.Audience.List,
+		Segment:    c.Audience.Segment,
+		Digest:     c.Audience.Digest,
+		Revision:   c.Revision,
+		Status:     c.Status,
+		CreatedAt:  c.CreatedAt,
+		StartedAt:  c.StartedAt,
+		FinishedAt: c.FinishedAt,
+		Queued:     c.Queued,
+		Sent:       c.Sent,
+		Failed:     c.Failed,
+		Skipped:    c.Skipped,
+	}
+	if c.ScheduledAt != nil {
+		at := *c.ScheduledAt
+		if loc, err := time.LoadLocation(c.TimeZone); err == nil {
+			at = at.In(loc)
+		}
+		status.ScheduledAt, status.TimeZone = &at, c.TimeZone
+	}
+	for _, e := range c.Errors {
+		status.Errors = append(status.Errors, DeliveryError{Email: e.Email, Error: e.Error})
+	}
+	status.ErrorsOmitted = c.Failed - len(c.Errors)
+	return status
+}
+
+// CampaignHandler serves /campaigns/{id}: GET reports progress and, for
+// drafts, the latest revision; PUT saves a new revision of a draft and
+// DELETE discards it.
+func CampaignHandler(d *Deps) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodPut && r.Method != http.MethodDelete {
+			SendJSON(w, http.StatusMethodNotAllowed, JSONResponse{Error: "Method not allowed"})
+			return
+		}
+
+		if !requireSecret(w, r) {
+			return
+		}
+
+		id, ok := campaignID(w, r)
+		if !ok {
+			return
+		}
+
+		switch r.Method {
+		case http.MethodPut:
+			dr, ok := decodeDraft(w, r, d)
+			if !ok {
+				return
+			}
+			rev, err := d.Drafts.SaveDraft(r.Context(), id, dr)
+			if !draftError(w, err) {
+				return
+			}
+			info := newDraftInfo(*rev)
+			SendJSON(w, http.StatusOK, JSONResponse{Success: true, CampaignID: id, Draft: &info})
+			return
+		case http.MethodDelete:
+			if !draftError(w, d.Drafts.DeleteDraft(r.Context(), id)) {
+				return
+			}
+			SendJSON(w, http.StatusOK, JSONResponse{Success: true, Message: "Draft deleted"})
+			return
+		}
+
+		campaign, err := d.Campaigns.Campaign(r.Context(), id)
+		if errors.Is(err, store.ErrNotFound) {
+			SendJSON(w, http.StatusNotFound, JSONResponse{Error: "Campaign not found"})
+			return
+		}
+		if err != nil {
+			SendJSON(w, http.StatusInternalServerError, JSONResponse{Error: "Database error occurred"})
+			return
+		}
+
+		resp := JSONResponse{Success: true, CampaignID: campaign.ID, Campaign: newCampaignStatus(campaign)}
+		if campaign.Revision > 0 {
+			rev, err := d.Drafts.Revision(r.Context(), id, campaign.Revision)
+			if err != nil {
+				SendJSON(w, http.StatusInternalServerError, JSONResponse{Error: "Database error occurred"})
+				return
+			}
+			info := newDraftInfo(*rev)
+			resp.Draft = &info
+		}
+		SendJSON(w, http.StatusOK, resp)
+	}
+}
+
+func campaignID(w http.ResponseWriter, r *http.Request) (int64, bool) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		SendJSON(w, http.StatusBadRequest, JSONResponse{Error: "Invalid campaign ID"})
+		return 0, false
+	}
+	return id, true
+}
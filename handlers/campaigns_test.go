@@ -0,0 +1,44 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/pixperk/newsletter/store"
+)
+
+func TestCampaignStatusCapsErrors(t *testing.T) {
+	ctx := context.Background()
+	d, st, _ := newTestDeps(t)
+	const recipients = store.MaxDeliveryErrors + 20
+	for i := range recipients {
+		subscribe(t, st, fmt.Sprintf("reader%d@example.com", i))
+	}
+	id, _, err := st.CreateCampaign(ctx, "Issue 1", "<p>Hi</p>", "", store.Audience{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	claimed, err := st.ClaimDeliveries(ctx, recipients, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, del := range claimed {
+		if err := st.SettleDelivery(ctx, del.ID, store.DeliveryFailed, "provider outage"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	target := fmt.Sprintf("/campaigns/%d", id)
+	w, resp := serve(t, CampaignHandler(d), request{method: http.MethodGet, target: target, pattern: "/campaigns/{id}", admin: true})
+	if w.Code != http.StatusOK || resp.Campaign == nil {
+		t.Fatalf("status %d, %+v", w.Code, resp)
+	}
+	c := resp.Campaign
+	if c.Failed != recipients || len(c.Errors) != store.MaxDeliveryErrors || c.ErrorsOmitted != 20 {
+		t.Errorf("failed %d with %d errors listed and %d omitted; want %d, %d and 20",
+			c.Failed, len(c.Errors), c.ErrorsOmitted, recipients, store.MaxDeliveryErrors)
+	}
+}
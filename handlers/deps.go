@@ -0,0 +1,31 @@
+package handlers
+
+import (
+	"github.com/pixperk/newsletter/store"
+	"github.com/pixperk/newsletter/templates"
+	"github.com/pixperk/newsletter/utils"
+)
+
+// Notifier is told when new deliveries have been queued.
+type Notifier interface {
+	Notify()
+}
+
+// Deps bundles what the handlers need. main wires the Postgres store and
+// the configured sender; store.Memory works for local experiments.
+type Deps struct {
+	Subscribers   store.SubscriberStore
+	Verifications store.VerificationStore
+	Campaigns     store.CampaignStore
+	Drafts        store.DraftStore
+	Lists         store.ListStore
+	Idempotency   store.IdempotencyStore
+	Events        store.EventStore
+	Sender        utils.EmailSender
+	Queue         Notifier
+	Policy        SubscriptionPolicy
+	Bounces       BouncePolicy
+	Sends         SendPolicy
+	TestSends     TestSendPolicy
+	Themes        *templates.Registry
+}
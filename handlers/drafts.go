@@ -0,0 +1,474 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pixperk/newsletter/store"
+	"github.com/pixperk/newsletter/templates"
+	"github.com/pixperk/newsletter/utils"
+)
+
+var errListAndSegment = errors.New("Choose either a list or a segment, not both")
+
+// DraftInfo is a campaign revision as returned by the admin API.
+type DraftInfo struct {
+	CampaignID  int64     `json:"campaign_id"`
+	Revision    int       `json:"revision"`
+	Subject     string    `json:"subject"`
+	Preheader   string    `json:"preheader,omitempty"`
+	Body        string    `json:"body"`
+	Theme       string    `json:"theme,omitempty"`
+	List        string    `json:"list,omitempty"`
+	Segment     string    `json:"segment,omitempty"`
+	Digest      bool      `json:"digest,omitempty"`
+	Tags        []string  `json:"tags,omitempty"`
+	UTMCampaign string    `json:"utm_campaign,omitempty"`
+	TrustedHTML bool      `json:"trusted_html,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+func newDraftInfo(r store.Revision) DraftInfo {
+	return DraftInfo{
+		CampaignID:  r.CampaignID,
+		Revision:    r.Number,
+		Subject:     r.Subject,
+		Preheader:   r.Preheader,
+		Body:        r.Body,
+		Theme:       r.Theme,
+		List:        r.Audience.List,
+		Segment:     r.Audience.Segment,
+		Digest:      r.Audience.Digest,
+		Tags:        r.Tags,
+		UTMCampaign: r.UTMCampaign,
+		TrustedHTML: r.TrustedHTML,
+		CreatedAt:   r.CreatedAt,
+	}
+}
+
+// DraftRequest is the full content of a draft; every save stores it as a
+// new revision. Body may start with front matter (see utils.FrontMatter),
+// which fills in the fields the request leaves empty.
+type DraftRequest struct {
+	Subject   string `json:"subject"`
+	Preheader string `json:"preheader"`
+	Body      string `json:"body"`
+	// Theme names the theme to render with; the default one if empty.
+	Theme string `json:"theme"`
+	// List or Segment (slugs) limits the send; without either it goes to
+	// every active subscriber.
+	List    string `json:"list"`
+	Segment string `json:"segment"`
+	// Digest sends to subscribers who chose the monthly digest instead of
+	// those who want every issue.
+	Digest bool     `json:"digest"`
+	Tags   []string `json:"tags"`
+	// UTMCampaign is added as utm_campaign to the links of the campaign.
+	UTMCampaign string `json:"utm_campaign"`
+	// TrustedHTML sends raw HTML in the body as written; by default it is
+	// reduced to an email-safe allowlist (see utils.SanitizeHTML).
+	TrustedHTML bool `json:"trusted_html"`
+}
+
+// invalidDraftError is a draft request that cannot be saved as written.
+type invalidDraftError struct {
+	err error
+}
+
+func (e *invalidDraftError) Error() string {
+	return e.err.Error()
+}
+
+// applyFrontMatter moves the front matter of req.Body, if any, into the
+// fields req leaves empty and returns it.
+func (req *DraftRequest) applyFrontMatter() (utils.FrontMatter, error) {
+	fm, body, err := utils.SplitFrontMatter(req.Body)
+	if err != nil {
+		return fm, &invalidDraftError{fmt.Errorf("Invalid %w", err)}
+	}
+	req.Body = body
+	for _, f := range []struct {
+		field *string
+		value string
+	}{
+		{&req.Subject, fm.Subject},
+		{&req.Preheader, fm.Preheader},
+		{&req.Theme, fm.Theme},
+		{&req.List, fm.List},
+		{&req.Segment, fm.Segment},
+		{&req.UTMCampaign, fm.UTMCampaign},
+	} {
+		if *f.field == "" {
+			*f.field = f.value
+		}
+	}
+	if req.Tags == nil {
+		req.Tags = fm.Tags
+	}
+	req.TrustedHTML = req.TrustedHTML || fm.TrustedHTML
+	return fm, nil
+}
+
+// RevisionDiff compares two revisions of a campaign.
+type RevisionDiff struct {
+	From    int           `json:"from"`
+	To      int           `json:"to"`
+	Changes []FieldChange `json:"changes"`
+	// Body is a unified diff of the Markdown, empty when unchanged.
+	Body string `json:"body,omitempty"`
+}
+
+// FieldChange is a single-line field that differs between two revisions.
+type FieldChange struct {
+	Field string `json:"field"`
+	From  string `json:"from"`
+	To    string `json:"to"`
+}
+
+// draft validates the request. The list or segment must exist when the
+// draft is saved; it is checked again when the campaign is sent.
+func (req DraftRequest) draft(r *http.Request, d *Deps) (store.Draft, error) {
+	dr := store.Draft{
+		Subject:     strings.TrimSpace(req.Subject),
+		Preheader:   strings.TrimSpace(req.Preheader),
+		Body:        req.Body,
+		Theme:       strings.TrimSpace(req.Theme),
+		Audience:    store.Audience{List: strings.TrimSpace(req.List), Segment: strings.TrimSpace(req.Segment), Digest: req.Digest},
+		UTMCampaign: strings.TrimSpace(req.UTMCampaign),
+		TrustedHTML: req.TrustedHTML,
+	}
+	if dr.Audience.List != "" && dr.Audience.Segment != "" {
+		return dr, &invalidDraftError{errListAndSegment}
+	}
+	tags, err := normalizeTags(req.Tags)
+	if err != nil {
+		return dr, &invalidDraftError{fmt.Errorf("Invalid tags: %w", err)}
+	}
+	dr.Tags = tags
+	if _, err := d.Themes.Theme(dr.Theme); err != nil {
+		return dr, err
+	}
+	if dr.Audience.List != "" {
+		if _, err := d.Lists.List(r.Context(), dr.Audience.List); err != nil {
+			return dr, err
+		}
+	}
+	if dr.Audience.Segment != "" {
+		if _, err := d.Lists.Segment(r.Context(), dr.Audience.Segment); err != nil {
+			return dr, err
+		}
+	}
+	return dr, nil
+}
+
+// CampaignsHandler serves GET /campaigns, the unsent drafts (?tag= keeps
+// those with that tag), and POST /campaigns, which creates a draft.
+func CampaignsHandler(d *Deps) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodPost {
+			SendJSON(w, http.StatusMethodNotAllowed, JSONResponse{Error: "Method not allowed"})
+			return
+		}
+
+		if !requireSecret(w, r) {
+			return
+		}
+
+		if r.Method == http.MethodGet {
+			drafts, err := d.Drafts.Drafts(r.Context())
+			if err != nil {
+				SendJSON(w, http.StatusInternalServerError, JSONResponse{Error: "Database error occurred"})
+				return
+			}
+			tag := strings.ToLower(strings.TrimSpace(r.URL.Query().Get("tag")))
+			infos := make([]DraftInfo, 0, len(drafts))
+			for _, dr := range drafts {
+				if tag == "" || slices.Contains(dr.Tags, tag) {
+					infos = append(infos, newDraftInfo(dr))
+				}
+			}
+			SendJSON(w, http.StatusOK, JSONResponse{Success: true, Drafts: infos})
+			return
+		}
+
+		dr, ok := decodeDraft(w, r, d)
+		if !ok {
+			return
+		}
+		rev, err := d.Drafts.CreateDraft(r.Context(), dr)
+		if err != nil {
+			SendJSON(w, http.StatusInternalServerError, JSONResponse{Error: "Database error occurred"})
+			return
+		}
+
+		info := newDraftInfo(*rev)
+		SendJSON(w, http.StatusCreated, JSONResponse{Success: true, CampaignID: rev.CampaignID, Draft: &info})
+	}
+}
+
+// decodeDraft reads and validates a DraftRequest, or a whole issue sent
+// as text/markdown, writing the error response itself.
+func decodeDraft(w http.ResponseWriter, r *http.Request, d *Deps) (store.Draft, bool) {
+	var req DraftRequest
+	if isMarkdown(r) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			SendJSON(w, http.StatusBadRequest, JSONResponse{Error: "Invalid Markdown request"})
+			return store.Draft{}, false
+		}
+		req.Body = string(body)
+	} else if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		SendJSON(w, http.StatusBadRequest, JSONResponse{Error: "Invalid JSON request"})
+		return store.Draft{}, false
+	}
+
+	fm, err := req.applyFrontMatter()
+	if err != nil {
+		SendJSON(w, http.StatusBadRequest, JSONResponse{Error: err.Error()})
+		return store.Draft{}, false
+	}
+	if fm.ScheduledAt != "" {
+		SendJSON(w, http.StatusBadRequest, JSONResponse{Error: "Drafts are not scheduled from front matter; use /send or /campaigns/{id}/schedule"})
+		return store.Draft{}, false
+	}
+	return validDraft(w, r, d, req)
+}
+
+// isMarkdown reports whether the request body is a Markdown issue rather
+// than JSON.
+func isMarkdown(r *http.Request) bool {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return mediaType == "text/markdown"
+}
+
+// validDraft validates req, writing the error response itself.
+func validDraft(w http.ResponseWriter, r *http.Request, d *Deps, req DraftRequest) (store.Draft, bool) {
+	dr, err := req.draft(r, d)
+	var invalid *invalidDraftError
+	switch {
+	case errors.As(err, &invalid):
+		SendJSON(w, http.StatusBadRequest, JSONResponse{Error: err.Error()})
+	case errors.Is(err, templates.ErrUnknownTheme):
+		SendJSON(w, http.StatusBadRequest, JSONResponse{Error: unknownThemeMessage(d, dr.Theme)})
+	case errors.Is(err, store.ErrNotFound):
+		SendJSON(w, http.StatusNotFound, JSONResponse{Error: "List or segment not found"})
+	case err != nil:
+		SendJSON(w, http.StatusInternalServerError, JSONResponse{Error: "Database error occurred"})
+	}
+	return dr, err == nil
+}
+
+// unknownThemeMessage tells the client which themes it can choose from.
+func unknownThemeMessage(d *Deps, name string) string {
+	return fmt.Sprintf("Unknown theme %q, choose one of: %s", name, strings.Join(d.Themes.Names(), ", "))
+}
+
+// RevisionsHandler serves GET /campaigns/{id}/revisions and, with a
+// number, GET /campaigns/{id}/revisions/{revision}.
+func RevisionsHandler(d *Deps) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			SendJSON(w, http.StatusMethodNotAllowed, JSONResponse{Error: "Method not allowed"})
+			return
+		}
+
+		if !requireSecret(w, r) {
+			return
+		}
+
+		id, ok := campaignID(w, r)
+		if !ok {
+			return
+		}
+
+		if r.PathValue("revision") != "" {
+			number, err := strconv.Atoi(r.PathValue("revision"))
+			if err != nil || number < 1 {
+				SendJSON(w, http.StatusBadRequest, JSONResponse{Error: "Invalid revision"})
+				return
+			}
+			rev, err := d.Drafts.Revision(r.Context(), id, number)
+			if !draftError(w, err) {
+				return
+			}
+			info := newDraftInfo(*rev)
+			SendJSON(w, http.StatusOK, JSONResponse{Success: true, CampaignID: id, Draft: &info})
+			return
+		}
+
+		revisions, err := d.Drafts.Revisions(r.Context(), id)
+		if !draftError(w, err) {
+			return
+		}
+		infos := make([]DraftInfo, 0, len(revisions))
+		for _, rev := range revisions {
+			infos = append(infos, newDraftInfo(rev))
+		}
+		SendJSON(w, http.StatusOK, JSONResponse{Success: true, CampaignID: id, Revisions: infos})
+	}
+}
+
+// RevisionDiffHandler serves GET /campaigns/{id}/diff?from=N&to=M. To
+// defaults to the latest revision and from to the one before it.
+func RevisionDiffHandler(d *Deps) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			SendJSON(w, http.StatusMethodNotAllowed, JSONResponse{Error: "Method not allowed"})
+			return
+		}
+
+		if !requireSecret(w, r) {
+			return
+		}
+
+		id, ok := campaignID(w, r)
+		if !ok {
+			return
+		}
+
+		var from, to int
+		for name, n := range map[string]*int{"from": &from, "to": &to} {
+			if v := r.URL.Query().Get(name); v != "" {
+				var err error
+				if *n, err = strconv.Atoi(v); err != nil || *n < 1 {
+					SendJSON(w, http.StatusBadRequest, JSONResponse{Error: "Invalid revision"})
+					return
+				}
+			}
+		}
+
+		b, err := d.Drafts.Revision(r.Context(), id, to)
+		if !draftError(w, err) {
+			return
+		}
+		if from == 0 {
+			from = max(b.Number-1, 1)
+		}
+		a, err := d.Drafts.Revision(r.Context(), id, from)
+		if !draftError(w, err) {
+			return
+		}
+
+		SendJSON(w, http.StatusOK, JSONResponse{Success: true, CampaignID: id, Diff: diffRevisions(a, b)})
+	}
+}
+
+func diffRevisions(a, b *store.Revision) *RevisionDiff {
+	diff := &RevisionDiff{From: a.Number, To: b.Number, Changes: []FieldChange{}}
+	fields := []struct{ name, from, to string }{
+		{"subject", a.Subject, b.Subject},
+		{"preheader", a.Preheader, b.Preheader},
+		{"theme", a.Theme, b.Theme},
+		{"list", a.Audience.List, b.Audience.List},
+		{"segment", a.Audience.Segment, b.Audience.Segment},
+		{"digest", strconv.FormatBool(a.Audience.Digest), strconv.FormatBool(b.Audience.Digest)},
+		{"tags", strings.Join(a.Tags, ", "), strings.Join(b.Tags, ", ")},
+		{"utm_campaign", a.UTMCampaign, b.UTMCampaign},
+		{"trusted_html", strconv.FormatBool(a.TrustedHTML), strconv.FormatBool(b.TrustedHTML)},
+	}
+	for _, f := range fields {
+		if f.from != f.to {
+			diff.Changes = append(diff.Changes, FieldChange{Field: f.name, From: f.from, To: f.to})
+		}
+	}
+	diff.Body = utils.UnifiedDiff(fmt.Sprintf("revision %d", a.Number), fmt.Sprintf("revision %d", b.Number), a.Body, b.Body)
+	return diff
+}
+
+// SendCampaignHandler serves POST /campaigns/{id}/send, which queues the
+// latest revision of a draft. ?force=true skips the duplicate check.
+func SendCampaignHandler(d *Deps) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			SendJSON(w, http.StatusMethodNotAllowed, JSONResponse{Error: "Method not allowed"})
+			return
+		}
+
+		if !requireSecret(w, r) {
+			return
+		}
+
+		id, ok := campaignID(w, r)
+		if !ok {
+			return
+		}
+		sendDraft(w, r, d, id, r.URL.Query().Get("force") == "true")
+	}
+}
+
+// sendDraft renders the latest revision of draft id and queues it. It
+// writes the JSON response itself.
+func sendDraft(w http.ResponseWriter, r *http.Request, d *Deps, id int64, force bool) {
+	rev, rendered, ok := renderDraft(w, r, d, id)
+	if !ok {
+		return
+	}
+	if !force && !notDuplicate(w, r, d, rev.Subject, rendered.HTML, rev.Audience, time.Now(), id) {
+		return
+	}
+
+	count, err := d.Drafts.SendDraft(r.Context(), id, rev.Number, rev.Subject, rendered.HTML, rendered.Text, rev.Audience)
+	if errors.Is(err, store.ErrNotFound) {
+		SendJSON(w, http.StatusNotFound, JSONResponse{Error: "List or segment not found"})
+		return
+	}
+	if errors.Is(err, store.ErrConflict) {
+		SendJSON(w, http.StatusConflict, JSONResponse{Error: "Campaign was sent or edited in the meantime"})
+		return
+	}
+	if err != nil {
+		SendJSON(w, http.StatusInternalServerError, JSONResponse{Error: "Database error occurred"})
+		return
+	}
+
+	sendQueued(w, d, id, count, rendered.Stripped)
+}
+
+// renderDraft renders the latest revision of draft id. It writes the
+// error response itself.
+func renderDraft(w http.ResponseWriter, r *http.Request, d *Deps, id int64) (*store.Revision, *newsletter, bool) {
+	rev, err := d.Drafts.Revision(r.Context(), id, 0)
+	if !draftError(w, err) {
+		return nil, nil, false
+	}
+	if rev.Subject == "" || rev.Body == "" {
+		SendJSON(w, http.StatusBadRequest, JSONResponse{Error: "Subject and body are required"})
+		return nil, nil, false
+	}
+
+	rendered, err := renderNewsletter(r.Context(), d, rev.Draft)
+	if err != nil {
+		var invalid *invalidTemplateError
+		if errors.As(err, &invalid) {
+			SendJSON(w, http.StatusBadRequest, JSONResponse{Error: err.Error()})
+		} else {
+			SendJSON(w, http.StatusInternalServerError, JSONResponse{Error: "Database error occurred"})
+		}
+		return nil, nil, false
+	}
+	return rev, rendered, true
+}
+
+// draftError writes the response for a failed draft operation and reports
+// whether err was nil.
+func draftError(w http.ResponseWriter, err error) bool {
+	switch {
+	case err == nil:
+		return true
+	case errors.Is(err, store.ErrNotFound):
+		SendJSON(w, http.StatusNotFound, JSONResponse{Error: "Campaign or revision not found"})
+	case errors.Is(err, store.ErrConflict):
+		SendJSON(w, http.StatusConflict, JSONResponse{Error: "Campaign is scheduled or has already been sent"})
+	default:
+		SendJSON(w, http.StatusInternalServerError, JSONResponse{Error: "Database error occurred"})
+	}
+	return false
+}
@@ -0,0 +1,123 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pixperk/newsletter/store"
+	"github.com/pixperk/newsletter/templates"
+	"github.com/pixperk/newsletter/utils"
+)
+
+const testSecret = "test-secret"
+
+// outbox is an EmailSender that keeps every message.
+type outbox struct {
+	mu   sync.Mutex
+	sent []utils.Message
+}
+
+func (o *outbox) Send(ctx context.Context, msg utils.Message) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.sent = append(o.sent, msg)
+	return nil
+}
+
+func (o *outbox) messages() []utils.Message {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return append([]utils.Message(nil), o.sent...)
+}
+
+// countingNotifier records how often new deliveries were announced.
+type countingNotifier struct{ n int }
+
+func (c *countingNotifier) Notify() { c.n++ }
+
+// newTestDeps wires the handlers to an in-memory store and an outbox.
+func newTestDeps(t *testing.T) (*Deps, *store.Memory, *outbox) {
+	t.Helper()
+	t.Setenv("SEND_SECRET", testSecret)
+	t.Setenv("UNSUBSCRIBE_SECRET", "unsubscribe-secret")
+	t.Setenv("PUBLIC_URL", "https://news.example.com")
+
+	themes, err := templates.Load("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	st := store.NewMemory()
+	mail := &outbox{}
+	d := &Deps{
+		Subscribers:   st,
+		Verifications: st,
+		Campaigns:     st,
+		Drafts:        st,
+		Lists:         st,
+		Idempotency:   st,
+		Events:        st,
+		Sender:        mail,
+		Queue:         &countingNotifier{},
+		Policy:        PolicyDouble,
+		Bounces:       BouncePolicy{HardBounceLimit: 1, SoftBounceLimit: 3, SoftWindow: 30 * 24 * time.Hour, SuppressBlocked: true},
+		Sends:         SendPolicy{DuplicateWindow: 24 * time.Hour},
+		Themes:        themes,
+	}
+	return d, st, mail
+}
+
+// request is a call to a handler.
+type request struct {
+	method  string
+	target  string
+	body    string
+	headers map[string]string
+	// admin adds the X-Secret header.
+	admin bool
+	// pattern is the route, for handlers that read path values.
+	pattern string
+}
+
+// serve runs h for req and returns the recorder and the decoded JSON
+// response, which is zero for other content types.
+func serve(t *testing.T, h http.HandlerFunc, req request) (*httptest.ResponseRecorder, JSONResponse) {
+	t.Helper()
+	var body io.Reader
+	if req.body != "" {
+		body = strings.NewReader(req.body)
+	}
+	r := httptest.NewRequest(req.method, req.target, body)
+	if req.body != "" {
+		r.Header.Set("Content-Type", "application/json")
+	}
+	for k, v := range req.headers {
+		r.Header.Set(k, v)
+	}
+	if req.admin {
+		r.Header.Set("X-Secret", testSecret)
+	}
+
+	w := httptest.NewRecorder()
+	if req.pattern != "" {
+		mux := http.NewServeMux()
+		mux.HandleFunc(req.pattern, h)
+		mux.ServeHTTP(w, r)
+	} else {
+		h(w, r)
+	}
+
+	var resp JSONResponse
+	if strings.HasPrefix(w.Header().Get("Content-Type"), "application/json") {
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("decode response %q: %v", w.Body.String(), err)
+		}
+	}
+	return w, resp
+}
@@ -0,0 +1,116 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/pixperk/newsletter/store"
+)
+
+const (
+	maxIdempotencyKeyLength = 255
+	// idempotencyStale is how long a request may hold its key without a
+	// response before a retry takes the key over, e.g. after a crash.
+	idempotencyStale = 5 * time.Minute
+)
+
+// withIdempotencyKey handles a request at most once per Idempotency-Key
+// header. A retry with the same key and request gets the recorded response
+// back; reusing a key for a different request is refused. Server errors are
+// not recorded so the request can be retried. Requests without the header
+// are simply handled.
+func withIdempotencyKey(w http.ResponseWriter, r *http.Request, d *Deps, body []byte, handle func(http.ResponseWriter)) {
+	key := strings.TrimSpace(r.Header.Get("Idempotency-Key"))
+	if key == "" {
+		handle(w)
+		return
+	}
+	if len(key) > maxIdempotencyKeyLength {
+		SendJSON(w, http.StatusBadRequest, JSONResponse{Error: "Idempotency-Key must be at most 255 characters"})
+		return
+	}
+
+	hash := requestHash(r, body)
+
+	prev, err := d.Idempotency.ReserveIdempotencyKey(r.Context(), key, hash, idempotencyStale)
+	if errors.Is(err, store.ErrConflict) {
+		SendJSON(w, http.StatusConflict, JSONResponse{Error: "A request with this Idempotency-Key is still being processed"})
+		return
+	}
+	if err != nil {
+		SendJSON(w, http.StatusInternalServerError, JSONResponse{Error: "Database error occurred"})
+		return
+	}
+	if prev != nil {
+		switch {
+		case prev.RequestHash != hash:
+			SendJSON(w, http.StatusUnprocessableEntity, JSONResponse{Error: "Idempotency-Key has already been used for a different request"})
+		case prev.Status == 0:
+			SendJSON(w, http.StatusConflict, JSONResponse{Error: "A request with this Idempotency-Key is still being processed"})
+		default:
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("Idempotent-Replayed", "true")
+			w.WriteHeader(prev.Status)
+			w.Write(prev.Response)
+		}
+		return
+	}
+
+	rec := &responseRecorder{ResponseWriter: w}
+	handle(rec)
+
+	// Record the outcome even if the client has gone away; that is exactly
+	// when it will retry.
+	ctx := context.WithoutCancel(r.Context())
+	if rec.status >= http.StatusInternalServerError {
+		if err := d.Idempotency.ReleaseIdempotencyKey(ctx, key); err != nil {
+			log.Printf("idempotency: failed to release key %q: %v", key, err)
+		}
+		return
+	}
+	var result struct {
+		CampaignID int64 `json:"campaign_id"`
+	}
+	json.Unmarshal(rec.body.Bytes(), &result)
+	if err := d.Idempotency.CompleteIdempotencyKey(ctx, key, rec.status, rec.body.Bytes(), result.CampaignID); err != nil {
+		log.Printf("idempotency: failed to record response for key %q: %v", key, err)
+	}
+}
+
+// requestHash identifies a request by its method, path, query parameters
+// (such as force) and body.
+func requestHash(r *http.Request, body []byte) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s %s?%s\n", r.Method, r.URL.Path, r.URL.Query().Encode())
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// responseRecorder passes a response through and keeps a copy.
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (rr *responseRecorder) WriteHeader(status int) {
+	rr.status = status
+	rr.ResponseWriter.WriteHeader(status)
+}
+
+func (rr *responseRecorder) Write(b []byte) (int, error) {
+	if rr.status == 0 {
+		rr.status = http.StatusOK
+	}
+	rr.body.Write(b)
+	return rr.ResponseWriter.Write(b)
+}
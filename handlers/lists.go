@@ -0,0 +1,179 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/pixperk/newsletter/store"
+)
+
+var slugPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,63}$`)
+
+// ListInfo is a list as returned by the admin API.
+type ListInfo struct {
+	Slug        string    `json:"slug"`
+	Name        string    `json:"name"`
+	Description string    `json:"description,omitempty"`
+	Public      bool      `json:"public"`
+	Members     int       `json:"members"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+func newListInfo(l store.List) ListInfo {
+	return ListInfo{
+		Slug:        l.Slug,
+		Name:        l.Name,
+		Description: l.Description,
+		Public:      l.Public,
+		Members:     l.Members,
+		CreatedAt:   l.CreatedAt,
+	}
+}
+
+type CreateListRequest struct {
+	Slug        string `json:"slug"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Public      bool   `json:"public"`
+}
+
+// ListsHandler serves GET /lists and POST /lists.
+func ListsHandler(d *Deps) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodPost {
+			SendJSON(w, http.StatusMethodNotAllowed, JSONResponse{Error: "Method not allowed"})
+			return
+		}
+
+		if !requireSecret(w, r) {
+			return
+		}
+
+		if r.Method == http.MethodGet {
+			lists, err := d.Lists.Lists(r.Context())
+			if err != nil {
+				SendJSON(w, http.StatusInternalServerError, JSONResponse{Error: "Database error occurred"})
+				return
+			}
+			infos := make([]ListInfo, 0, len(lists))
+			for _, l := range lists {
+				infos = append(infos, newListInfo(l))
+			}
+			SendJSON(w, http.StatusOK, JSONResponse{Success: true, Lists: infos})
+			return
+		}
+
+		var req CreateListRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			SendJSON(w, http.StatusBadRequest, JSONResponse{Error: "Invalid JSON request"})
+			return
+		}
+
+		slug := strings.TrimSpace(req.Slug)
+		name := strings.TrimSpace(req.Name)
+		if !slugPattern.MatchString(slug) {
+			SendJSON(w, http.StatusBadRequest, JSONResponse{Error: "Slug must be lowercase letters, digits and dashes"})
+			return
+		}
+		if name == "" {
+			SendJSON(w, http.StatusBadRequest, JSONResponse{Error: "Name is required"})
+			return
+		}
+
+		l, err := d.Lists.CreateList(r.Context(), store.List{
+			Slug:        slug,
+			Name:        name,
+			Description: strings.TrimSpace(req.Description),
+			Public:      req.Public,
+		})
+		if errors.Is(err, store.ErrConflict) {
+			SendJSON(w, http.StatusConflict, JSONResponse{Error: "A list with this slug already exists"})
+			return
+		}
+		if err != nil {
+			SendJSON(w, http.StatusInternalServerError, JSONResponse{Error: "Database error occurred"})
+			return
+		}
+
+		info := newListInfo(*l)
+		SendJSON(w, http.StatusCreated, JSONResponse{Success: true, List: &info})
+	}
+}
+
+// ListHandler serves GET and DELETE /lists/{slug}.
+func ListHandler(d *Deps) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodDelete {
+			SendJSON(w, http.StatusMethodNotAllowed, JSONResponse{Error: "Method not allowed"})
+			return
+		}
+
+		if !requireSecret(w, r) {
+			return
+		}
+
+		slug := r.PathValue("slug")
+		if r.Method == http.MethodDelete {
+			err := d.Lists.DeleteList(r.Context(), slug)
+			if errors.Is(err, store.ErrNotFound) {
+				SendJSON(w, http.StatusNotFound, JSONResponse{Error: "List not found"})
+				return
+			}
+			if err != nil {
+				SendJSON(w, http.StatusInternalServerError, JSONResponse{Error: "Database error occurred"})
+				return
+			}
+			SendJSON(w, http.StatusOK, JSONResponse{Success: true, Message: "List deleted"})
+			return
+		}
+
+		l, err := d.Lists.List(r.Context(), slug)
+		if errors.Is(err, store.ErrNotFound) {
+			SendJSON(w, http.StatusNotFound, JSONResponse{Error: "List not found"})
+			return
+		}
+		if err != nil {
+			SendJSON(w, http.StatusInternalServerError, JSONResponse{Error: "Database error occurred"})
+			return
+		}
+
+		info := newListInfo(*l)
+		SendJSON(w, http.StatusOK, JSONResponse{Success: true, List: &info})
+	}
+}
+
+// publicLists checks that every slug names a list subscribers may join
+// themselves and returns them without duplicates.
+func publicLists(r *http.Request, d *Deps, slugs []string) ([]string, error) {
+	if len(slugs) == 0 {
+		return nil, nil
+	}
+	lists, err := d.Lists.Lists(r.Context())
+	if err != nil {
+		return nil, err
+	}
+	var out []string
+	for _, slug := range slugs {
+		slug = strings.TrimSpace(slug)
+		if !slices.ContainsFunc(lists, func(l store.List) bool { return l.Slug == slug && l.Public }) {
+			return nil, &unknownListError{slug}
+		}
+		if !slices.Contains(out, slug) {
+			out = append(out, slug)
+		}
+	}
+	return out, nil
+}
+
+type unknownListError struct {
+	slug string
+}
+
+func (e *unknownListError) Error() string {
+	return "Unknown list: " + e.slug
+}
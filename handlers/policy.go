@@ -0,0 +1,109 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/pixperk/newsletter/store"
+)
+
+// SubscriptionPolicy decides what the direct /subscribe endpoint does.
+type SubscriptionPolicy string
+
+const (
+	// PolicyDouble makes /subscribe send the verification email, exactly
+	// like /subscribe/verify. This is the default.
+	PolicyDouble SubscriptionPolicy = "double"
+	// PolicyVerifyOnly disables /subscribe; only /subscribe/verify works.
+	PolicyVerifyOnly SubscriptionPolicy = "verify-only"
+	// PolicySingle adds subscribers immediately without confirmation.
+	PolicySingle SubscriptionPolicy = "single"
+)
+
+// SendPolicy guards /send against mailing the same issue twice.
+type SendPolicy struct {
+	// DuplicateWindow is how long an identical subject and body may not be
+	// sent to the same audience again without force. Zero disables the
+	// check.
+	DuplicateWindow time.Duration
+}
+
+// SendPolicyFromEnv reads DUPLICATE_SEND_WINDOW, a duration that defaults
+// to 24h.
+func SendPolicyFromEnv() (SendPolicy, error) {
+	p := SendPolicy{DuplicateWindow: 24 * time.Hour}
+	if v := os.Getenv("DUPLICATE_SEND_WINDOW"); v != "" {
+		var err error
+		if p.DuplicateWindow, err = time.ParseDuration(v); err != nil || p.DuplicateWindow < 0 {
+			return p, fmt.Errorf("invalid DUPLICATE_SEND_WINDOW %q", v)
+		}
+	}
+	return p, nil
+}
+
+// TestSendPolicy decides who may receive test sends.
+type TestSendPolicy struct {
+	// Recipients are always allowed and receive test sends that name no
+	// recipients.
+	Recipients []string
+	// List is the slug of the internal testers list; its members are
+	// allowed as well.
+	List string
+}
+
+// TestSendPolicyFromEnv reads TEST_RECIPIENTS, a comma-separated list of
+// addresses, and TEST_LIST, which defaults to internal-testers.
+func TestSendPolicyFromEnv() (TestSendPolicy, error) {
+	p := TestSendPolicy{List: "internal-testers"}
+	if v := strings.TrimSpace(os.Getenv("TEST_LIST")); v != "" {
+		p.List = v
+	}
+	for _, email := range strings.Split(os.Getenv("TEST_RECIPIENTS"), ",") {
+		if email = strings.TrimSpace(email); email == "" {
+			continue
+		}
+		if !strings.Contains(email, "@") {
+			return p, fmt.Errorf("invalid TEST_RECIPIENTS address %q", email)
+		}
+		p.Recipients = append(p.Recipients, email)
+	}
+	return p, nil
+}
+
+// allows reports whether email may receive test sends.
+func (p TestSendPolicy) allows(sub *store.Subscriber, email string) bool {
+	for _, r := range p.Recipients {
+		if strings.EqualFold(r, email) {
+			return true
+		}
+	}
+	return sub != nil && p.List != "" && slices.Contains(sub.Lists, p.List)
+}
+
+// SubscriptionPolicyFromEnv reads SUBSCRIPTION_POLICY.
+func SubscriptionPolicyFromEnv() (SubscriptionPolicy, error) {
+	switch p := SubscriptionPolicy(strings.ToLower(strings.TrimSpace(os.Getenv("SUBSCRIPTION_POLICY")))); p {
+	case "":
+		return PolicyDouble, nil
+	case PolicyDouble, PolicyVerifyOnly, PolicySingle:
+		return p, nil
+	default:
+		return "", fmt.Errorf("unknown SUBSCRIPTION_POLICY %q", p)
+	}
+}
+
+func consentFrom(r *http.Request, source, fallback string) store.Consent {
+	source = strings.TrimSpace(source)
+	if source == "" {
+		source = fallback
+	}
+	return store.Consent{
+		IP:        GetRemoteIP(r),
+		UserAgent: r.UserAgent(),
+		Source:    source,
+	}
+}
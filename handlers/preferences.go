@@ -0,0 +1,421 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"html/template"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pixperk/newsletter/store"
+	"github.com/pixperk/newsletter/utils"
+)
+
+const (
+	// preferencesTTL is how long a preference center link stays usable.
+	preferencesTTL = 7 * 24 * time.Hour
+	maxPauseWeeks  = 52
+)
+
+// Preferences is what the preference center shows a subscriber.
+type Preferences struct {
+	Email       string           `json:"email"`
+	FirstName   string           `json:"first_name"`
+	LastName    string           `json:"last_name"`
+	Frequency   string           `json:"frequency"`
+	PausedUntil *time.Time       `json:"paused_until,omitempty"`
+	Lists       []PreferenceList `json:"lists"`
+}
+
+// PreferenceList is a public list and whether the subscriber is on it.
+type PreferenceList struct {
+	Slug        string `json:"slug"`
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	Subscribed  bool   `json:"subscribed"`
+}
+
+type PreferencesLinkRequest struct {
+	Email string `json:"email"`
+}
+
+// PreferencesUpdateRequest changes a subscriber's preferences. Omitted
+// fields are left alone. Lists replaces the public lists the subscriber is
+// on; memberships of non-public lists are kept. PauseWeeks pauses delivery
+// for that many weeks and Resume lifts a pause. UnsubscribeAll ignores
+// everything else.
+type PreferencesUpdateRequest struct {
+	FirstName      *string   `json:"first_name"`
+	LastName       *string   `json:"last_name"`
+	Lists          *[]string `json:"lists"`
+	Frequency      *string   `json:"frequency"`
+	PauseWeeks     int       `json:"pause_weeks"`
+	Resume         bool      `json:"resume"`
+	UnsubscribeAll bool      `json:"unsubscribe_all"`
+}
+
+// PreferencesLinkHandler mails an active subscriber a link to the
+// preference center. The response is the same whether or not the address
+// is subscribed so it cannot be used to probe the list.
+func PreferencesLinkHandler(d *Deps) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			SendJSON(w, http.StatusMethodNotAllowed, JSONResponse{Error: "Method not allowed"})
+			return
+		}
+
+		var req PreferencesLinkRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			SendJSON(w, http.StatusBadRequest, JSONResponse{Error: "Invalid JSON request"})
+			return
+		}
+
+		email := strings.TrimSpace(req.Email)
+		if email == "" {
+			SendJSON(w, http.StatusBadRequest, JSONResponse{Error: "Email is required"})
+			return
+		}
+
+		sub, err := d.Subscribers.Subscriber(r.Context(), email)
+		if err != nil && !errors.Is(err, store.ErrNotFound) {
+			SendJSON(w, http.StatusInternalServerError, JSONResponse{Error: "Database error occurred"})
+			return
+		}
+
+		if sub != nil && sub.Status == store.StatusActive && sub.Suppression == "" {
+			token, err := generateVerificationToken()
+			if err != nil {
+				SendJSON(w, http.StatusInternalServerError, JSONResponse{Error: "Failed to generate verification token"})
+				return
+			}
+
+			err = d.Verifications.SaveVerification(r.Context(), store.Verification{
+				Email:     sub.Email,
+				Token:     token,
+				Purpose:   store.PurposePreferences,
+				ExpiresAt: time.Now().Add(preferencesTTL),
+				Consent:   consentFrom(r, "", "preferences"),
+			})
+			if err != nil {
+				SendJSON(w, http.StatusInternalServerError, JSONResponse{Error: "Database error occurred"})
+				return
+			}
+
+			var body bytes.Buffer
+			preferencesEmailTmpl.Execute(&body, utils.PublicURL()+"/preferences/"+token)
+			msg := utils.Message{To: sub.Email, ToName: sub.Profile.Name(), Subject: "Manage your newsletter preferences", HTML: body.String()}
+			if err := d.Sender.Send(r.Context(), msg); err != nil {
+				SendJSON(w, http.StatusInternalServerError, JSONResponse{Error: "Failed to send preferences email: " + err.Error()})
+				return
+			}
+		}
+
+		SendJSON(w, http.StatusOK, JSONResponse{Success: true, Message: "If that address is subscribed, a link to manage your preferences is on its way."})
+	}
+}
+
+// PreferencesHandler serves /preferences/{token}. GET shows the current
+// preferences, POST or PUT changes them. Requests and responses are JSON
+// when the client asks for it and an HTML form otherwise, so the link
+// works straight from the email.
+func PreferencesHandler(d *Deps) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		asJSON := strings.Contains(r.Header.Get("Accept"), "application/json") ||
+			strings.HasPrefix(r.Header.Get("Content-Type"), "application/json")
+		fail := func(status int, msg string) {
+			if asJSON {
+				SendJSON(w, status, JSONResponse{Error: msg})
+				return
+			}
+			renderPreferencesPage(w, status, preferencesPage{Title: "Preferences", Text: msg})
+		}
+
+		if r.Method != http.MethodGet && r.Method != http.MethodPost && r.Method != http.MethodPut {
+			fail(http.StatusMethodNotAllowed, "Method not allowed")
+			return
+		}
+
+		v, err := d.Verifications.Verification(r.Context(), r.PathValue("token"))
+		if err == nil && v.Purpose != store.PurposePreferences {
+			err = store.ErrNotFound
+		}
+		if errors.Is(err, store.ErrNotFound) {
+			fail(http.StatusNotFound, "This preferences link is invalid. Request a new one to continue.")
+			return
+		}
+		if err != nil {
+			fail(http.StatusInternalServerError, "Database error occurred")
+			return
+		}
+		if time.Now().After(v.ExpiresAt) {
+			fail(http.StatusGone, "This preferences link has expired. Request a new one to continue.")
+			return
+		}
+
+		sub, err := d.Subscribers.Subscriber(r.Context(), v.Email)
+		if err == nil && (sub.Status != store.StatusActive || sub.Suppression != "") {
+			err = store.ErrNotFound
+		}
+		if errors.Is(err, store.ErrNotFound) {
+			fail(http.StatusGone, v.Email+" is no longer subscribed to the newsletter.")
+			return
+		}
+		if err != nil {
+			fail(http.StatusInternalServerError, "Database error occurred")
+			return
+		}
+
+		lists, err := d.Lists.Lists(r.Context())
+		if err != nil {
+			fail(http.StatusInternalServerError, "Database error occurred")
+			return
+		}
+
+		message := ""
+		if r.Method != http.MethodGet {
+			var req PreferencesUpdateRequest
+			if asJSON {
+				if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+					fail(http.StatusBadRequest, "Invalid JSON request")
+					return
+				}
+			} else if req, err = preferencesForm(r); err != nil {
+				fail(http.StatusBadRequest, err.Error())
+				return
+			}
+
+			if req.UnsubscribeAll {
+				if _, err := d.Subscribers.Suppress(r.Context(), sub.Email, store.StatusUnsubscribed, "preference center"); err != nil {
+					fail(http.StatusInternalServerError, "Database error occurred")
+					return
+				}
+				if asJSON {
+					SendJSON(w, http.StatusOK, JSONResponse{Success: true, Message: "Successfully unsubscribed from newsletter 👋"})
+					return
+				}
+				renderPreferencesPage(w, http.StatusOK, preferencesPage{Title: "Unsubscribed", Text: sub.Email + " will no longer receive the newsletter."})
+				return
+			}
+
+			u, err := req.update(sub, lists)
+			if err != nil {
+				fail(http.StatusBadRequest, err.Error())
+				return
+			}
+			if sub, err = d.Subscribers.UpdateProfile(r.Context(), sub.Email, u); err != nil {
+				fail(http.StatusInternalServerError, "Database error occurred")
+				return
+			}
+			message = "Your preferences have been saved."
+		}
+
+		prefs := newPreferences(sub, lists)
+		if asJSON {
+			SendJSON(w, http.StatusOK, JSONResponse{Success: true, Message: message, Preferences: prefs})
+			return
+		}
+		renderPreferencesPage(w, http.StatusOK, preferencesPage{Title: "Your preferences", Text: message, Prefs: prefs})
+	}
+}
+
+func newPreferences(s *store.Subscriber, lists []store.List) *Preferences {
+	p := &Preferences{
+		Email:     s.Email,
+		FirstName: s.Profile.FirstName,
+		LastName:  s.Profile.LastName,
+		Frequency: s.Frequency,
+		Lists:     []PreferenceList{},
+	}
+	if s.Paused() {
+		p.PausedUntil = s.PausedUntil
+	}
+	for _, l := range lists {
+		if !l.Public {
+			continue
+		}
+		p.Lists = append(p.Lists, PreferenceList{
+			Slug:        l.Slug,
+			Name:        l.Name,
+			Description: l.Description,
+			Subscribed:  slices.Contains(s.Lists, l.Slug),
+		})
+	}
+	return p
+}
+
+// preferencesForm reads the HTML form. Unchecked checkboxes are not
+// submitted, so the lists field is always taken as the complete set.
+func preferencesForm(r *http.Request) (PreferencesUpdateRequest, error) {
+	var req PreferencesUpdateRequest
+	if err := r.ParseForm(); err != nil {
+		return req, errors.New("invalid form submission")
+	}
+	switch r.PostForm.Get("action") {
+	case "unsubscribe_all":
+		req.UnsubscribeAll = true
+		return req, nil
+	case "resume":
+		req.Resume = true
+		return req, nil
+	}
+
+	first, last, lists := r.PostForm.Get("first_name"), r.PostForm.Get("last_name"), r.PostForm["lists"]
+	req.FirstName, req.LastName, req.Lists = &first, &last, &lists
+	if frequency := r.PostForm.Get("frequency"); frequency != "" {
+		req.Frequency = &frequency
+	}
+	if weeks := r.PostForm.Get("pause_weeks"); weeks != "" {
+		n, err := strconv.Atoi(weeks)
+		if err != nil {
+			return req, errors.New("pause_weeks must be a number")
+		}
+		req.PauseWeeks = n
+	}
+	return req, nil
+}
+
+// update turns the request into a profile update for s. Only public lists
+// can be joined or left here.
+func (req PreferencesUpdateRequest) update(s *store.Subscriber, lists []store.List) (store.ProfileUpdate, error) {
+	var u store.ProfileUpdate
+	if req.FirstName != nil {
+		name := strings.TrimSpace(*req.FirstName)
+		if err := validateName("first_name", name); err != nil {
+			return u, err
+		}
+		u.FirstName = &name
+	}
+	if req.LastName != nil {
+		name := strings.TrimSpace(*req.LastName)
+		if err := validateName("last_name", name); err != nil {
+			return u, err
+		}
+		u.LastName = &name
+	}
+	if req.Frequency != nil {
+		switch *req.Frequency {
+		case store.FrequencyEvery, store.FrequencyMonthly:
+			u.Frequency = req.Frequency
+		default:
+			return u, errors.New("frequency must be every or monthly")
+		}
+	}
+	if req.Lists != nil {
+		public := func(slug string) bool {
+			return slices.ContainsFunc(lists, func(l store.List) bool { return l.Slug == slug && l.Public })
+		}
+		var keep []string
+		for _, slug := range s.Lists {
+			if !public(slug) {
+				keep = append(keep, slug)
+			}
+		}
+		for _, slug := range *req.Lists {
+			slug = strings.TrimSpace(slug)
+			if !public(slug) {
+				return u, &unknownListError{slug}
+			}
+			if !slices.Contains(keep, slug) {
+				keep = append(keep, slug)
+			}
+		}
+		u.Lists = &keep
+	}
+	switch {
+	case req.PauseWeeks < 0 || req.PauseWeeks > maxPauseWeeks:
+		return u, errors.New("pause_weeks must be between 1 and 52")
+	case req.PauseWeeks > 0:
+		until := time.Now().Add(time.Duration(req.PauseWeeks) * 7 * 24 * time.Hour)
+		u.PausedUntil = &until
+	case req.Resume:
+		u.PausedUntil = &time.Time{}
+	}
+	return u, nil
+}
+
+type preferencesPage struct {
+	Title string
+	Text  string
+	Prefs *Preferences
+}
+
+var preferencesTmpl = template.Must(template.New("preferences").Parse(`<!doctype html>
+<html lang="en">
+<head>
+  <meta charset="UTF-8">
+  <meta name="viewport" content="width=device-width,initial-scale=1.0">
+  <title>{{.Title}} · pixperk</title>
+</head>
+<body style="margin:0;padding:40px 16px;background-color:#0a0a0a;font-family:-apple-system,BlinkMacSystemFont,'Segoe UI',Inter,Roboto,Helvetica,Arial,sans-serif;color:#9a9a9a;font-size:15px;line-height:1.75;">
+  <div style="max-width:480px;margin:0 auto;background-color:#111111;border:1px solid rgba(255,255,255,0.06);border-radius:14px;padding:36px;">
+    <h1 style="margin:0 0 16px;font-size:21px;font-weight:600;color:#ececec;">{{.Title}}</h1>
+    {{with .Text}}<p style="margin:0 0 24px;">{{.}}</p>{{end}}
+    {{with .Prefs}}
+    <p style="margin:0 0 24px;">Newsletter settings for <strong style="color:#d4d4d4;">{{.Email}}</strong>.</p>
+    {{if .PausedUntil}}
+    <form method="post" style="margin:0 0 24px;">
+      <p style="margin:0 0 12px;">Delivery is paused until {{.PausedUntil.Format "January 2, 2006"}}.</p>
+      <button type="submit" name="action" value="resume" style="border:none;border-radius:8px;background-color:#e0e0e0;color:#0a0a0a;padding:10px 24px;font-size:14px;font-weight:600;cursor:pointer;">Resume now</button>
+    </form>
+    {{end}}
+    <form method="post">
+      <label style="display:block;margin:0 0 4px;color:#d4d4d4;font-size:13px;">First name</label>
+      <input type="text" name="first_name" value="{{.FirstName}}" style="box-sizing:border-box;width:100%;margin:0 0 12px;padding:10px 12px;border-radius:8px;border:1px solid rgba(255,255,255,0.1);background-color:#0a0a0a;color:#d4d4d4;font-size:14px;">
+      <label style="display:block;margin:0 0 4px;color:#d4d4d4;font-size:13px;">Last name</label>
+      <input type="text" name="last_name" value="{{.LastName}}" style="box-sizing:border-box;width:100%;margin:0 0 20px;padding:10px 12px;border-radius:8px;border:1px solid rgba(255,255,255,0.1);background-color:#0a0a0a;color:#d4d4d4;font-size:14px;">
+      {{if .Lists}}
+      <p style="margin:0 0 8px;color:#d4d4d4;font-size:13px;">Lists</p>
+      {{range .Lists}}
+      <label style="display:block;margin:0 0 8px;"><input type="checkbox" name="lists" value="{{.Slug}}"{{if .Subscribed}} checked{{end}}> {{.Name}}{{with .Description}} <span style="color:#555;font-size:13px;">— {{.}}</span>{{end}}</label>
+      {{end}}
+      {{end}}
+      <p style="margin:12px 0 8px;color:#d4d4d4;font-size:13px;">How often</p>
+      <label style="display:block;margin:0 0 8px;"><input type="radio" name="frequency" value="every"{{if eq .Frequency "every"}} checked{{end}}> Every issue</label>
+      <label style="display:block;margin:0 0 20px;"><input type="radio" name="frequency" value="monthly"{{if eq .Frequency "monthly"}} checked{{end}}> Monthly digest</label>
+      <label style="display:block;margin:0 0 4px;color:#d4d4d4;font-size:13px;">Take a break</label>
+      <select name="pause_weeks" style="box-sizing:border-box;width:100%;margin:0 0 24px;padding:10px 12px;border-radius:8px;border:1px solid rgba(255,255,255,0.1);background-color:#0a0a0a;color:#d4d4d4;font-size:14px;">
+        <option value="">Don't pause</option>
+        <option value="1">Pause for 1 week</option>
+        <option value="2">Pause for 2 weeks</option>
+        <option value="4">Pause for 4 weeks</option>
+        <option value="8">Pause for 8 weeks</option>
+      </select>
+      <button type="submit" name="action" value="save" style="border:none;border-radius:8px;background-color:#e0e0e0;color:#0a0a0a;padding:12px 32px;font-size:14px;font-weight:600;cursor:pointer;">Save preferences</button>
+    </form>
+    <form method="post" style="margin:28px 0 0;padding:20px 0 0;border-top:1px solid rgba(255,255,255,0.06);">
+      <button type="submit" name="action" value="unsubscribe_all" style="border:1px solid rgba(255,255,255,0.1);border-radius:8px;background-color:transparent;color:#9a9a9a;padding:10px 24px;font-size:13px;cursor:pointer;">Unsubscribe from everything</button>
+    </form>
+    {{end}}
+  </div>
+</body>
+</html>`))
+
+func renderPreferencesPage(w http.ResponseWriter, statusCode int, page preferencesPage) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(statusCode)
+	preferencesTmpl.Execute(w, page)
+}
+
+var preferencesEmailTmpl = template.Must(template.New("preferences-email").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+  <meta charset="UTF-8">
+  <meta name="viewport" content="width=device-width,initial-scale=1.0">
+</head>
+<body style="margin:0;padding:40px 16px;background-color:#0a0a0a;font-family:-apple-system,BlinkMacSystemFont,'Segoe UI',Inter,Roboto,Helvetica,Arial,sans-serif;">
+  <div style="max-width:560px;margin:0 auto;background-color:#111111;border:1px solid rgba(255,255,255,0.06);border-radius:14px;padding:40px;">
+    <p style="margin:0 0 20px;color:#9a9a9a;font-size:15px;line-height:1.75;">
+      use the button below to change your name, pick the lists you're on, switch to a monthly digest, take a break, or unsubscribe from the <strong style="color:#d4d4d4;">pixperk</strong> newsletter.
+    </p>
+    <p style="margin:0 0 28px;text-align:center;">
+      <a href="{{.}}" target="_blank" style="display:inline-block;padding:12px 32px;border-radius:8px;background-color:#e0e0e0;color:#0a0a0a;font-size:14px;font-weight:600;text-decoration:none;">Manage preferences</a>
+    </p>
+    <p style="margin:0 0 6px;color:#555;font-size:12px;line-height:1.6;text-align:center;">This link expires in 7 days.</p>
+    <p style="margin:0;color:#444;font-size:12px;line-height:1.6;text-align:center;">If you didn't request this, you can safely ignore this email.</p>
+  </div>
+</body>
+</html>`))
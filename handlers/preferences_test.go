@@ -0,0 +1,164 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/pixperk/newsletter/store"
+)
+
+var preferencesLink = regexp.MustCompile(`/preferences/([0-9a-f]{64})`)
+
+// preferencesToken requests a preference center link for email and
+// returns its token.
+func preferencesToken(t *testing.T, d *Deps, mail *outbox, email string) string {
+	t.Helper()
+	req := request{method: http.MethodPost, target: "/preferences", body: `{"email": "` + email + `"}`}
+	if w, resp := serve(t, PreferencesLinkHandler(d), req); w.Code != http.StatusOK {
+		t.Fatalf("link: status %d, %+v", w.Code, resp)
+	}
+	sent := mail.messages()
+	if len(sent) == 0 {
+		t.Fatal("no preferences email sent")
+	}
+	m := preferencesLink.FindStringSubmatch(sent[len(sent)-1].HTML)
+	if m == nil {
+		t.Fatalf("no preferences link in %q", sent[len(sent)-1].HTML)
+	}
+	return m[1]
+}
+
+// preferences calls the preference center for token as JSON.
+func preferences(t *testing.T, d *Deps, method, token, body string) (int, JSONResponse) {
+	t.Helper()
+	w, resp := serve(t, PreferencesHandler(d), request{
+		method:  method,
+		target:  "/preferences/" + token,
+		pattern: "/preferences/{token}",
+		body:    body,
+		headers: map[string]string{"Accept": "application/json"},
+	})
+	return w.Code, resp
+}
+
+func TestPreferencesLinkDoesNotRevealSubscribers(t *testing.T) {
+	d, st, mail := newTestDeps(t)
+	subscribe(t, st, "ada@example.com", "bob@example.com")
+	if _, err := st.Suppress(context.Background(), "bob@example.com", store.StatusUnsubscribed, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	var messages []string
+	for _, email := range []string{"ada@example.com", "bob@example.com", "eve@example.com"} {
+		w, resp := serve(t, PreferencesLinkHandler(d), request{method: http.MethodPost, target: "/preferences", body: `{"email": "` + email + `"}`})
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: status %d, %+v", email, w.Code, resp)
+		}
+		messages = append(messages, resp.Message)
+	}
+	if messages[0] != messages[1] || messages[1] != messages[2] {
+		t.Errorf("responses differ by subscription: %q", messages)
+	}
+	if sent := mail.messages(); len(sent) != 1 || sent[0].To != "ada@example.com" {
+		t.Errorf("sent %+v, want one email to the active subscriber", sent)
+	}
+}
+
+func TestPreferencesTokenPurpose(t *testing.T) {
+	d, st, mail := newTestDeps(t)
+	subscribe(t, st, "ada@example.com")
+
+	// A subscription token does not open the preference center...
+	req := request{method: http.MethodPost, target: "/subscribe/verify", body: `{"email": "eve@example.com"}`}
+	if w, resp := serve(t, VerifySubscribeHandler(d), req); w.Code != http.StatusOK {
+		t.Fatalf("subscribe: status %d, %+v", w.Code, resp)
+	}
+	subscribeToken := verificationToken(t, mail, "eve@example.com")
+	if code, resp := preferences(t, d, http.MethodGet, subscribeToken, ""); code != http.StatusNotFound {
+		t.Errorf("subscription token in the preference center: status %d, %+v; want 404", code, resp)
+	}
+
+	// ...and a preferences token does not confirm a subscription.
+	token := preferencesToken(t, d, mail, "ada@example.com")
+	confirm := request{method: http.MethodPost, target: "/verify", body: `{"token": "` + token + `"}`}
+	if w, resp := serve(t, VerifyConfirmHandler(d), confirm); w.Code != http.StatusNotFound {
+		t.Errorf("preferences token at /verify: status %d, %+v; want 404", w.Code, resp)
+	}
+
+	code, resp := preferences(t, d, http.MethodGet, token, "")
+	if code != http.StatusOK || resp.Preferences == nil || resp.Preferences.Email != "ada@example.com" {
+		t.Fatalf("GET: status %d, %+v", code, resp)
+	}
+	if code, resp := preferences(t, d, http.MethodGet, "0000", ""); code != http.StatusNotFound {
+		t.Errorf("unknown token: status %d, %+v; want 404", code, resp)
+	}
+}
+
+func TestPreferencesTokenExpiry(t *testing.T) {
+	d, st, _ := newTestDeps(t)
+	subscribe(t, st, "ada@example.com")
+	err := st.SaveVerification(context.Background(), store.Verification{
+		Email:     "ada@example.com",
+		Token:     "expired",
+		Purpose:   store.PurposePreferences,
+		ExpiresAt: time.Now().Add(-time.Minute),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code, resp := preferences(t, d, http.MethodGet, "expired", ""); code != http.StatusGone {
+		t.Errorf("expired token: status %d, %+v; want 410", code, resp)
+	}
+}
+
+func TestPreferencesAfterUnsubscribing(t *testing.T) {
+	d, st, mail := newTestDeps(t)
+	subscribe(t, st, "ada@example.com")
+	token := preferencesToken(t, d, mail, "ada@example.com")
+
+	if code, resp := preferences(t, d, http.MethodPost, token, `{"unsubscribe_all": true}`); code != http.StatusOK {
+		t.Fatalf("unsubscribe all: status %d, %+v", code, resp)
+	}
+	if got := status(t, st, "ada@example.com"); got != store.StatusUnsubscribed {
+		t.Errorf("status = %q, want unsubscribed", got)
+	}
+
+	// The link stops working once the address is no longer subscribed.
+	if code, resp := preferences(t, d, http.MethodPost, token, `{"first_name": "Ada"}`); code != http.StatusGone {
+		t.Errorf("update after unsubscribing: status %d, %+v; want 410", code, resp)
+	}
+}
+
+func TestPreferencesOnlyPublicLists(t *testing.T) {
+	d, st, mail := newTestDeps(t)
+	ctx := context.Background()
+	for _, l := range []store.List{{Slug: "weekly", Name: "Weekly", Public: true}, {Slug: "staff", Name: "Staff"}} {
+		if _, err := st.CreateList(ctx, l); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := st.AddSubscriber(ctx, "ada@example.com", store.Consent{Source: "test"}, store.Profile{}, []string{"staff"}); err != nil {
+		t.Fatal(err)
+	}
+	token := preferencesToken(t, d, mail, "ada@example.com")
+
+	if code, resp := preferences(t, d, http.MethodPut, token, `{"lists": ["staff"]}`); code != http.StatusBadRequest {
+		t.Errorf("joining a private list: status %d, %+v; want 400", code, resp)
+	}
+
+	code, resp := preferences(t, d, http.MethodPut, token, `{"lists": ["weekly"]}`)
+	if code != http.StatusOK {
+		t.Fatalf("joining a public list: status %d, %+v", code, resp)
+	}
+	sub, err := st.Subscriber(ctx, "ada@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Replacing the public lists keeps the private ones.
+	if len(sub.Lists) != 2 {
+		t.Errorf("lists = %q, want staff kept and weekly joined", sub.Lists)
+	}
+}
@@ -0,0 +1,222 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/pixperk/newsletter/store"
+	"github.com/pixperk/newsletter/utils"
+)
+
+const (
+	// gmailClipSize is the size above which Gmail hides the rest of a
+	// message behind "View entire message".
+	gmailClipSize = 102 * 1024
+	// maxSubjectLength is roughly what mobile inboxes show of a subject.
+	maxSubjectLength = 60
+)
+
+var (
+	imgPattern  = regexp.MustCompile(`(?i)<img\b[^>]*>`)
+	altPattern  = regexp.MustCompile(`(?i)\balt\s*=\s*"[^"]*\S[^"]*"`)
+	srcPattern  = regexp.MustCompile(`(?i)\bsrc\s*=\s*"([^"]*)"`)
+	hrefPattern = regexp.MustCompile(`(?i)<a\b[^>]*\bhref\s*=\s*"([^"]*)"`)
+)
+
+// PreviewRequest is either inline content or a campaign_id, optionally
+// with a revision (the latest by default). Email personalizes the preview
+// as that subscriber.
+type PreviewRequest struct {
+	CampaignID  int64  `json:"campaign_id"`
+	Revision    int    `json:"revision"`
+	Subject     string `json:"subject"`
+	Preheader   string `json:"preheader"`
+	Body        string `json:"body"`
+	Theme       string `json:"theme"`
+	TrustedHTML bool   `json:"trusted_html"`
+	Email       string `json:"email"`
+}
+
+// Preview is a newsletter as a recipient would get it.
+type Preview struct {
+	Subject  string   `json:"subject"`
+	HTML     string   `json:"html"`
+	Text     string   `json:"text"`
+	Size     int      `json:"size"`
+	Email    string   `json:"email,omitempty"`
+	Warnings []string `json:"warnings"`
+	// Stripped lists what sanitizing removed from the HTML.
+	Stripped []string `json:"stripped,omitempty"`
+}
+
+// PreviewHandler serves POST /preview, which renders a newsletter through
+// the same pipeline as /send without sending anything. With ?format=html
+// the response is the HTML itself, for viewing in a browser.
+func PreviewHandler(d *Deps) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			SendJSON(w, http.StatusMethodNotAllowed, JSONResponse{Error: "Method not allowed"})
+			return
+		}
+
+		if !requireSecret(w, r) {
+			return
+		}
+
+		var req PreviewRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			SendJSON(w, http.StatusBadRequest, JSONResponse{Error: "Invalid JSON request"})
+			return
+		}
+
+		n, ok := requestedDraft(w, r, d, req.CampaignID, req.Revision, DraftRequest{Subject: req.Subject, Preheader: req.Preheader, Body: req.Body, Theme: req.Theme, TrustedHTML: req.TrustedHTML})
+		if !ok {
+			return
+		}
+
+		preview, ok := renderPreview(w, r, d, n, req.Email)
+		if !ok {
+			return
+		}
+
+		if r.URL.Query().Get("format") == "html" {
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			w.Write([]byte(preview.HTML))
+			return
+		}
+		SendJSON(w, http.StatusOK, JSONResponse{Success: true, CampaignID: req.CampaignID, Preview: preview})
+	}
+}
+
+// requestedDraft returns the given revision of campaign id, or the inline
+// content, front matter applied, if id is 0. It writes the error response
+// itself.
+func requestedDraft(w http.ResponseWriter, r *http.Request, d *Deps, id int64, revision int, inline DraftRequest) (store.Draft, bool) {
+	var n store.Draft
+	if id != 0 {
+		rev, err := d.Drafts.Revision(r.Context(), id, revision)
+		if !draftError(w, err) {
+			return n, false
+		}
+		n = rev.Draft
+	} else {
+		if _, err := inline.applyFrontMatter(); err != nil {
+			SendJSON(w, http.StatusBadRequest, JSONResponse{Error: err.Error()})
+			return n, false
+		}
+		n = store.Draft{
+			Subject:     strings.TrimSpace(inline.Subject),
+			Preheader:   strings.TrimSpace(inline.Preheader),
+			Body:        inline.Body,
+			Theme:       strings.TrimSpace(inline.Theme),
+			UTMCampaign: strings.TrimSpace(inline.UTMCampaign),
+			TrustedHTML: inline.TrustedHTML,
+		}
+	}
+	if n.Subject == "" || n.Body == "" {
+		SendJSON(w, http.StatusBadRequest, JSONResponse{Error: "Subject and body are required"})
+		return n, false
+	}
+	return n, true
+}
+
+// renderPreview renders n personalized as the subscriber email, or as an
+// anonymous recipient if email is empty. It writes the error response
+// itself.
+func renderPreview(w http.ResponseWriter, r *http.Request, d *Deps, n store.Draft, email string) (*Preview, bool) {
+	rendered, err := renderNewsletter(r.Context(), d, n)
+	if err != nil {
+		var invalid *invalidTemplateError
+		if errors.As(err, &invalid) {
+			SendJSON(w, http.StatusBadRequest, JSONResponse{Error: err.Error()})
+		} else {
+			SendJSON(w, http.StatusInternalServerError, JSONResponse{Error: "Database error occurred"})
+		}
+		return nil, false
+	}
+
+	var contact utils.Contact
+	var warnings []string
+	if email = strings.TrimSpace(email); email != "" {
+		sub, err := d.Subscribers.Subscriber(r.Context(), email)
+		if errors.Is(err, store.ErrNotFound) {
+			SendJSON(w, http.StatusNotFound, JSONResponse{Error: "Subscriber not found"})
+			return nil, false
+		}
+		if err != nil {
+			SendJSON(w, http.StatusInternalServerError, JSONResponse{Error: "Database error occurred"})
+			return nil, false
+		}
+		contact = contactFor(sub)
+		for _, a := range rendered.Template.Attributes() {
+			if v, ok := contact.Attributes[a]; !ok || v == nil || v == "" {
+				warnings = append(warnings, fmt.Sprintf("Merge tag %s is empty for %s", a, email))
+			}
+		}
+	} else if strings.Contains(n.Subject+n.Body, "{{") {
+		warnings = append(warnings, "Not personalized; merge tags show their defaults. Set email to preview as a subscriber")
+	}
+
+	subject, html, text, err := rendered.Template.Render(contact)
+	if err != nil {
+		SendJSON(w, http.StatusBadRequest, JSONResponse{Error: "Invalid merge tags: " + err.Error()})
+		return nil, false
+	}
+
+	return &Preview{
+		Subject:  subject,
+		HTML:     html,
+		Text:     text,
+		Size:     len(html),
+		Email:    email,
+		Warnings: append(warnings, contentWarnings(subject, n.Preheader, html)...),
+		Stripped: rendered.Stripped,
+	}, true
+}
+
+// contentWarnings points out things that commonly go wrong in inboxes.
+func contentWarnings(subject, preheader, html string) []string {
+	warnings := []string{}
+	if n := utf8.RuneCountInString(subject); n > maxSubjectLength {
+		warnings = append(warnings, fmt.Sprintf("Subject is %d characters; many inboxes cut it off after about %d", n, maxSubjectLength))
+	}
+	if preheader == "" {
+		warnings = append(warnings, "No preheader; inboxes will show the start of the email instead")
+	}
+	if len(html) > gmailClipSize {
+		warnings = append(warnings, fmt.Sprintf("Email is %d KB; Gmail clips messages over 102 KB", len(html)/1024))
+	}
+
+	var seen []string
+	for _, img := range imgPattern.FindAllString(html, -1) {
+		if altPattern.MatchString(img) {
+			continue
+		}
+		src := ""
+		if m := srcPattern.FindStringSubmatch(img); m != nil {
+			src = m[1]
+		}
+		warnings = append(warnings, "Image without alt text: "+src)
+	}
+	for _, m := range hrefPattern.FindAllStringSubmatch(html, -1) {
+		href := m[1]
+		scheme, _, _ := strings.Cut(strings.ToLower(href), ":")
+		switch {
+		case slices.Contains(seen, href):
+		case href == "" || strings.HasPrefix(href, "#") || !strings.Contains(href, ":"):
+			warnings = append(warnings, fmt.Sprintf("Link %q is relative and will not work in an email", href))
+		case scheme == "http":
+			warnings = append(warnings, fmt.Sprintf("Link %q is not HTTPS", href))
+		case scheme != "https" && scheme != "mailto" && scheme != "tel":
+			warnings = append(warnings, fmt.Sprintf("Link %q uses an unusual scheme", href))
+		}
+		seen = append(seen, href)
+	}
+	return warnings
+}
@@ -0,0 +1,40 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestFrontMatterPreheaderUsesThemePreheader(t *testing.T) {
+	d, _, _ := newTestDeps(t)
+	body := `{"body": "---\nsubject: Spring issue\npreheader: What is new this spring\n---\n# Hello\n\nBody text."}`
+
+	w, _ := serve(t, PreviewHandler(d), request{method: http.MethodPost, target: "/preview?format=html", body: body, admin: true})
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", w.Code, w.Body)
+	}
+	html := w.Body.String()
+	if n := strings.Count(html, "What is new this spring"); n != 1 {
+		t.Errorf("preheader appears %d times, want once", n)
+	}
+	if !strings.Contains(html, `mso-hide: all`) {
+		t.Error("preheader is not in the theme's hidden preheader block")
+	}
+	if strings.Contains(html, "preheader:") || strings.Contains(html, "<hr") {
+		t.Error("front matter leaked into the body")
+	}
+}
+
+func TestRequestPreheaderWinsOverFrontMatter(t *testing.T) {
+	d, _, _ := newTestDeps(t)
+	body := `{"preheader": "From the request", "body": "+++\nsubject = \"Issue\"\npreheader = \"From front matter\"\n+++\nBody"}`
+
+	_, resp := serve(t, PreviewHandler(d), request{method: http.MethodPost, target: "/preview", body: body, admin: true})
+	if resp.Preview == nil {
+		t.Fatalf("no preview: %+v", resp)
+	}
+	if !strings.Contains(resp.Preview.HTML, "From the request") || strings.Contains(resp.Preview.HTML, "From front matter") {
+		t.Error("front matter preheader replaced the one in the request")
+	}
+}
@@ -0,0 +1,235 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/pixperk/newsletter/store"
+	"github.com/pixperk/newsletter/utils"
+)
+
+const (
+	maxNameLength      = 100
+	maxAttributes      = 50
+	maxAttributeLength = 1000
+	maxTags            = 50
+	maxTagLength       = 64
+)
+
+// attributeKey keeps custom field names usable as template variables.
+var attributeKey = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_]{0,63}$`)
+
+// ProfileFields are the optional profile fields accepted by the subscribe
+// endpoints.
+type ProfileFields struct {
+	FirstName  string         `json:"first_name"`
+	LastName   string         `json:"last_name"`
+	Attributes map[string]any `json:"attributes"`
+}
+
+func (f ProfileFields) profile() (store.Profile, error) {
+	p := store.Profile{
+		FirstName: strings.TrimSpace(f.FirstName),
+		LastName:  strings.TrimSpace(f.LastName),
+	}
+	if err := validateName("first_name", p.FirstName); err != nil {
+		return p, err
+	}
+	if err := validateName("last_name", p.LastName); err != nil {
+		return p, err
+	}
+	if err := validateAttributes(f.Attributes, false); err != nil {
+		return p, err
+	}
+	p.Attributes = f.Attributes
+	return p, nil
+}
+
+func validateName(field, name string) error {
+	if utf8.RuneCountInString(name) > maxNameLength {
+		return fmt.Errorf("%s must be at most %d characters", field, maxNameLength)
+	}
+	return nil
+}
+
+// validateAttributes accepts flat string, number and boolean values. null
+// is only meaningful in updates, where it removes the key.
+func validateAttributes(attrs map[string]any, allowNull bool) error {
+	if len(attrs) > maxAttributes {
+		return fmt.Errorf("at most %d attributes are allowed", maxAttributes)
+	}
+	for key, value := range attrs {
+		if !attributeKey.MatchString(key) {
+			return fmt.Errorf("invalid attribute name %q", key)
+		}
+		switch v := value.(type) {
+		case nil:
+			if !allowNull {
+				return fmt.Errorf("attribute %q must not be null", key)
+			}
+		case string:
+			if utf8.RuneCountInString(v) > maxAttributeLength {
+				return fmt.Errorf("attribute %q must be at most %d characters", key, maxAttributeLength)
+			}
+		case float64, bool:
+		default:
+			return fmt.Errorf("attribute %q must be a string, number or boolean", key)
+		}
+	}
+	return nil
+}
+
+// SubscriberProfile is a subscriber as returned by the admin API.
+type SubscriberProfile struct {
+	Email        string         `json:"email"`
+	Status       string         `json:"status"`
+	Suppression  string         `json:"suppression,omitempty"`
+	FirstName    string         `json:"first_name,omitempty"`
+	LastName     string         `json:"last_name,omitempty"`
+	Attributes   map[string]any `json:"attributes"`
+	Tags         []string       `json:"tags"`
+	Lists        []string       `json:"lists"`
+	SubscribedAt time.Time      `json:"subscribed_at"`
+	ConfirmedAt  *time.Time     `json:"confirmed_at,omitempty"`
+}
+
+func newSubscriberProfile(s *store.Subscriber) *SubscriberProfile {
+	return &SubscriberProfile{
+		Email:        s.Email,
+		Status:       s.Status,
+		Suppression:  s.Suppression,
+		FirstName:    s.Profile.FirstName,
+		LastName:     s.Profile.LastName,
+		Attributes:   s.Profile.Attributes,
+		Tags:         nonNil(s.Tags),
+		Lists:        nonNil(s.Lists),
+		SubscribedAt: s.CreatedAt,
+		ConfirmedAt:  s.ConfirmedAt,
+	}
+}
+
+func nonNil(s []string) []string {
+	if s == nil {
+		return []string{}
+	}
+	return s
+}
+
+// contactFor is what merge tags see of a subscriber.
+func contactFor(s *store.Subscriber) utils.Contact {
+	return utils.Contact{
+		Email:        s.Email,
+		FirstName:    s.Profile.FirstName,
+		LastName:     s.Profile.LastName,
+		SubscribedAt: s.CreatedAt,
+		Attributes:   s.Profile.Attributes,
+	}
+}
+
+// ProfileUpdateRequest changes a subscriber's profile. Omitted fields are
+// left alone, an empty name clears it and a null attribute is removed.
+// Tags and lists replace the current ones.
+type ProfileUpdateRequest struct {
+	FirstName  *string        `json:"first_name"`
+	LastName   *string        `json:"last_name"`
+	Attributes map[string]any `json:"attributes"`
+	Tags       *[]string      `json:"tags"`
+	Lists      *[]string      `json:"lists"`
+}
+
+// SubscriberHandler serves GET and PATCH /subscribers/{email}.
+func SubscriberHandler(d *Deps) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodPatch {
+			SendJSON(w, http.StatusMethodNotAllowed, JSONResponse{Error: "Method not allowed"})
+			return
+		}
+
+		if !requireSecret(w, r) {
+			return
+		}
+
+		email := strings.TrimSpace(r.PathValue("email"))
+
+		var sub *store.Subscriber
+		var err error
+		if r.Method == http.MethodGet {
+			sub, err = d.Subscribers.Subscriber(r.Context(), email)
+		} else {
+			var req ProfileUpdateRequest
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				SendJSON(w, http.StatusBadRequest, JSONResponse{Error: "Invalid JSON request"})
+				return
+			}
+			u, verr := req.update()
+			if verr != nil {
+				SendJSON(w, http.StatusBadRequest, JSONResponse{Error: verr.Error()})
+				return
+			}
+			sub, err = d.Subscribers.UpdateProfile(r.Context(), email, u)
+		}
+		if errors.Is(err, store.ErrNotFound) {
+			SendJSON(w, http.StatusNotFound, JSONResponse{Error: "Subscriber not found"})
+			return
+		}
+		if err != nil {
+			SendJSON(w, http.StatusInternalServerError, JSONResponse{Error: "Database error occurred"})
+			return
+		}
+
+		SendJSON(w, http.StatusOK, JSONResponse{Success: true, Subscriber: newSubscriberProfile(sub)})
+	}
+}
+
+func (req ProfileUpdateRequest) update() (store.ProfileUpdate, error) {
+	u := store.ProfileUpdate{Attributes: req.Attributes}
+	if req.FirstName != nil {
+		name := strings.TrimSpace(*req.FirstName)
+		if err := validateName("first_name", name); err != nil {
+			return u, err
+		}
+		u.FirstName = &name
+	}
+	if req.LastName != nil {
+		name := strings.TrimSpace(*req.LastName)
+		if err := validateName("last_name", name); err != nil {
+			return u, err
+		}
+		u.LastName = &name
+	}
+	if req.Tags != nil {
+		tags, err := normalizeTags(*req.Tags)
+		if err != nil {
+			return u, err
+		}
+		u.Tags = &tags
+	}
+	u.Lists = req.Lists
+	return u, validateAttributes(req.Attributes, true)
+}
+
+// normalizeTags lower-cases and de-duplicates tags.
+func normalizeTags(tags []string) ([]string, error) {
+	if len(tags) > maxTags {
+		return nil, fmt.Errorf("at most %d tags are allowed", maxTags)
+	}
+	out := []string{}
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag == "" || utf8.RuneCountInString(tag) > maxTagLength {
+			return nil, fmt.Errorf("tags must be 1 to %d characters", maxTagLength)
+		}
+		if !slices.Contains(out, tag) {
+			out = append(out, tag)
+		}
+	}
+	slices.Sort(out)
+	return out, nil
+}
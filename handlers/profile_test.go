@@ -0,0 +1,144 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/pixperk/newsletter/utils"
+)
+
+func TestSubscriberHandlerRequiresSecret(t *testing.T) {
+	d, st, _ := newTestDeps(t)
+	subscribe(t, st, "ada@example.com")
+	h := SubscriberHandler(d)
+
+	for _, req := range []request{
+		{method: http.MethodGet, target: "/subscribers/ada@example.com"},
+		{method: http.MethodGet, target: "/subscribers/ada@example.com", headers: map[string]string{"X-Secret": "wrong"}},
+		{method: http.MethodPatch, target: "/subscribers/ada@example.com", body: `{"first_name": "Eve"}`},
+	} {
+		req.pattern = "/subscribers/{email}"
+		if w, resp := serve(t, h, req); w.Code != http.StatusUnauthorized || resp.Subscriber != nil {
+			t.Errorf("%s %v: status %d, %+v; want 401", req.method, req.headers, w.Code, resp)
+		}
+	}
+	if sub, _ := st.Subscriber(context.Background(), "ada@example.com"); sub.Profile.FirstName != "" {
+		t.Errorf("unauthorized PATCH changed the first name to %q", sub.Profile.FirstName)
+	}
+
+	w, resp := serve(t, h, request{method: http.MethodGet, target: "/subscribers/ada@example.com", pattern: "/subscribers/{email}", admin: true})
+	if w.Code != http.StatusOK || resp.Subscriber == nil || resp.Subscriber.Email != "ada@example.com" {
+		t.Errorf("GET: status %d, %+v", w.Code, resp)
+	}
+}
+
+func TestProfileValidation(t *testing.T) {
+	tests := []struct {
+		name    string
+		profile string
+		// patchOK is set for profiles only valid in updates.
+		patchOK bool
+	}{
+		{"name too long", `"first_name": "` + strings.Repeat("a", maxNameLength+1) + `"`, false},
+		{"attribute name with a dash", `"attributes": {"first-name": "x"}`, false},
+		{"attribute name with braces", `"attributes": {"a}}{{b": "x"}`, false},
+		{"attribute name with a dot", `"attributes": {"a.b": "x"}`, false},
+		{"attribute name starting with a digit", `"attributes": {"1st": "x"}`, false},
+		{"attribute name starting with an underscore", `"attributes": {"_x": "x"}`, false},
+		{"nested attribute", `"attributes": {"address": {"city": "x"}}`, false},
+		{"list attribute", `"attributes": {"langs": ["go"]}`, false},
+		{"attribute too long", `"attributes": {"bio": "` + strings.Repeat("x", maxAttributeLength+1) + `"}`, false},
+		{"null attribute", `"attributes": {"plan": null}`, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d, st, mail := newTestDeps(t)
+			req := request{method: http.MethodPost, target: "/subscribe", body: `{"email": "ada@example.com", ` + tt.profile + `}`}
+			if w, resp := serve(t, SubscribeHandler(d), req); w.Code != http.StatusBadRequest {
+				t.Errorf("subscribe: status %d, %+v; want 400", w.Code, resp)
+			}
+			if n := len(mail.messages()); n != 0 {
+				t.Errorf("sent %d verification emails for an invalid profile", n)
+			}
+
+			subscribe(t, st, "ada@example.com")
+			want := http.StatusBadRequest
+			if tt.patchOK {
+				want = http.StatusOK
+			}
+			patch := request{method: http.MethodPatch, target: "/subscribers/ada@example.com", pattern: "/subscribers/{email}", admin: true, body: "{" + tt.profile + "}"}
+			if w, resp := serve(t, SubscriberHandler(d), patch); w.Code != want {
+				t.Errorf("patch: status %d, %+v; want %d", w.Code, resp, want)
+			}
+		})
+	}
+}
+
+func TestProfileUpdate(t *testing.T) {
+	d, st, _ := newTestDeps(t)
+	subscribe(t, st, "ada@example.com")
+	h := SubscriberHandler(d)
+	patch := func(body string) JSONResponse {
+		t.Helper()
+		w, resp := serve(t, h, request{method: http.MethodPatch, target: "/subscribers/ada@example.com", pattern: "/subscribers/{email}", admin: true, body: body})
+		if w.Code != http.StatusOK {
+			t.Fatalf("PATCH %s: status %d, %+v", body, w.Code, resp)
+		}
+		return resp
+	}
+
+	resp := patch(`{"first_name": " Ada ", "attributes": {"plan": "pro", "seats": 3, "beta": true}, "tags": ["VIP", "vip", " early "]}`)
+	sub := resp.Subscriber
+	if sub.FirstName != "Ada" || sub.Attributes["plan"] != "pro" || sub.Attributes["seats"] != float64(3) || sub.Attributes["beta"] != true {
+		t.Errorf("after first PATCH = %+v", sub)
+	}
+	if strings.Join(sub.Tags, ",") != "early,vip" {
+		t.Errorf("tags = %q, want early,vip", sub.Tags)
+	}
+
+	// Omitted fields are kept and null removes an attribute.
+	sub = patch(`{"attributes": {"plan": null}}`).Subscriber
+	if sub.FirstName != "Ada" || len(sub.Tags) != 2 {
+		t.Errorf("omitted fields changed: %+v", sub)
+	}
+	if _, ok := sub.Attributes["plan"]; ok || sub.Attributes["seats"] != float64(3) {
+		t.Errorf("attributes = %v, want plan removed and seats kept", sub.Attributes)
+	}
+
+	w, resp := serve(t, h, request{method: http.MethodPatch, target: "/subscribers/bob@example.com", pattern: "/subscribers/{email}", admin: true, body: `{"first_name": "Bob"}`})
+	if w.Code != http.StatusNotFound {
+		t.Errorf("unknown subscriber: status %d, %+v; want 404", w.Code, resp)
+	}
+}
+
+func TestAttributeValuesAreData(t *testing.T) {
+	d, st, _ := newTestDeps(t)
+	d.Policy = PolicySingle
+	// A subscriber controls their own name and attributes; neither may
+	// turn into markup or merge tags when a newsletter is rendered.
+	body := `{"email": "eve@example.com", "first_name": "<script>alert(1)</script>", "attributes": {"company": "{{unsubscribe_url}}<b>x</b>"}}`
+	if w, resp := serve(t, SubscribeHandler(d), request{method: http.MethodPost, target: "/subscribe", body: body}); w.Code != http.StatusOK {
+		t.Fatalf("subscribe: status %d, %+v", w.Code, resp)
+	}
+	sub, err := st.Subscriber(context.Background(), "eve@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tmpl, err := utils.ParseMergeTemplate("Hi {{first_name}}", "<p>{{first_name}} at {{company}}</p>", "{{company}}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	subject, html, text, err := tmpl.Render(contactFor(sub))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "<p>&lt;script&gt;alert(1)&lt;/script&gt; at {{unsubscribe_url}}&lt;b&gt;x&lt;/b&gt;</p>"; html != want {
+		t.Errorf("html = %q, want %q", html, want)
+	}
+	if subject != "Hi <script>alert(1)</script>" || text != "{{unsubscribe_url}}<b>x</b>" {
+		t.Errorf("subject = %q, text = %q; want the values verbatim", subject, text)
+	}
+}
@@ -11,6 +11,25 @@ type JSONResponse struct {
 	Error           string `json:"error,omitempty"`
 	EmailsSent      int    `json:"emails_sent,omitempty"`
 	SubscriberCount int    `json:"subscriber_count,omitempty"`
+
+	CampaignID int64           `json:"campaign_id,omitempty"`
+	Campaign   *CampaignStatus `json:"campaign,omitempty"`
+	Draft      *DraftInfo      `json:"draft,omitempty"`
+	Drafts     []DraftInfo     `json:"drafts,omitempty"`
+	Revisions  []DraftInfo     `json:"revisions,omitempty"`
+	Diff       *RevisionDiff   `json:"diff,omitempty"`
+	Preview    *Preview        `json:"preview,omitempty"`
+	// Stripped lists what sanitizing removed from a campaign's HTML.
+	Stripped []string `json:"stripped,omitempty"`
+
+	Subscriber *SubscriberProfile `json:"subscriber,omitempty"`
+
+	List     *ListInfo     `json:"list,omitempty"`
+	Lists    []ListInfo    `json:"lists,omitempty"`
+	Segment  *SegmentInfo  `json:"segment,omitempty"`
+	Segments []SegmentInfo `json:"segments,omitempty"`
+
+	Preferences *Preferences `json:"preferences,omitempty"`
 }
 
 func SendJSON(w http.ResponseWriter, statusCode int, resp JSONResponse) {
@@ -0,0 +1,141 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/pixperk/newsletter/store"
+)
+
+// localLayouts are the accepted forms of a scheduled time without an
+// offset; it is then read in the request's time zone.
+var localLayouts = []string{"2006-01-02T15:04", "2006-01-02T15:04:05", "2006-01-02 15:04"}
+
+// ScheduleRequest picks when a campaign starts. ScheduledAt is either an
+// RFC 3339 time with an offset or a local time such as 2026-03-01T09:00
+// in TimeZone, an IANA name that defaults to UTC. Force schedules it even
+// if an identical campaign went to the same audience within the duplicate
+// window before that time.
+type ScheduleRequest struct {
+	ScheduledAt string `json:"scheduled_at"`
+	TimeZone    string `json:"time_zone"`
+	Force       bool   `json:"force"`
+}
+
+// parseSchedule returns the scheduled instant and the name of the time
+// zone it was given in.
+func parseSchedule(at, zone string) (time.Time, string, error) {
+	at, zone = strings.TrimSpace(at), strings.TrimSpace(zone)
+	if at == "" {
+		return time.Time{}, "", errors.New("scheduled_at is required")
+	}
+	loc := time.UTC
+	if zone != "" {
+		var err error
+		if loc, err = time.LoadLocation(zone); err != nil {
+			return time.Time{}, "", errors.New("Unknown time zone: " + zone)
+		}
+	}
+
+	t, err := time.Parse(time.RFC3339, at)
+	for _, layout := range localLayouts {
+		if err == nil {
+			break
+		}
+		t, err = time.ParseInLocation(layout, at, loc)
+	}
+	if err != nil {
+		return time.Time{}, "", errors.New("scheduled_at must be an RFC 3339 time or a local time like 2006-01-02T15:04")
+	}
+	if !t.After(time.Now()) {
+		return time.Time{}, "", errors.New("scheduled_at must be in the future")
+	}
+	return t, loc.String(), nil
+}
+
+// ScheduleHandler serves /campaigns/{id}/schedule: POST or PUT schedules
+// the latest revision of a draft, or moves a campaign that is already
+// scheduled, and DELETE cancels the schedule, turning the campaign back
+// into a draft. Both are possible until the campaign starts.
+func ScheduleHandler(d *Deps) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost && r.Method != http.MethodPut && r.Method != http.MethodDelete {
+			SendJSON(w, http.StatusMethodNotAllowed, JSONResponse{Error: "Method not allowed"})
+			return
+		}
+
+		if !requireSecret(w, r) {
+			return
+		}
+
+		id, ok := campaignID(w, r)
+		if !ok {
+			return
+		}
+
+		if r.Method == http.MethodDelete {
+			err := d.Drafts.UnscheduleDraft(r.Context(), id)
+			if errors.Is(err, store.ErrConflict) {
+				SendJSON(w, http.StatusConflict, JSONResponse{Error: "Campaign is not scheduled"})
+				return
+			}
+			if !draftError(w, err) {
+				return
+			}
+			SendJSON(w, http.StatusOK, JSONResponse{Success: true, Message: "Schedule cancelled, the campaign is a draft again", CampaignID: id})
+			return
+		}
+
+		var req ScheduleRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			SendJSON(w, http.StatusBadRequest, JSONResponse{Error: "Invalid JSON request"})
+			return
+		}
+		at, zone, err := parseSchedule(req.ScheduledAt, req.TimeZone)
+		if err != nil {
+			SendJSON(w, http.StatusBadRequest, JSONResponse{Error: err.Error()})
+			return
+		}
+
+		scheduleDraft(w, r, d, id, at, zone, req.Force)
+	}
+}
+
+// scheduleDraft renders the latest revision of draft id and schedules it,
+// refusing duplicates unless force is set. It writes the JSON response
+// itself.
+func scheduleDraft(w http.ResponseWriter, r *http.Request, d *Deps, id int64, at time.Time, zone string, force bool) {
+	rev, rendered, ok := renderDraft(w, r, d, id)
+	if !ok {
+		return
+	}
+	if !force && !notDuplicate(w, r, d, rev.Subject, rendered.HTML, rev.Audience, at, id) {
+		return
+	}
+
+	err := d.Drafts.ScheduleDraft(r.Context(), id, rev.Number, rev.Subject, rendered.HTML, rendered.Text, at, zone, force)
+	if errors.Is(err, store.ErrConflict) {
+		SendJSON(w, http.StatusConflict, JSONResponse{Error: "Campaign has started or was edited in the meantime"})
+		return
+	}
+	if !draftError(w, err) {
+		return
+	}
+
+	campaign, err := d.Campaigns.Campaign(r.Context(), id)
+	if err != nil {
+		SendJSON(w, http.StatusInternalServerError, JSONResponse{Error: "Database error occurred"})
+		return
+	}
+	status := newCampaignStatus(campaign)
+	SendJSON(w, http.StatusOK, JSONResponse{
+		Success:    true,
+		Message:    "Campaign scheduled for " + status.ScheduledAt.Format("Mon, 02 Jan 2006 15:04 MST"),
+		CampaignID: id,
+		Campaign:   status,
+		Stripped:   rendered.Stripped,
+	})
+}
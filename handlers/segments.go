@@ -0,0 +1,144 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/pixperk/newsletter/segment"
+	"github.com/pixperk/newsletter/store"
+)
+
+// SegmentInfo is a segment as returned by the admin API. Matches is only
+// filled in for single segments.
+type SegmentInfo struct {
+	Slug      string    `json:"slug"`
+	Name      string    `json:"name"`
+	Filter    string    `json:"filter"`
+	Matches   *int      `json:"matches,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func newSegmentInfo(s store.Segment) SegmentInfo {
+	return SegmentInfo{Slug: s.Slug, Name: s.Name, Filter: s.Filter, CreatedAt: s.CreatedAt}
+}
+
+type CreateSegmentRequest struct {
+	Slug   string `json:"slug"`
+	Name   string `json:"name"`
+	Filter string `json:"filter"`
+}
+
+// SegmentsHandler serves GET /segments and POST /segments.
+func SegmentsHandler(d *Deps) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodPost {
+			SendJSON(w, http.StatusMethodNotAllowed, JSONResponse{Error: "Method not allowed"})
+			return
+		}
+
+		if !requireSecret(w, r) {
+			return
+		}
+
+		if r.Method == http.MethodGet {
+			segments, err := d.Lists.Segments(r.Context())
+			if err != nil {
+				SendJSON(w, http.StatusInternalServerError, JSONResponse{Error: "Database error occurred"})
+				return
+			}
+			infos := make([]SegmentInfo, 0, len(segments))
+			for _, s := range segments {
+				infos = append(infos, newSegmentInfo(s))
+			}
+			SendJSON(w, http.StatusOK, JSONResponse{Success: true, Segments: infos})
+			return
+		}
+
+		var req CreateSegmentRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			SendJSON(w, http.StatusBadRequest, JSONResponse{Error: "Invalid JSON request"})
+			return
+		}
+
+		slug := strings.TrimSpace(req.Slug)
+		name := strings.TrimSpace(req.Name)
+		if !slugPattern.MatchString(slug) {
+			SendJSON(w, http.StatusBadRequest, JSONResponse{Error: "Slug must be lowercase letters, digits and dashes"})
+			return
+		}
+		if name == "" {
+			SendJSON(w, http.StatusBadRequest, JSONResponse{Error: "Name is required"})
+			return
+		}
+		if _, err := segment.Parse(req.Filter); err != nil {
+			SendJSON(w, http.StatusBadRequest, JSONResponse{Error: "Invalid filter: " + err.Error()})
+			return
+		}
+
+		s, err := d.Lists.CreateSegment(r.Context(), store.Segment{Slug: slug, Name: name, Filter: strings.TrimSpace(req.Filter)})
+		if errors.Is(err, store.ErrConflict) {
+			SendJSON(w, http.StatusConflict, JSONResponse{Error: "A segment with this slug already exists"})
+			return
+		}
+		if err != nil {
+			SendJSON(w, http.StatusInternalServerError, JSONResponse{Error: "Database error occurred"})
+			return
+		}
+
+		info := newSegmentInfo(*s)
+		SendJSON(w, http.StatusCreated, JSONResponse{Success: true, Segment: &info})
+	}
+}
+
+// SegmentHandler serves GET /segments/{slug}, which includes the number of
+// subscribers the segment currently matches, and DELETE.
+func SegmentHandler(d *Deps) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodDelete {
+			SendJSON(w, http.StatusMethodNotAllowed, JSONResponse{Error: "Method not allowed"})
+			return
+		}
+
+		if !requireSecret(w, r) {
+			return
+		}
+
+		slug := r.PathValue("slug")
+		if r.Method == http.MethodDelete {
+			err := d.Lists.DeleteSegment(r.Context(), slug)
+			if errors.Is(err, store.ErrNotFound) {
+				SendJSON(w, http.StatusNotFound, JSONResponse{Error: "Segment not found"})
+				return
+			}
+			if err != nil {
+				SendJSON(w, http.StatusInternalServerError, JSONResponse{Error: "Database error occurred"})
+				return
+			}
+			SendJSON(w, http.StatusOK, JSONResponse{Success: true, Message: "Segment deleted"})
+			return
+		}
+
+		s, err := d.Lists.Segment(r.Context(), slug)
+		if errors.Is(err, store.ErrNotFound) {
+			SendJSON(w, http.StatusNotFound, JSONResponse{Error: "Segment not found"})
+			return
+		}
+		if err != nil {
+			SendJSON(w, http.StatusInternalServerError, JSONResponse{Error: "Database error occurred"})
+			return
+		}
+
+		n, err := d.Lists.CountAudience(r.Context(), store.Audience{Segment: slug})
+		if err != nil {
+			SendJSON(w, http.StatusInternalServerError, JSONResponse{Error: "Database error occurred"})
+			return
+		}
+
+		info := newSegmentInfo(*s)
+		info.Matches = &n
+		SendJSON(w, http.StatusOK, JSONResponse{Success: true, Segment: &info})
+	}
+}
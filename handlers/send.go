@@ -1,145 +1,274 @@
 package handlers
 
 import (
-	"database/sql"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
 	"os"
 	"strconv"
 	"strings"
-	"sync"
 	"time"
 
+	"github.com/pixperk/newsletter/store"
 	"github.com/pixperk/newsletter/utils"
 )
 
+// SendRequest is a draft to send at once, or the campaign_id of a saved
+// one. A Body with front matter may also set scheduled_at and time_zone.
 type SendRequest struct {
-	Subject string `json:"subject"`
-	Body    string `json:"body"`
+	// CampaignID sends the latest revision of a draft instead of the
+	// content below.
+	CampaignID int64 `json:"campaign_id"`
+	DraftRequest
+	// ScheduledAt and TimeZone (see ScheduleRequest) schedule the send
+	// instead of queueing it now. Inline content is saved as a draft first.
+	ScheduledAt string `json:"scheduled_at"`
+	TimeZone    string `json:"time_zone"`
+	// Force sends even if an identical campaign went to the same audience
+	// within the duplicate window.
+	Force bool `json:"force"`
 }
 
-const maxSendWorkers = 20
-
-func SendHandler(db *sql.DB) http.HandlerFunc {
+// SendHandler serves POST /send. A request carrying an Idempotency-Key
+// header is handled once; retries get the original response back. The body
+// can also be a whole issue sent as text/markdown, in which case
+// ?force=true skips the duplicate check.
+func SendHandler(d *Deps) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodPost {
 			SendJSON(w, http.StatusMethodNotAllowed, JSONResponse{Error: "Method not allowed"})
 			return
 		}
 
-		if r.Header.Get("X-Secret") != os.Getenv("SEND_SECRET") {
-			SendJSON(w, http.StatusUnauthorized, JSONResponse{Error: "Unauthorized - invalid or missing X-Secret header"})
+		if !requireSecret(w, r) {
 			return
 		}
 
-		var req SendRequest
-		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
 			SendJSON(w, http.StatusBadRequest, JSONResponse{Error: "Invalid JSON request"})
 			return
 		}
+		withIdempotencyKey(w, r, d, body, func(w http.ResponseWriter) {
+			var req SendRequest
+			if isMarkdown(r) {
+				req.Body, req.Force = string(body), r.URL.Query().Get("force") == "true"
+			} else if err := json.Unmarshal(body, &req); err != nil {
+				SendJSON(w, http.StatusBadRequest, JSONResponse{Error: "Invalid JSON request"})
+				return
+			}
+			send(w, r, d, req)
+		})
+	}
+}
 
-		if req.Subject == "" || req.Body == "" {
-			SendJSON(w, http.StatusBadRequest, JSONResponse{Error: "Subject and body are required"})
+// send creates, schedules or queues the campaign described by req. It
+// writes the JSON response itself.
+func send(w http.ResponseWriter, r *http.Request, d *Deps, req SendRequest) {
+	if req.CampaignID == 0 {
+		fm, err := req.applyFrontMatter()
+		if err != nil {
+			SendJSON(w, http.StatusBadRequest, JSONResponse{Error: err.Error()})
 			return
 		}
+		if req.ScheduledAt == "" {
+			req.ScheduledAt, req.TimeZone = string(fm.ScheduledAt), fm.TimeZone
+		}
+	}
 
-		htmlBody := utils.MarkdownToHTML(req.Body)
+	var at time.Time
+	var zone string
+	if req.ScheduledAt != "" {
+		var err error
+		if at, zone, err = parseSchedule(req.ScheduledAt, req.TimeZone); err != nil {
+			SendJSON(w, http.StatusBadRequest, JSONResponse{Error: err.Error()})
+			return
+		}
+	}
 
-		footerHTML := loadFooterHTML()
-		if footerHTML != "" {
-			htmlBody += footerHTML
+	if req.CampaignID != 0 {
+		if req.ScheduledAt != "" {
+			scheduleDraft(w, r, d, req.CampaignID, at, zone, req.Force)
+		} else {
+			sendDraft(w, r, d, req.CampaignID, req.Force)
 		}
+		return
+	}
 
-		htmlBody = utils.WrapInTemplate(req.Subject, htmlBody)
+	if req.Subject == "" || req.Body == "" {
+		SendJSON(w, http.StatusBadRequest, JSONResponse{Error: "Subject and body are required"})
+		return
+	}
 
-		rows, err := db.Query(`SELECT email FROM subscribers`)
-		if err != nil {
+	dr, ok := validDraft(w, r, d, req.DraftRequest)
+	if !ok {
+		return
+	}
+
+	rendered, err := renderNewsletter(r.Context(), d, dr)
+	if err != nil {
+		var invalid *invalidTemplateError
+		if errors.As(err, &invalid) {
+			SendJSON(w, http.StatusBadRequest, JSONResponse{Error: err.Error()})
+		} else {
 			SendJSON(w, http.StatusInternalServerError, JSONResponse{Error: "Database error occurred"})
-			return
 		}
-		defer rows.Close()
+		return
+	}
 
-		var emails []string
-		for rows.Next() {
-			var email string
-			if err := rows.Scan(&email); err != nil {
-				continue
-			}
-			emails = append(emails, email)
-		}
+	start := time.Now()
+	if req.ScheduledAt != "" {
+		start = at
+	}
+	if !req.Force && !notDuplicate(w, r, d, dr.Subject, rendered.HTML, dr.Audience, start, 0) {
+		return
+	}
 
-		subscriberCount := len(emails)
-		if subscriberCount == 0 {
-			SendJSON(w, http.StatusOK, JSONResponse{Success: true, Message: "No subscribers to send emails to"})
+	if req.ScheduledAt != "" {
+		rev, err := d.Drafts.CreateDraft(r.Context(), dr)
+		if err != nil {
+			SendJSON(w, http.StatusInternalServerError, JSONResponse{Error: "Database error occurred"})
 			return
 		}
+		// Checked above so that no draft is saved for a duplicate; the
+		// force flag is also what the scheduler goes by at the start.
+		scheduleDraft(w, r, d, rev.CampaignID, at, zone, req.Force)
+		return
+	}
 
-		// Worker pool to bound concurrency
-		type result struct {
-			email string
-			err   error
-		}
+	campaignID, subscriberCount, err := d.Campaigns.CreateCampaign(r.Context(), dr.Subject, rendered.HTML, rendered.Text, dr.Audience)
+	if errors.Is(err, store.ErrNotFound) {
+		SendJSON(w, http.StatusNotFound, JSONResponse{Error: "List or segment not found"})
+		return
+	}
+	if err != nil {
+		SendJSON(w, http.StatusInternalServerError, JSONResponse{Error: "Database error occurred"})
+		return
+	}
 
-		jobs := make(chan string, len(emails))
-		results := make(chan result, len(emails))
+	sendQueued(w, d, campaignID, subscriberCount, rendered.Stripped)
+}
 
-		var wg sync.WaitGroup
-		workers := maxSendWorkers
-		if subscriberCount < workers {
-			workers = subscriberCount
-		}
-		for i := 0; i < workers; i++ {
-			wg.Add(1)
-			go func() {
-				defer wg.Done()
-				for email := range jobs {
-					err := utils.SendEmail(email, req.Subject, htmlBody)
-					results <- result{email: email, err: err}
-				}
-			}()
-		}
+// notDuplicate refuses a campaign starting at start whose subject and
+// rendered body were already queued for the same audience within the
+// duplicate window before it, or are scheduled to go to it. Campaign
+// except, the one being sent or scheduled, does not count. It writes the
+// error response itself.
+func notDuplicate(w http.ResponseWriter, r *http.Request, d *Deps, subject, htmlBody string, a store.Audience, start time.Time, except int64) bool {
+	if d.Sends.DuplicateWindow == 0 {
+		return true
+	}
+	id, err := d.Campaigns.DuplicateCampaign(r.Context(), subject, htmlBody, a, start.Add(-d.Sends.DuplicateWindow), except)
+	if errors.Is(err, store.ErrNotFound) {
+		return true
+	}
+	if err != nil {
+		SendJSON(w, http.StatusInternalServerError, JSONResponse{Error: "Database error occurred"})
+		return false
+	}
+	SendJSON(w, http.StatusConflict, JSONResponse{
+		Error:      fmt.Sprintf("An identical campaign was queued for this audience recently or is scheduled for it (campaign %d); set force to send it again", id),
+		CampaignID: id,
+	})
+	return false
+}
 
-		for _, email := range emails {
-			jobs <- email
-		}
-		close(jobs)
-
-		go func() {
-			wg.Wait()
-			close(results)
-		}()
-
-		successCount := 0
-		errorCount := 0
-		var errors []string
-
-		for res := range results {
-			if res.err != nil {
-				errorCount++
-				errors = append(errors, fmt.Sprintf("Failed to send to %s: %v", res.email, res.err))
-			} else {
-				successCount++
-			}
-		}
+// sendQueued reports a newly queued campaign, and what was stripped from
+// its HTML, and wakes the workers.
+func sendQueued(w http.ResponseWriter, d *Deps, campaignID int64, subscriberCount int, stripped []string) {
+	if subscriberCount == 0 {
+		SendJSON(w, http.StatusOK, JSONResponse{Success: true, Message: "No subscribers to send emails to", CampaignID: campaignID, Stripped: stripped})
+		return
+	}
 
-		if errorCount == 0 {
-			SendJSON(w, http.StatusOK, JSONResponse{Success: true, Message: "Newsletter sent successfully to all subscribers! 🚀", EmailsSent: successCount, SubscriberCount: subscriberCount})
-		} else if successCount > 0 {
-			errorMessage := fmt.Sprintf("Partial success: %d sent, %d failed. Errors: %s", successCount, errorCount, strings.Join(errors, "; "))
-			SendJSON(w, http.StatusPartialContent, JSONResponse{Success: true, Message: "Newsletter partially sent", Error: errorMessage, EmailsSent: successCount, SubscriberCount: subscriberCount})
-		} else {
-			errorMessage := fmt.Sprintf("All emails failed to send. Errors: %s", strings.Join(errors, "; "))
-			SendJSON(w, http.StatusInternalServerError, JSONResponse{Error: errorMessage, EmailsSent: successCount, SubscriberCount: subscriberCount})
+	d.Queue.Notify()
+
+	SendJSON(w, http.StatusAccepted, JSONResponse{
+		Success:         true,
+		Message:         fmt.Sprintf("Newsletter queued for %d subscribers. Track progress at /campaigns/%d", subscriberCount, campaignID),
+		SubscriberCount: subscriberCount,
+		CampaignID:      campaignID,
+		Stripped:        stripped,
+	})
+}
+
+// invalidTemplateError is a newsletter that cannot be rendered: its theme
+// does not exist, or its merge tags do not parse or refer to unknown
+// variables.
+type invalidTemplateError struct {
+	err error
+}
+
+func (e *invalidTemplateError) Error() string {
+	return e.err.Error()
+}
+
+// newsletter is a draft rendered for sending: the HTML and plain-text
+// bodies still contain merge tags, which Template fills in per recipient.
+// Stripped lists what sanitizing removed from the body's HTML.
+type newsletter struct {
+	HTML     string
+	Text     string
+	Template *utils.MergeTemplate
+	Stripped []string
+}
+
+// renderNewsletter turns a draft's Markdown body into the campaign's HTML
+// template and plain-text alternative. Raw HTML is sanitized unless the
+// draft is trusted, and the draft's utm_campaign is added to the links of
+// both bodies. It then checks that the merge tags of the subject and
+// bodies can be rendered for every subscriber.
+func renderNewsletter(ctx context.Context, d *Deps, n store.Draft) (*newsletter, error) {
+	htmlBody := utils.MarkdownTemplateToHTML(n.Body)
+	textBody := n.Body
+	var stripped []string
+	if !n.TrustedHTML {
+		var err error
+		if htmlBody, stripped, err = utils.SanitizeHTML(htmlBody); err != nil {
+			return nil, &invalidTemplateError{err}
 		}
 	}
+
+	footer := loadFooter()
+	if footer != "" {
+		htmlBody += "<br>" + utils.MarkdownTemplateToHTML(footer)
+		textBody += "\n\n----\n\n" + footer
+	}
+	htmlBody = utils.AddUTMToHTML(htmlBody, n.UTMCampaign)
+
+	theme, err := d.Themes.Theme(n.Theme)
+	if err != nil {
+		return nil, &invalidTemplateError{errors.New(unknownThemeMessage(d, n.Theme))}
+	}
+	htmlBody, err = theme.Newsletter(n.Subject, n.Preheader, htmlBody)
+	if err != nil {
+		return nil, err
+	}
+	htmlBody = utils.InlineCSS(htmlBody)
+	textBody = utils.AddUTMToText(utils.MarkdownTemplateToText(textBody), n.UTMCampaign)
+
+	tmpl, err := utils.ParseMergeTemplate(n.Subject, htmlBody, textBody)
+	if err != nil {
+		return nil, &invalidTemplateError{fmt.Errorf("Invalid merge tags: %w", err)}
+	}
+	known, err := d.Subscribers.AttributeKeys(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := tmpl.Validate(known); err != nil {
+		return nil, &invalidTemplateError{fmt.Errorf("Invalid merge tags: %w", err)}
+	}
+	return &newsletter{HTML: htmlBody, Text: textBody, Template: tmpl, Stripped: stripped}, nil
 }
 
-func loadFooterHTML() string {
+// loadFooter returns the Markdown of footer.md, or "" if there is none.
+func loadFooter() string {
 	footerContent, err := os.ReadFile("footer.md")
 	if err != nil {
 		return ""
 	}
-	content := strings.ReplaceAll(string(footerContent), "{{YEAR}}", strconv.Itoa(time.Now().Year()))
-	return "<br>" + utils.MarkdownToHTML(content)
+	return strings.ReplaceAll(string(footerContent), "{{YEAR}}", strconv.Itoa(time.Now().Year()))
 }
@@ -0,0 +1,175 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/pixperk/newsletter/store"
+)
+
+// subscribe adds active subscribers to st.
+func subscribe(t *testing.T, st *store.Memory, emails ...string) {
+	t.Helper()
+	for _, email := range emails {
+		if err := st.AddSubscriber(context.Background(), email, store.Consent{Source: "test"}, store.Profile{}, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+const issue = `{"subject": "Issue 1", "body": "# Hello\n\nFirst issue."}`
+
+func TestSendRefusesDuplicates(t *testing.T) {
+	d, st, _ := newTestDeps(t)
+	subscribe(t, st, "a@example.com", "b@example.com")
+	h := SendHandler(d)
+
+	w, resp := serve(t, h, request{method: http.MethodPost, target: "/send", body: issue, admin: true})
+	if w.Code != http.StatusAccepted || resp.SubscriberCount != 2 {
+		t.Fatalf("first send: status %d, %+v", w.Code, resp)
+	}
+	first := resp.CampaignID
+
+	w, resp = serve(t, h, request{method: http.MethodPost, target: "/send", body: issue, admin: true})
+	if w.Code != http.StatusConflict || resp.CampaignID != first {
+		t.Fatalf("second send: status %d, %+v; want 409 naming campaign %d", w.Code, resp, first)
+	}
+
+	forced := `{"subject": "Issue 1", "body": "# Hello\n\nFirst issue.", "force": true}`
+	w, resp = serve(t, h, request{method: http.MethodPost, target: "/send", body: forced, admin: true})
+	if w.Code != http.StatusAccepted || resp.CampaignID == first {
+		t.Fatalf("forced send: status %d, %+v", w.Code, resp)
+	}
+}
+
+func TestScheduledSendRefusesDuplicates(t *testing.T) {
+	d, st, _ := newTestDeps(t)
+	subscribe(t, st, "a@example.com")
+	h := SendHandler(d)
+
+	if w, resp := serve(t, h, request{method: http.MethodPost, target: "/send", body: issue, admin: true}); w.Code != http.StatusAccepted {
+		t.Fatalf("send: status %d, %+v", w.Code, resp)
+	}
+
+	soon := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+	scheduled := fmt.Sprintf(`{"subject": "Issue 1", "body": "# Hello\n\nFirst issue.", "scheduled_at": %q}`, soon)
+	w, resp := serve(t, h, request{method: http.MethodPost, target: "/send", body: scheduled, admin: true})
+	if w.Code != http.StatusConflict {
+		t.Fatalf("scheduled duplicate: status %d, %+v; want 409", w.Code, resp)
+	}
+	if drafts, _ := st.Drafts(context.Background()); len(drafts) != 0 {
+		t.Errorf("refused send left %d drafts behind", len(drafts))
+	}
+
+	// Outside the window of the earlier send the same content is fine.
+	later := time.Now().Add(48 * time.Hour).UTC().Format(time.RFC3339)
+	scheduled = fmt.Sprintf(`{"subject": "Issue 1", "body": "# Hello\n\nFirst issue.", "scheduled_at": %q}`, later)
+	if w, resp := serve(t, h, request{method: http.MethodPost, target: "/send", body: scheduled, admin: true}); w.Code != http.StatusOK {
+		t.Fatalf("scheduled after the window: status %d, %+v", w.Code, resp)
+	}
+}
+
+func TestScheduleHandlerRefusesDuplicates(t *testing.T) {
+	d, st, _ := newTestDeps(t)
+	subscribe(t, st, "a@example.com")
+	if w, resp := serve(t, SendHandler(d), request{method: http.MethodPost, target: "/send", body: issue, admin: true}); w.Code != http.StatusAccepted {
+		t.Fatalf("send: status %d, %+v", w.Code, resp)
+	}
+	rev, err := st.CreateDraft(context.Background(), store.Draft{Subject: "Issue 1", Body: "# Hello\n\nFirst issue."})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h := ScheduleHandler(d)
+	target := fmt.Sprintf("/campaigns/%d/schedule", rev.CampaignID)
+	soon := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+	req := request{method: http.MethodPost, target: target, pattern: "/campaigns/{id}/schedule", admin: true,
+		body: fmt.Sprintf(`{"scheduled_at": %q}`, soon)}
+	if w, resp := serve(t, h, req); w.Code != http.StatusConflict {
+		t.Fatalf("schedule duplicate: status %d, %+v; want 409", w.Code, resp)
+	}
+
+	req.body = fmt.Sprintf(`{"scheduled_at": %q, "force": true}`, soon)
+	if w, resp := serve(t, h, req); w.Code != http.StatusOK {
+		t.Fatalf("forced schedule: status %d, %+v", w.Code, resp)
+	}
+}
+
+func TestScheduledCampaignsCountAsDuplicates(t *testing.T) {
+	d, st, _ := newTestDeps(t)
+	subscribe(t, st, "a@example.com")
+	h := SendHandler(d)
+
+	soon := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+	scheduled := fmt.Sprintf(`{"subject": "Issue 1", "body": "# Hello\n\nFirst issue.", "scheduled_at": %q}`, soon)
+	w, resp := serve(t, h, request{method: http.MethodPost, target: "/send", body: scheduled, admin: true})
+	if w.Code != http.StatusOK {
+		t.Fatalf("schedule: status %d, %+v", w.Code, resp)
+	}
+	first := resp.CampaignID
+
+	// A scheduled campaign has not been queued yet, but it will be.
+	w, resp = serve(t, h, request{method: http.MethodPost, target: "/send", body: scheduled, admin: true})
+	if w.Code != http.StatusConflict || resp.CampaignID != first {
+		t.Errorf("schedule twice: status %d, %+v; want 409 naming campaign %d", w.Code, resp, first)
+	}
+	w, resp = serve(t, h, request{method: http.MethodPost, target: "/send", body: issue, admin: true})
+	if w.Code != http.StatusConflict || resp.CampaignID != first {
+		t.Errorf("send now after scheduling: status %d, %+v; want 409 naming campaign %d", w.Code, resp, first)
+	}
+	if drafts, _ := st.Drafts(context.Background()); len(drafts) != 0 {
+		t.Errorf("refused sends left %d drafts behind", len(drafts))
+	}
+
+	// The scheduled campaign itself can still be moved or sent right away.
+	later := time.Now().Add(2 * time.Hour).UTC().Format(time.RFC3339)
+	move := request{method: http.MethodPut, target: fmt.Sprintf("/campaigns/%d/schedule", first), pattern: "/campaigns/{id}/schedule", admin: true,
+		body: fmt.Sprintf(`{"scheduled_at": %q}`, later)}
+	if w, resp := serve(t, ScheduleHandler(d), move); w.Code != http.StatusOK {
+		t.Errorf("reschedule: status %d, %+v", w.Code, resp)
+	}
+	now := request{method: http.MethodPost, target: fmt.Sprintf("/campaigns/%d/send", first), pattern: "/campaigns/{id}/send", admin: true}
+	if w, resp := serve(t, SendCampaignHandler(d), now); w.Code != http.StatusAccepted {
+		t.Errorf("send the scheduled campaign now: status %d, %+v", w.Code, resp)
+	}
+}
+
+func TestIdempotencyKey(t *testing.T) {
+	d, st, _ := newTestDeps(t)
+	subscribe(t, st, "a@example.com")
+	h := SendHandler(d)
+	markdown := request{
+		method:  http.MethodPost,
+		target:  "/send",
+		body:    "---\nsubject: Issue 1\n---\nFirst issue.",
+		headers: map[string]string{"Content-Type": "text/markdown", "Idempotency-Key": "k1"},
+		admin:   true,
+	}
+
+	w, first := serve(t, h, markdown)
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("first send: status %d, %+v", w.Code, first)
+	}
+
+	w, resp := serve(t, h, markdown)
+	if w.Code != http.StatusAccepted || w.Header().Get("Idempotent-Replayed") != "true" || resp.CampaignID != first.CampaignID {
+		t.Fatalf("retry: status %d, replayed %q, %+v; want the first response", w.Code, w.Header().Get("Idempotent-Replayed"), resp)
+	}
+
+	// The same key with force toggled is a different request, not a
+	// replay of the first one.
+	forced := markdown
+	forced.target = "/send?force=true"
+	if w, resp := serve(t, h, forced); w.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("key reused with force: status %d, %+v; want 422", w.Code, resp)
+	}
+
+	other := markdown
+	other.body = "---\nsubject: Issue 2\n---\nSecond issue."
+	if w, resp := serve(t, h, other); w.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("key reused with another body: status %d, %+v; want 422", w.Code, resp)
+	}
+}
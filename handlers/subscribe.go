@@ -2,51 +2,94 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
 	"strings"
 
-	database "github.com/pixperk/newsletter/db"
+	"github.com/pixperk/newsletter/store"
 )
 
 type SubscribeRequest struct {
-	Email string `json:"email"`
+	Email  string   `json:"email"`
+	Source string   `json:"source"`
+	Lists  []string `json:"lists"`
+	ProfileFields
 }
 
-func SubscribeHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		SendJSON(w, http.StatusMethodNotAllowed, JSONResponse{Error: "Method not allowed"})
-		return
-	}
+// SubscribeHandler is the direct subscription endpoint. What it does is
+// governed by d.Policy: under double opt-in it only sends the verification
+// email, under single opt-in it subscribes immediately.
+func SubscribeHandler(d *Deps) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			SendJSON(w, http.StatusMethodNotAllowed, JSONResponse{Error: "Method not allowed"})
+			return
+		}
 
-	var req SubscribeRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		SendJSON(w, http.StatusBadRequest, JSONResponse{Error: "Invalid JSON request"})
-		return
-	}
+		if d.Policy == PolicyVerifyOnly {
+			SendJSON(w, http.StatusGone, JSONResponse{Error: "Direct subscription is disabled, use /subscribe/verify"})
+			return
+		}
 
-	email := strings.TrimSpace(req.Email)
-	if email == "" {
-		SendJSON(w, http.StatusBadRequest, JSONResponse{Error: "Email is required"})
-		return
-	}
+		var req SubscribeRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			SendJSON(w, http.StatusBadRequest, JSONResponse{Error: "Invalid JSON request"})
+			return
+		}
 
-	var exists bool
-	err := database.DB.QueryRow(`SELECT EXISTS(SELECT 1 FROM subscribers WHERE email = $1)`, email).Scan(&exists)
-	if err != nil {
-		SendJSON(w, http.StatusInternalServerError, JSONResponse{Error: "Database error occurred"})
-		return
-	}
+		email := strings.TrimSpace(req.Email)
+		if email == "" {
+			SendJSON(w, http.StatusBadRequest, JSONResponse{Error: "Email is required"})
+			return
+		}
 
-	if exists {
-		SendJSON(w, http.StatusConflict, JSONResponse{Error: "Email is already subscribed to the newsletter"})
-		return
-	}
+		profile, err := req.profile()
+		if err != nil {
+			SendJSON(w, http.StatusBadRequest, JSONResponse{Error: err.Error()})
+			return
+		}
 
-	_, err = database.DB.Exec(`INSERT INTO subscribers (email) VALUES ($1)`, email)
-	if err != nil {
-		SendJSON(w, http.StatusInternalServerError, JSONResponse{Error: "Database error occurred"})
-		return
-	}
+		lists, err := publicLists(r, d, req.Lists)
+		var unknown *unknownListError
+		if errors.As(err, &unknown) {
+			SendJSON(w, http.StatusBadRequest, JSONResponse{Error: err.Error()})
+			return
+		}
+		if err != nil {
+			SendJSON(w, http.StatusInternalServerError, JSONResponse{Error: "Database error occurred"})
+			return
+		}
 
-	SendJSON(w, http.StatusOK, JSONResponse{Success: true, Message: "Successfully subscribed to newsletter! 🎉"})
+		c := consentFrom(r, req.Source, "subscribe")
+
+		if d.Policy == PolicyDouble {
+			sendVerification(w, r, d, email, c, profile, lists)
+			return
+		}
+
+		suppressed, err := d.Subscribers.Suppression(r.Context(), email)
+		if err != nil {
+			SendJSON(w, http.StatusInternalServerError, JSONResponse{Error: "Database error occurred"})
+			return
+		}
+
+		// Without a confirmation step there is no proof the owner changed
+		// their mind, so any suppression blocks the direct path.
+		if suppressed != "" {
+			SendJSON(w, http.StatusForbidden, JSONResponse{Error: "This email address cannot be subscribed"})
+			return
+		}
+
+		err = d.Subscribers.AddSubscriber(r.Context(), email, c, profile, lists)
+		if errors.Is(err, store.ErrConflict) {
+			SendJSON(w, http.StatusConflict, JSONResponse{Error: "Email is already subscribed to the newsletter"})
+			return
+		}
+		if err != nil {
+			SendJSON(w, http.StatusInternalServerError, JSONResponse{Error: "Database error occurred"})
+			return
+		}
+
+		SendJSON(w, http.StatusOK, JSONResponse{Success: true, Message: "Successfully subscribed to newsletter! 🎉"})
+	}
 }
@@ -0,0 +1,122 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"regexp"
+	"testing"
+
+	"github.com/pixperk/newsletter/store"
+)
+
+var verifyLink = regexp.MustCompile(`verify=([0-9a-f]{64})`)
+
+// verificationToken returns the token of the last verification email.
+func verificationToken(t *testing.T, mail *outbox, email string) string {
+	t.Helper()
+	sent := mail.messages()
+	if len(sent) == 0 {
+		t.Fatal("no verification email sent")
+	}
+	msg := sent[len(sent)-1]
+	if msg.To != email {
+		t.Fatalf("verification email went to %q, want %q", msg.To, email)
+	}
+	m := verifyLink.FindStringSubmatch(msg.HTML)
+	if m == nil {
+		t.Fatalf("no verification link in %q", msg.HTML)
+	}
+	return m[1]
+}
+
+func TestSubscribeAndVerify(t *testing.T) {
+	d, st, mail := newTestDeps(t)
+	ctx := context.Background()
+	subscribe := request{method: http.MethodPost, target: "/subscribe", body: `{"email": "ada@example.com", "first_name": "Ada"}`}
+
+	if w, resp := serve(t, SubscribeHandler(d), subscribe); w.Code != http.StatusOK {
+		t.Fatalf("subscribe: status %d, %+v", w.Code, resp)
+	}
+	if ok, _ := st.IsSubscribed(ctx, "ada@example.com"); ok {
+		t.Fatal("subscribed before confirming under double opt-in")
+	}
+	token := verificationToken(t, mail, "ada@example.com")
+
+	confirm := VerifyConfirmHandler(d)
+	if w, resp := serve(t, confirm, request{method: http.MethodPost, target: "/verify", body: `{"token": "0000"}`}); w.Code != http.StatusNotFound {
+		t.Fatalf("unknown token: status %d, %+v; want 404", w.Code, resp)
+	}
+	req := request{method: http.MethodPost, target: "/verify", body: `{"token": "` + token + `"}`}
+	if w, resp := serve(t, confirm, req); w.Code != http.StatusOK {
+		t.Fatalf("confirm: status %d, %+v", w.Code, resp)
+	}
+	sub, err := st.Subscriber(ctx, "ada@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !sub.Sendable() || sub.ConfirmedAt == nil || sub.Profile.FirstName != "Ada" || sub.Consent.Source != "subscribe" {
+		t.Errorf("subscriber after confirming = %+v", sub)
+	}
+
+	if w, resp := serve(t, confirm, req); w.Code != http.StatusConflict {
+		t.Errorf("second confirm: status %d, %+v; want 409", w.Code, resp)
+	}
+	if w, resp := serve(t, VerifySubscribeHandler(d), subscribe); w.Code != http.StatusConflict {
+		t.Errorf("subscribe again: status %d, %+v; want 409", w.Code, resp)
+	}
+}
+
+func TestSubscribeSinglePolicy(t *testing.T) {
+	d, st, mail := newTestDeps(t)
+	d.Policy = PolicySingle
+	req := request{method: http.MethodPost, target: "/subscribe", body: `{"email": "ada@example.com"}`}
+
+	if w, resp := serve(t, SubscribeHandler(d), req); w.Code != http.StatusOK {
+		t.Fatalf("subscribe: status %d, %+v", w.Code, resp)
+	}
+	if ok, _ := st.IsSubscribed(context.Background(), "ada@example.com"); !ok {
+		t.Error("not subscribed under single opt-in")
+	}
+	if n := len(mail.messages()); n != 0 {
+		t.Errorf("sent %d emails under single opt-in, want none", n)
+	}
+	if w, resp := serve(t, SubscribeHandler(d), req); w.Code != http.StatusConflict {
+		t.Errorf("subscribe again: status %d, %+v; want 409", w.Code, resp)
+	}
+
+	d.Policy = PolicyVerifyOnly
+	if w, resp := serve(t, SubscribeHandler(d), req); w.Code != http.StatusGone {
+		t.Errorf("subscribe under verify-only: status %d, %+v; want 410", w.Code, resp)
+	}
+}
+
+func TestSubscribeSuppressed(t *testing.T) {
+	tests := []struct {
+		status string
+		want   int
+	}{
+		// An unsubscriber may come back through double opt-in...
+		{store.StatusUnsubscribed, http.StatusOK},
+		// ...but bounced and complaining addresses may not.
+		{store.StatusBounced, http.StatusForbidden},
+		{store.StatusComplained, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.status, func(t *testing.T) {
+			d, st, _ := newTestDeps(t)
+			subscribe(t, st, "ada@example.com")
+			if _, err := st.Suppress(context.Background(), "ada@example.com", tt.status, ""); err != nil {
+				t.Fatal(err)
+			}
+			req := request{method: http.MethodPost, target: "/subscribe/verify", body: `{"email": "ada@example.com"}`}
+			if w, resp := serve(t, VerifySubscribeHandler(d), req); w.Code != tt.want {
+				t.Errorf("status %d, %+v; want %d", w.Code, resp, tt.want)
+			}
+
+			d.Policy = PolicySingle
+			if w, resp := serve(t, SubscribeHandler(d), req); w.Code != http.StatusForbidden {
+				t.Errorf("single opt-in: status %d, %+v; want 403", w.Code, resp)
+			}
+		})
+	}
+}
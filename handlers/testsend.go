@@ -2,61 +2,166 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
-	"os"
-	"strconv"
 	"strings"
-	"time"
 
+	"github.com/pixperk/newsletter/store"
 	"github.com/pixperk/newsletter/utils"
 )
 
+// maxTestRecipients caps a single test send.
+const maxTestRecipients = 20
+
+// TestSendRequest is either inline content or a campaign_id, optionally
+// with a revision (the latest by default). Recipients default to the
+// configured test recipients; Sample personalizes every copy as that
+// subscriber.
 type TestSendRequest struct {
-	Subject string `json:"subject"`
-	Body    string `json:"body"`
+	CampaignID  int64    `json:"campaign_id"`
+	Revision    int      `json:"revision"`
+	Subject     string   `json:"subject"`
+	Preheader   string   `json:"preheader"`
+	Body        string   `json:"body"`
+	Theme       string   `json:"theme"`
+	TrustedHTML bool     `json:"trusted_html"`
+	Recipients  []string `json:"recipients"`
+	Sample      string   `json:"sample"`
 }
 
-const testRecipient = "mishrayashaswikumar@gmail.com"
+// TestSendHandler serves POST /test-send, which mails a newsletter,
+// rendered exactly as /send would and with the subject prefixed [TEST], to
+// allowed test recipients only.
+func TestSendHandler(d *Deps) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			SendJSON(w, http.StatusMethodNotAllowed, JSONResponse{Error: "Method not allowed"})
+			return
+		}
 
-func TestSendHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		SendJSON(w, http.StatusMethodNotAllowed, JSONResponse{Error: "Method not allowed"})
-		return
-	}
+		if !requireSecret(w, r) {
+			return
+		}
 
-	if r.Header.Get("X-Secret") != os.Getenv("SEND_SECRET") {
-		SendJSON(w, http.StatusUnauthorized, JSONResponse{Error: "Unauthorized - invalid or missing X-Secret header"})
-		return
-	}
+		var req TestSendRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			SendJSON(w, http.StatusBadRequest, JSONResponse{Error: "Invalid JSON request"})
+			return
+		}
 
-	var req TestSendRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		SendJSON(w, http.StatusBadRequest, JSONResponse{Error: "Invalid JSON request"})
-		return
-	}
+		recipients := req.Recipients
+		if len(recipients) == 0 {
+			recipients = d.TestSends.Recipients
+		}
+		if len(recipients) == 0 {
+			SendJSON(w, http.StatusBadRequest, JSONResponse{Error: "Recipients are required; no TEST_RECIPIENTS are configured"})
+			return
+		}
+		if len(recipients) > maxTestRecipients {
+			SendJSON(w, http.StatusBadRequest, JSONResponse{Error: "At most 20 test recipients are allowed"})
+			return
+		}
 
-	if req.Subject == "" || req.Body == "" {
-		SendJSON(w, http.StatusBadRequest, JSONResponse{Error: "Subject and body are required"})
-		return
-	}
+		n, ok := requestedDraft(w, r, d, req.CampaignID, req.Revision, DraftRequest{Subject: req.Subject, Preheader: req.Preheader, Body: req.Body, Theme: req.Theme, TrustedHTML: req.TrustedHTML})
+		if !ok {
+			return
+		}
 
-	htmlBody := utils.MarkdownToHTML(req.Body)
+		var sample *store.Subscriber
+		if req.Sample != "" {
+			var err error
+			sample, err = d.Subscribers.Subscriber(r.Context(), strings.TrimSpace(req.Sample))
+			if errors.Is(err, store.ErrNotFound) {
+				SendJSON(w, http.StatusNotFound, JSONResponse{Error: "Sample subscriber not found"})
+				return
+			}
+			if err != nil {
+				SendJSON(w, http.StatusInternalServerError, JSONResponse{Error: "Database error occurred"})
+				return
+			}
+		}
 
-	footerHTML := ""
-	if f, err := os.ReadFile("footer.md"); err == nil {
-		content := strings.ReplaceAll(string(f), "{{YEAR}}", strconv.Itoa(time.Now().Year()))
-		footerHTML = utils.MarkdownToHTML(content)
-	}
-	if footerHTML != "" {
-		htmlBody += "<br>" + footerHTML
-	}
+		// Check every recipient before anything goes out.
+		contacts := make([]utils.Contact, 0, len(recipients))
+		for _, email := range recipients {
+			email = strings.TrimSpace(email)
+			sub, err := d.Subscribers.Subscriber(r.Context(), email)
+			if err != nil && !errors.Is(err, store.ErrNotFound) {
+				SendJSON(w, http.StatusInternalServerError, JSONResponse{Error: "Database error occurred"})
+				return
+			}
+			if !d.TestSends.allows(sub, email) {
+				SendJSON(w, http.StatusForbidden, JSONResponse{Error: email + " is not an allowed test recipient"})
+				return
+			}
+			suppressed, err := d.Subscribers.Suppression(r.Context(), email)
+			if err != nil {
+				SendJSON(w, http.StatusInternalServerError, JSONResponse{Error: "Database error occurred"})
+				return
+			}
+			if suppressed != "" {
+				SendJSON(w, http.StatusConflict, JSONResponse{Error: email + " is on the suppression list (" + suppressed + ")"})
+				return
+			}
 
-	htmlBody = utils.WrapInTemplate(req.Subject, htmlBody)
+			// Personalize as the sample, or as the recipient's own
+			// subscription, but keep the recipient's address so email and
+			// unsubscribe links never point at somebody else.
+			contact := utils.Contact{Email: email}
+			if sample != nil {
+				contact = contactFor(sample)
+			} else if sub != nil {
+				contact = contactFor(sub)
+			}
+			contact.Email = email
+			contacts = append(contacts, contact)
+		}
 
-	if err := utils.SendEmail(testRecipient, req.Subject, htmlBody); err != nil {
-		SendJSON(w, http.StatusInternalServerError, JSONResponse{Error: "Failed to send test email: " + err.Error()})
-		return
-	}
+		rendered, err := renderNewsletter(r.Context(), d, n)
+		if err != nil {
+			var invalid *invalidTemplateError
+			if errors.As(err, &invalid) {
+				SendJSON(w, http.StatusBadRequest, JSONResponse{Error: err.Error()})
+			} else {
+				SendJSON(w, http.StatusInternalServerError, JSONResponse{Error: "Database error occurred"})
+			}
+			return
+		}
+
+		msgs := make([]utils.Message, 0, len(contacts))
+		for _, contact := range contacts {
+			subject, html, text, err := rendered.Template.Render(contact)
+			if err != nil {
+				SendJSON(w, http.StatusBadRequest, JSONResponse{Error: "Invalid merge tags: " + err.Error()})
+				return
+			}
+			msgs = append(msgs, utils.Message{
+				To:      contact.Email,
+				ToName:  contact.Name(),
+				Subject: "[TEST] " + subject,
+				HTML:    html,
+				Text:    text,
+				Params:  contact.Params(),
+				Headers: utils.UnsubscribeHeaders(contact.Email),
+			})
+		}
 
-	SendJSON(w, http.StatusOK, JSONResponse{Success: true, Message: "Test email sent to " + testRecipient})
+		var sent, failed []string
+		for i, err := range utils.SendAll(r.Context(), d.Sender, msgs) {
+			if err != nil {
+				failed = append(failed, msgs[i].To+": "+err.Error())
+			} else {
+				sent = append(sent, msgs[i].To)
+			}
+		}
+		if len(failed) > 0 {
+			SendJSON(w, http.StatusInternalServerError, JSONResponse{
+				Error:      "Failed to send test email to " + strings.Join(failed, "; "),
+				EmailsSent: len(sent),
+			})
+			return
+		}
+
+		SendJSON(w, http.StatusOK, JSONResponse{Success: true, Message: "Test email sent to " + strings.Join(sent, ", "), EmailsSent: len(sent), Stripped: rendered.Stripped})
+	}
 }
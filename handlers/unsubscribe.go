@@ -2,51 +2,124 @@ package handlers
 
 import (
 	"encoding/json"
+	"html/template"
 	"net/http"
 	"strings"
 
-	database "github.com/pixperk/newsletter/db"
+	"github.com/pixperk/newsletter/store"
+	"github.com/pixperk/newsletter/utils"
 )
 
 type UnsubscribeRequest struct {
-	Email string `json:"email"`
+	Token  string `json:"token"`
+	Reason string `json:"reason"`
 }
 
-func UnsubscribeHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		SendJSON(w, http.StatusMethodNotAllowed, JSONResponse{Error: "Method not allowed"})
-		return
-	}
+// UnsubscribeHandler unsubscribes the owner of a signed token sent as JSON.
+func UnsubscribeHandler(d *Deps) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			SendJSON(w, http.StatusMethodNotAllowed, JSONResponse{Error: "Method not allowed"})
+			return
+		}
 
-	var req UnsubscribeRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		SendJSON(w, http.StatusBadRequest, JSONResponse{Error: "Invalid JSON request"})
-		return
-	}
+		var req UnsubscribeRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			SendJSON(w, http.StatusBadRequest, JSONResponse{Error: "Invalid JSON request"})
+			return
+		}
 
-	email := strings.TrimSpace(req.Email)
-	if email == "" {
-		SendJSON(w, http.StatusBadRequest, JSONResponse{Error: "Email is required"})
-		return
-	}
+		token := strings.TrimSpace(req.Token)
+		if token == "" {
+			SendJSON(w, http.StatusBadRequest, JSONResponse{Error: "Unsubscribe token is required"})
+			return
+		}
 
-	var exists bool
-	err := database.DB.QueryRow(`SELECT EXISTS(SELECT 1 FROM subscribers WHERE email = $1)`, email).Scan(&exists)
-	if err != nil {
-		SendJSON(w, http.StatusInternalServerError, JSONResponse{Error: "Database error occurred"})
-		return
-	}
+		email, err := utils.ParseUnsubscribeToken(token)
+		if err != nil {
+			SendJSON(w, http.StatusBadRequest, JSONResponse{Error: "Invalid unsubscribe token"})
+			return
+		}
+
+		found, err := d.Subscribers.Suppress(r.Context(), email, store.StatusUnsubscribed, strings.TrimSpace(req.Reason))
+		if err != nil {
+			SendJSON(w, http.StatusInternalServerError, JSONResponse{Error: "Database error occurred"})
+			return
+		}
+
+		if !found {
+			SendJSON(w, http.StatusNotFound, JSONResponse{Error: "Email not found in subscription list"})
+			return
+		}
 
-	if !exists {
-		SendJSON(w, http.StatusNotFound, JSONResponse{Error: "Email not found in subscription list"})
-		return
+		SendJSON(w, http.StatusOK, JSONResponse{Success: true, Message: "Successfully unsubscribed from newsletter 👋"})
 	}
+}
+
+// OneClickUnsubscribeHandler serves /unsubscribe/{token}, the link in every
+// newsletter and its List-Unsubscribe header. GET only shows a confirmation
+// form because link scanners prefetch URLs; POST, either from that form or
+// an RFC 8058 one-click request, performs the unsubscribe.
+func OneClickUnsubscribeHandler(d *Deps) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		email, err := utils.ParseUnsubscribeToken(r.PathValue("token"))
+		if err != nil {
+			renderUnsubscribePage(w, http.StatusBadRequest, unsubscribePage{Title: "Invalid link", Text: "This unsubscribe link is invalid or has been tampered with."})
+			return
+		}
 
-	_, err = database.DB.Exec(`DELETE FROM subscribers WHERE email = $1`, email)
-	if err != nil {
-		SendJSON(w, http.StatusInternalServerError, JSONResponse{Error: "Database error occurred"})
-		return
+		switch r.Method {
+		case http.MethodGet:
+			renderUnsubscribePage(w, http.StatusOK, unsubscribePage{
+				Title:   "Unsubscribe",
+				Text:    "Stop sending the pixperk newsletter to " + email + "?",
+				Confirm: true,
+			})
+		case http.MethodPost:
+			reason := strings.TrimSpace(r.PostFormValue("reason"))
+			if r.PostFormValue("List-Unsubscribe") == "One-Click" {
+				reason = "one-click"
+			}
+			if _, err := d.Subscribers.Suppress(r.Context(), email, store.StatusUnsubscribed, reason); err != nil {
+				renderUnsubscribePage(w, http.StatusInternalServerError, unsubscribePage{Title: "Something went wrong", Text: "Please try again in a moment."})
+				return
+			}
+			renderUnsubscribePage(w, http.StatusOK, unsubscribePage{Title: "Unsubscribed", Text: email + " will no longer receive the newsletter."})
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
 	}
+}
+
+type unsubscribePage struct {
+	Title   string
+	Text    string
+	Confirm bool
+}
+
+var unsubscribeTmpl = template.Must(template.New("unsubscribe").Parse(`<!doctype html>
+<html lang="en">
+<head>
+  <meta charset="UTF-8">
+  <meta name="viewport" content="width=device-width,initial-scale=1.0">
+  <title>{{.Title}} · pixperk</title>
+</head>
+<body style="margin:0;padding:40px 16px;background-color:#0a0a0a;font-family:-apple-system,BlinkMacSystemFont,'Segoe UI',Inter,Roboto,Helvetica,Arial,sans-serif;">
+  <div style="max-width:480px;margin:0 auto;background-color:#111111;border:1px solid rgba(255,255,255,0.06);border-radius:14px;padding:36px;">
+    <h1 style="margin:0 0 16px;font-size:21px;font-weight:600;color:#ececec;">{{.Title}}</h1>
+    <p style="margin:0 0 24px;color:#9a9a9a;font-size:15px;line-height:1.75;">{{.Text}}</p>
+    {{if .Confirm}}
+    <form method="post">
+      <input type="text" name="reason" placeholder="Why are you leaving? (optional)" style="box-sizing:border-box;width:100%;margin:0 0 16px;padding:10px 12px;border-radius:8px;border:1px solid rgba(255,255,255,0.1);background-color:#0a0a0a;color:#d4d4d4;font-size:14px;">
+      <button type="submit" style="border:none;border-radius:8px;background-color:#e0e0e0;color:#0a0a0a;padding:12px 32px;font-size:14px;font-weight:600;cursor:pointer;">Unsubscribe</button>
+    </form>
+    {{end}}
+  </div>
+</body>
+</html>`))
 
-	SendJSON(w, http.StatusOK, JSONResponse{Success: true, Message: "Successfully unsubscribed from newsletter 👋"})
+func renderUnsubscribePage(w http.ResponseWriter, statusCode int, page unsubscribePage) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(statusCode)
+	unsubscribeTmpl.Execute(w, page)
 }
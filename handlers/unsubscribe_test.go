@@ -0,0 +1,93 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/pixperk/newsletter/store"
+	"github.com/pixperk/newsletter/utils"
+)
+
+// status returns the subscriber status of email in st.
+func status(t *testing.T, st *store.Memory, email string) string {
+	t.Helper()
+	sub, err := st.Subscriber(context.Background(), email)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sub.Status
+}
+
+func TestUnsubscribe(t *testing.T) {
+	d, st, _ := newTestDeps(t)
+	subscribe(t, st, "ada@example.com")
+	h := UnsubscribeHandler(d)
+
+	// A token signed with another secret is a forgery.
+	t.Setenv("UNSUBSCRIBE_SECRET", "another-secret")
+	forged := utils.UnsubscribeToken("ada@example.com")
+	t.Setenv("UNSUBSCRIBE_SECRET", "unsubscribe-secret")
+	for _, token := range []string{forged, "not-a-token", ""} {
+		req := request{method: http.MethodPost, target: "/unsubscribe", body: `{"token": "` + token + `"}`}
+		if w, resp := serve(t, h, req); w.Code != http.StatusBadRequest {
+			t.Errorf("token %q: status %d, %+v; want 400", token, w.Code, resp)
+		}
+	}
+	if got := status(t, st, "ada@example.com"); got != store.StatusActive {
+		t.Fatalf("status after invalid tokens = %q, want active", got)
+	}
+
+	req := request{method: http.MethodPost, target: "/unsubscribe", body: `{"token": "` + utils.UnsubscribeToken("ada@example.com") + `", "reason": "too many"}`}
+	if w, resp := serve(t, h, req); w.Code != http.StatusOK {
+		t.Fatalf("unsubscribe: status %d, %+v", w.Code, resp)
+	}
+	if got := status(t, st, "ada@example.com"); got != store.StatusUnsubscribed {
+		t.Errorf("status = %q, want unsubscribed", got)
+	}
+	if reason, _ := st.Suppression(context.Background(), "ada@example.com"); reason != store.StatusUnsubscribed {
+		t.Errorf("suppression = %q, want unsubscribed", reason)
+	}
+
+	req.body = `{"token": "` + utils.UnsubscribeToken("bob@example.com") + `"}`
+	if w, resp := serve(t, h, req); w.Code != http.StatusNotFound {
+		t.Errorf("unknown subscriber: status %d, %+v; want 404", w.Code, resp)
+	}
+}
+
+func TestOneClickUnsubscribe(t *testing.T) {
+	d, st, _ := newTestDeps(t)
+	subscribe(t, st, "ada@example.com")
+	h := OneClickUnsubscribeHandler(d)
+	target := "/unsubscribe/" + utils.UnsubscribeToken("ada@example.com")
+
+	// Link scanners prefetch with GET, which must only show the form.
+	w, _ := serve(t, h, request{method: http.MethodGet, target: target, pattern: "/unsubscribe/{token}"})
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "<form") {
+		t.Fatalf("GET: status %d, body %q", w.Code, w.Body.String())
+	}
+	if got := status(t, st, "ada@example.com"); got != store.StatusActive {
+		t.Fatalf("status after GET = %q, want active", got)
+	}
+
+	oneClick := request{
+		method:  http.MethodPost,
+		target:  target,
+		pattern: "/unsubscribe/{token}",
+		body:    "List-Unsubscribe=One-Click",
+		headers: map[string]string{"Content-Type": "application/x-www-form-urlencoded"},
+	}
+	if w, _ := serve(t, h, oneClick); w.Code != http.StatusOK {
+		t.Fatalf("one-click POST: status %d, body %q", w.Code, w.Body.String())
+	}
+	if got := status(t, st, "ada@example.com"); got != store.StatusUnsubscribed {
+		t.Errorf("status after one-click = %q, want unsubscribed", got)
+	}
+
+	bad := oneClick
+	bad.target = "/unsubscribe/" + strings.Replace(utils.UnsubscribeToken("ada@example.com"), ".", ".x", 1)
+	if w, _ := serve(t, h, bad); w.Code != http.StatusBadRequest {
+		t.Errorf("tampered token: status %d, want 400", w.Code)
+	}
+}
@@ -0,0 +1,208 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pixperk/newsletter/store"
+	"github.com/pixperk/newsletter/utils"
+)
+
+// BrevoEvent is a transactional webhook payload as posted by Brevo.
+type BrevoEvent struct {
+	Event     string   `json:"event"`
+	Email     string   `json:"email"`
+	MessageID string   `json:"message-id"`
+	Reason    string   `json:"reason"`
+	Tags      []string `json:"tags"`
+	Date      string   `json:"date"`
+	TsEvent   int64    `json:"ts_event"`
+}
+
+// BouncePolicy decides when delivery feedback suppresses an address.
+type BouncePolicy struct {
+	HardBounceLimit int           // hard bounces before suppression
+	SoftBounceLimit int           // soft bounces within SoftWindow before suppression
+	SoftWindow      time.Duration // look-back window for soft bounces
+	SuppressBlocked bool          // suppress addresses Brevo reports as blocked
+}
+
+// BouncePolicyFromEnv reads BOUNCE_HARD_LIMIT, BOUNCE_SOFT_LIMIT,
+// BOUNCE_SOFT_WINDOW (a Go duration) and BOUNCE_SUPPRESS_BLOCKED.
+func BouncePolicyFromEnv() (BouncePolicy, error) {
+	p := BouncePolicy{
+		HardBounceLimit: 1,
+		SoftBounceLimit: 3,
+		SoftWindow:      30 * 24 * time.Hour,
+		SuppressBlocked: true,
+	}
+	var err error
+	if v := os.Getenv("BOUNCE_HARD_LIMIT"); v != "" {
+		if p.HardBounceLimit, err = strconv.Atoi(v); err != nil || p.HardBounceLimit < 1 {
+			return p, fmt.Errorf("invalid BOUNCE_HARD_LIMIT %q", v)
+		}
+	}
+	if v := os.Getenv("BOUNCE_SOFT_LIMIT"); v != "" {
+		if p.SoftBounceLimit, err = strconv.Atoi(v); err != nil || p.SoftBounceLimit < 1 {
+			return p, fmt.Errorf("invalid BOUNCE_SOFT_LIMIT %q", v)
+		}
+	}
+	if v := os.Getenv("BOUNCE_SOFT_WINDOW"); v != "" {
+		if p.SoftWindow, err = time.ParseDuration(v); err != nil {
+			return p, fmt.Errorf("invalid BOUNCE_SOFT_WINDOW %q", v)
+		}
+	}
+	if v := os.Getenv("BOUNCE_SUPPRESS_BLOCKED"); v != "" {
+		if p.SuppressBlocked, err = strconv.ParseBool(v); err != nil {
+			return p, fmt.Errorf("invalid BOUNCE_SUPPRESS_BLOCKED %q", v)
+		}
+	}
+	return p, nil
+}
+
+// BrevoWebhookHandler ingests Brevo transactional events. Requests must
+// carry BREVO_WEBHOOK_SECRET as a bearer token, the basic-auth password or
+// a token query parameter, depending on what the webhook is configured
+// with in Brevo.
+func BrevoWebhookHandler(d *Deps) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			SendJSON(w, http.StatusMethodNotAllowed, JSONResponse{Error: "Method not allowed"})
+			return
+		}
+
+		if !webhookAuthorized(r) {
+			SendJSON(w, http.StatusUnauthorized, JSONResponse{Error: "Unauthorized"})
+			return
+		}
+
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			SendJSON(w, http.StatusBadRequest, JSONResponse{Error: "Invalid request body"})
+			return
+		}
+
+		// Brevo posts single events, but batched deliveries arrive as an
+		// array.
+		var events []BrevoEvent
+		if trimmed := bytes.TrimSpace(body); len(trimmed) > 0 && trimmed[0] == '[' {
+			err = json.Unmarshal(trimmed, &events)
+		} else {
+			var ev BrevoEvent
+			err = json.Unmarshal(trimmed, &ev)
+			events = []BrevoEvent{ev}
+		}
+		if err != nil {
+			SendJSON(w, http.StatusBadRequest, JSONResponse{Error: "Invalid JSON request"})
+			return
+		}
+
+		for _, ev := range events {
+			if err := recordEvent(r.Context(), d, ev); err != nil {
+				log.Printf("webhook: failed to record %s for %s: %v", ev.Event, ev.Email, err)
+				SendJSON(w, http.StatusInternalServerError, JSONResponse{Error: "Database error occurred"})
+				return
+			}
+		}
+
+		SendJSON(w, http.StatusOK, JSONResponse{Success: true})
+	}
+}
+
+func webhookAuthorized(r *http.Request) bool {
+	secret := os.Getenv("BREVO_WEBHOOK_SECRET")
+	if secret == "" {
+		return false
+	}
+	var got string
+	if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		got = token
+	} else if _, pass, ok := r.BasicAuth(); ok {
+		got = pass
+	} else {
+		got = r.URL.Query().Get("token")
+	}
+	return subtle.ConstantTimeCompare([]byte(got), []byte(secret)) == 1
+}
+
+// recordEvent stores ev and applies the bounce policy to its address.
+// Events Brevo redelivers are recorded once.
+func recordEvent(ctx context.Context, d *Deps, ev BrevoEvent) error {
+	email := strings.TrimSpace(ev.Email)
+	if email == "" || ev.Event == "" {
+		return nil
+	}
+
+	occurredAt := time.Now()
+	if ev.TsEvent > 0 {
+		occurredAt = time.Unix(ev.TsEvent, 0)
+	}
+
+	campaignID, _ := utils.ParseCampaignTag(ev.Tags)
+
+	recorded, err := d.Events.RecordEvent(ctx, store.Event{
+		Email:      email,
+		Event:      ev.Event,
+		CampaignID: campaignID,
+		MessageID:  ev.MessageID,
+		Reason:     ev.Reason,
+		OccurredAt: occurredAt,
+	})
+	if err != nil || !recorded {
+		return err
+	}
+
+	status, err := d.Bounces.verdict(ctx, d.Events, email, ev.Event)
+	if err != nil {
+		return err
+	}
+	if status != "" {
+		reason := ev.Event
+		if ev.Reason != "" {
+			reason += ": " + ev.Reason
+		}
+		if _, err := d.Subscribers.Suppress(ctx, email, status, reason); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// verdict returns the subscriber status an event should lead to, or "" if
+// the address stays deliverable.
+func (p BouncePolicy) verdict(ctx context.Context, events store.EventStore, email, event string) (string, error) {
+	switch event {
+	case "spam":
+		return store.StatusComplained, nil
+	case "unsubscribed":
+		return store.StatusUnsubscribed, nil
+	case "blocked":
+		if p.SuppressBlocked {
+			return store.StatusBounced, nil
+		}
+		return "", nil
+	case "hard_bounce", "invalid_email":
+		n, err := events.CountEvents(ctx, email, time.Time{}, "hard_bounce", "invalid_email")
+		if err != nil || n < p.HardBounceLimit {
+			return "", err
+		}
+		return store.StatusBounced, nil
+	case "soft_bounce":
+		n, err := events.CountEvents(ctx, email, time.Now().Add(-p.SoftWindow), "soft_bounce")
+		if err != nil || n < p.SoftBounceLimit {
+			return "", err
+		}
+		return store.StatusBounced, nil
+	}
+	return "", nil
+}
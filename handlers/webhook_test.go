@@ -0,0 +1,191 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/pixperk/newsletter/store"
+)
+
+const webhookSecret = "webhook-secret"
+
+// webhook posts body to the Brevo webhook with the shared secret.
+func webhook(t *testing.T, d *Deps, body string) {
+	t.Helper()
+	w, resp := serve(t, BrevoWebhookHandler(d), request{
+		method:  http.MethodPost,
+		target:  "/webhooks/brevo",
+		body:    body,
+		headers: map[string]string{"Authorization": "Bearer " + webhookSecret},
+	})
+	if w.Code != http.StatusOK {
+		t.Fatalf("webhook %s: status %d, %+v", body, w.Code, resp)
+	}
+}
+
+func event(name, email string, ts time.Time) string {
+	return fmt.Sprintf(`{"event": %q, "email": %q, "message-id": "<m%d@example.com>", "ts_event": %d, "reason": "mailbox full"}`,
+		name, email, ts.Unix(), ts.Unix())
+}
+
+func TestWebhookAuthorization(t *testing.T) {
+	d, st, _ := newTestDeps(t)
+	subscribe(t, st, "ada@example.com")
+	body := event("hard_bounce", "ada@example.com", time.Now())
+
+	t.Setenv("BREVO_WEBHOOK_SECRET", "")
+	if w, _ := serve(t, BrevoWebhookHandler(d), request{method: http.MethodPost, target: "/webhooks/brevo", body: body}); w.Code != http.StatusUnauthorized {
+		t.Errorf("without a configured secret: status %d, want 401", w.Code)
+	}
+
+	t.Setenv("BREVO_WEBHOOK_SECRET", webhookSecret)
+	for _, req := range []request{
+		{method: http.MethodPost, target: "/webhooks/brevo", body: body},
+		{method: http.MethodPost, target: "/webhooks/brevo?token=wrong", body: body},
+		{method: http.MethodPost, target: "/webhooks/brevo", body: body, headers: map[string]string{"Authorization": "Bearer wrong"}},
+	} {
+		if w, _ := serve(t, BrevoWebhookHandler(d), req); w.Code != http.StatusUnauthorized {
+			t.Errorf("%s %v: status %d, want 401", req.target, req.headers, w.Code)
+		}
+	}
+	if got := status(t, st, "ada@example.com"); got != store.StatusActive {
+		t.Errorf("unauthorized events changed the status to %q", got)
+	}
+
+	if w, _ := serve(t, BrevoWebhookHandler(d), request{method: http.MethodPost, target: "/webhooks/brevo?token=" + webhookSecret, body: body}); w.Code != http.StatusOK {
+		t.Errorf("token parameter: status %d, want 200", w.Code)
+	}
+}
+
+func TestWebhookSuppression(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name   string
+		events []string
+		want   string
+	}{
+		{"hard bounce", []string{event("hard_bounce", "ada@example.com", now)}, store.StatusBounced},
+		{"invalid email", []string{event("invalid_email", "ada@example.com", now)}, store.StatusBounced},
+		{"complaint", []string{event("spam", "ada@example.com", now)}, store.StatusComplained},
+		{"blocked", []string{event("blocked", "ada@example.com", now)}, store.StatusBounced},
+		{"unsubscribed", []string{event("unsubscribed", "ada@example.com", now)}, store.StatusUnsubscribed},
+		{"delivered", []string{event("delivered", "ada@example.com", now)}, store.StatusActive},
+		{"soft bounces below the limit", []string{
+			event("soft_bounce", "ada@example.com", now.Add(-2*time.Hour)),
+			event("soft_bounce", "ada@example.com", now.Add(-time.Hour)),
+		}, store.StatusActive},
+		{"soft bounces at the limit", []string{
+			event("soft_bounce", "ada@example.com", now.Add(-2*time.Hour)),
+			event("soft_bounce", "ada@example.com", now.Add(-time.Hour)),
+			event("soft_bounce", "ada@example.com", now),
+		}, store.StatusBounced},
+		// Brevo redelivers events; the same one counts once.
+		{"redelivered soft bounce", []string{
+			event("soft_bounce", "ada@example.com", now),
+			event("soft_bounce", "ada@example.com", now),
+			event("soft_bounce", "ada@example.com", now),
+		}, store.StatusActive},
+		{"batched", []string{"[" +
+			event("soft_bounce", "ada@example.com", now.Add(-2*time.Hour)) + "," +
+			event("soft_bounce", "ada@example.com", now.Add(-time.Hour)) + "," +
+			event("soft_bounce", "ada@example.com", now) + "]",
+		}, store.StatusBounced},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d, st, _ := newTestDeps(t)
+			t.Setenv("BREVO_WEBHOOK_SECRET", webhookSecret)
+			subscribe(t, st, "ada@example.com", "bob@example.com")
+
+			for _, body := range tt.events {
+				webhook(t, d, body)
+			}
+			if got := status(t, st, "ada@example.com"); got != tt.want {
+				t.Errorf("status = %q, want %q", got, tt.want)
+			}
+			if got := status(t, st, "bob@example.com"); got != store.StatusActive {
+				t.Errorf("another subscriber's status = %q, want active", got)
+			}
+
+			reason, err := st.Suppression(context.Background(), "ada@example.com")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.want == store.StatusActive && reason != "" {
+				t.Errorf("suppressed as %q, want deliverable", reason)
+			}
+			if tt.want != store.StatusActive && reason != tt.want {
+				t.Errorf("suppression = %q, want %q", reason, tt.want)
+			}
+		})
+	}
+}
+
+func TestBouncePolicyVerdict(t *testing.T) {
+	p := BouncePolicy{HardBounceLimit: 2, SoftBounceLimit: 2, SoftWindow: 24 * time.Hour, SuppressBlocked: false}
+	now := time.Now()
+	tests := []struct {
+		name  string
+		prior []store.Event // already recorded, besides the event itself
+		event string
+		want  string
+	}{
+		{"first hard bounce", nil, "hard_bounce", ""},
+		{"hard bounce at the limit", []store.Event{{Event: "hard_bounce", OccurredAt: now.AddDate(-1, 0, 0)}}, "hard_bounce", store.StatusBounced},
+		{"invalid email counts as hard", []store.Event{{Event: "hard_bounce", OccurredAt: now}}, "invalid_email", store.StatusBounced},
+		{"first soft bounce", nil, "soft_bounce", ""},
+		{"soft bounce at the limit", []store.Event{{Event: "soft_bounce", OccurredAt: now.Add(-time.Hour)}}, "soft_bounce", store.StatusBounced},
+		{"soft bounce outside the window", []store.Event{{Event: "soft_bounce", OccurredAt: now.Add(-48 * time.Hour)}}, "soft_bounce", ""},
+		{"hard bounces do not count as soft", []store.Event{{Event: "hard_bounce", OccurredAt: now}}, "soft_bounce", ""},
+		{"blocked without SuppressBlocked", nil, "blocked", ""},
+		{"complaint", nil, "spam", store.StatusComplained},
+		{"unsubscribed", nil, "unsubscribed", store.StatusUnsubscribed},
+		{"opened", nil, "opened", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			st := store.NewMemory()
+			ctx := context.Background()
+			for i, ev := range append(tt.prior, store.Event{Event: tt.event, OccurredAt: now}) {
+				ev.Email = "ada@example.com"
+				ev.MessageID = fmt.Sprint(i)
+				if _, err := st.RecordEvent(ctx, ev); err != nil {
+					t.Fatal(err)
+				}
+			}
+			got, err := p.verdict(ctx, st, "ada@example.com", tt.event)
+			if err != nil || got != tt.want {
+				t.Errorf("verdict = %q, %v; want %q", got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestBouncePolicyFromEnv(t *testing.T) {
+	t.Setenv("BOUNCE_HARD_LIMIT", "2")
+	t.Setenv("BOUNCE_SOFT_LIMIT", "5")
+	t.Setenv("BOUNCE_SOFT_WINDOW", "72h")
+	t.Setenv("BOUNCE_SUPPRESS_BLOCKED", "false")
+	p, err := BouncePolicyFromEnv()
+	want := BouncePolicy{HardBounceLimit: 2, SoftBounceLimit: 5, SoftWindow: 72 * time.Hour, SuppressBlocked: false}
+	if err != nil || p != want {
+		t.Errorf("BouncePolicyFromEnv() = %+v, %v; want %+v", p, err, want)
+	}
+
+	for _, env := range []struct{ key, value string }{
+		{"BOUNCE_HARD_LIMIT", "0"},
+		{"BOUNCE_SOFT_LIMIT", "many"},
+		{"BOUNCE_SOFT_WINDOW", "30"},
+		{"BOUNCE_SUPPRESS_BLOCKED", "maybe"},
+	} {
+		t.Run(env.key, func(t *testing.T) {
+			t.Setenv(env.key, env.value)
+			if _, err := BouncePolicyFromEnv(); err == nil {
+				t.Errorf("%s=%s accepted", env.key, env.value)
+			}
+		})
+	}
+}
@@ -9,10 +9,15 @@ import (
 	"os/signal"
 	"syscall"
 	"time"
+	_ "time/tzdata" // time zones for scheduled sends on hosts without zoneinfo
 
 	"github.com/joho/godotenv"
 	database "github.com/pixperk/newsletter/db"
 	"github.com/pixperk/newsletter/handlers"
+	"github.com/pixperk/newsletter/queue"
+	"github.com/pixperk/newsletter/store"
+	"github.com/pixperk/newsletter/templates"
+	"github.com/pixperk/newsletter/utils"
 )
 
 var allowedOrigins = map[string]bool{
@@ -38,8 +43,8 @@ func enableCORS(next http.HandlerFunc) http.HandlerFunc {
 			w.Header().Set("Access-Control-Allow-Origin", origin)
 			w.Header().Set("Vary", "Origin")
 		}
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-Secret")
+		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE, OPTIONS")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-Secret, Idempotency-Key")
 		w.Header().Set("Access-Control-Max-Age", "86400")
 
 		if r.Method == "OPTIONS" {
@@ -111,18 +116,111 @@ func main() {
 	database.InitDB()
 	defer database.DB.Close()
 
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		runMigrate(os.Args[2:])
+		return
+	}
+
+	if os.Getenv("UNSUBSCRIBE_SECRET") == "" {
+		log.Fatal("UNSUBSCRIBE_SECRET must be set to sign unsubscribe links")
+	}
+
+	// Bring the schema up to date before serving; concurrent replicas
+	// wait on the migration lock.
+	if n, err := database.MigrateUp(context.Background(), database.DB); err != nil {
+		log.Fatalf("Migration failed: %v", err)
+	} else if n > 0 {
+		log.Printf("Applied %d migration(s)", n)
+	}
+
+	sender, err := utils.NewEmailSenderFromEnv()
+	if err != nil {
+		log.Fatalf("Email sender error: %v", err)
+	}
+
+	policy, err := handlers.SubscriptionPolicyFromEnv()
+	if err != nil {
+		log.Fatalf("Config error: %v", err)
+	}
+
+	bouncePolicy, err := handlers.BouncePolicyFromEnv()
+	if err != nil {
+		log.Fatalf("Config error: %v", err)
+	}
+
+	sendPolicy, err := handlers.SendPolicyFromEnv()
+	if err != nil {
+		log.Fatalf("Config error: %v", err)
+	}
+
+	testSendPolicy, err := handlers.TestSendPolicyFromEnv()
+	if err != nil {
+		log.Fatalf("Config error: %v", err)
+	}
+
+	themes, err := templates.Load(os.Getenv("THEMES_DIR"), os.Getenv("DEFAULT_THEME"))
+	if err != nil {
+		log.Fatalf("Config error: %v", err)
+	}
+
+	st := store.NewPostgres(database.DB)
+
+	workerCtx, stopWorkers := context.WithCancel(context.Background())
+	pool := queue.NewPool(st, st, sender, 20)
+	pool.Start(workerCtx)
+	scheduler := queue.NewScheduler(st, pool, sendPolicy.DuplicateWindow)
+	scheduler.Start(workerCtx)
+
+	deps := &handlers.Deps{
+		Subscribers:   st,
+		Verifications: st,
+		Campaigns:     st,
+		Drafts:        st,
+		Lists:         st,
+		Idempotency:   st,
+		Events:        st,
+		Sender:        sender,
+		Queue:         pool,
+		Policy:        policy,
+		Bounces:       bouncePolicy,
+		Sends:         sendPolicy,
+		TestSends:     testSendPolicy,
+		Themes:        themes,
+	}
+
 	emailRateLimit := handlers.NewRateLimiter(5, 15*time.Minute)
 	generalRateLimit := handlers.NewRateLimiter(100, 15*time.Minute)
 	adminRateLimit := handlers.NewRateLimiter(10, 1*time.Hour)
+	webhookRateLimit := handlers.NewRateLimiter(5000, 15*time.Minute)
+	// Mail providers send one-click unsubscribes (RFC 8058) in bursts from
+	// a few shared IPs; the signed token already stops forgery.
+	oneClickRateLimit := handlers.NewRateLimiter(5000, 15*time.Minute)
 
 	http.HandleFunc("/ping", wrap(PingHandler, generalRateLimit))
 	http.HandleFunc("/health", wrap(HealthCheckHandler, generalRateLimit))
-	http.HandleFunc("/subscribe", wrap(handlers.SubscribeHandler, emailRateLimit))
-	http.HandleFunc("/subscribe/verify", wrap(handlers.VerifySubscribeHandler, emailRateLimit))
-	http.HandleFunc("/subscribe/confirm", wrap(handlers.VerifyConfirmHandler, emailRateLimit))
-	http.HandleFunc("/unsubscribe", wrap(handlers.UnsubscribeHandler, emailRateLimit))
-	http.HandleFunc("/send", wrap(handlers.SendHandler(database.DB), adminRateLimit))
-	http.HandleFunc("/test-send", wrap(handlers.TestSendHandler, adminRateLimit))
+	http.HandleFunc("/subscribe", wrap(handlers.SubscribeHandler(deps), emailRateLimit))
+	http.HandleFunc("/subscribe/verify", wrap(handlers.VerifySubscribeHandler(deps), emailRateLimit))
+	http.HandleFunc("/subscribe/confirm", wrap(handlers.VerifyConfirmHandler(deps), emailRateLimit))
+	http.HandleFunc("/unsubscribe", wrap(handlers.UnsubscribeHandler(deps), emailRateLimit))
+	http.HandleFunc("/unsubscribe/{token}", wrap(handlers.OneClickUnsubscribeHandler(deps), oneClickRateLimit))
+	http.HandleFunc("/preferences", wrap(handlers.PreferencesLinkHandler(deps), emailRateLimit))
+	http.HandleFunc("/preferences/{token}", wrap(handlers.PreferencesHandler(deps), generalRateLimit))
+	http.HandleFunc("/send", wrap(handlers.SendHandler(deps), adminRateLimit))
+	http.HandleFunc("/campaigns", wrap(handlers.CampaignsHandler(deps), generalRateLimit))
+	http.HandleFunc("/campaigns/{id}", wrap(handlers.CampaignHandler(deps), generalRateLimit))
+	http.HandleFunc("/campaigns/{id}/revisions", wrap(handlers.RevisionsHandler(deps), generalRateLimit))
+	http.HandleFunc("/campaigns/{id}/revisions/{revision}", wrap(handlers.RevisionsHandler(deps), generalRateLimit))
+	http.HandleFunc("/campaigns/{id}/diff", wrap(handlers.RevisionDiffHandler(deps), generalRateLimit))
+	http.HandleFunc("/campaigns/{id}/send", wrap(handlers.SendCampaignHandler(deps), adminRateLimit))
+	http.HandleFunc("/campaigns/{id}/schedule", wrap(handlers.ScheduleHandler(deps), adminRateLimit))
+	http.HandleFunc("/subscribers/{email}", wrap(handlers.SubscriberHandler(deps), generalRateLimit))
+	http.HandleFunc("/lists", wrap(handlers.ListsHandler(deps), generalRateLimit))
+	http.HandleFunc("/lists/{slug}", wrap(handlers.ListHandler(deps), generalRateLimit))
+	http.HandleFunc("/segments", wrap(handlers.SegmentsHandler(deps), generalRateLimit))
+	http.HandleFunc("/segments/{slug}", wrap(handlers.SegmentHandler(deps), generalRateLimit))
+	http.HandleFunc("/webhooks/brevo", wrap(handlers.BrevoWebhookHandler(deps), webhookRateLimit))
+	http.HandleFunc("/preview", wrap(handlers.PreviewHandler(deps), adminRateLimit))
+	http.HandleFunc("/test-send", wrap(handlers.TestSendHandler(deps), adminRateLimit))
 
 	port := os.Getenv("PORT")
 	if port == "" {
@@ -154,5 +252,9 @@ func main() {
 	if err := srv.Shutdown(ctx); err != nil {
 		log.Fatalf("Server forced to shutdown: %v", err)
 	}
+
+	stopWorkers()
+	pool.Wait()
+	scheduler.Wait()
 	log.Println("Server stopped gracefully")
 }
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+
+	database "github.com/pixperk/newsletter/db"
+)
+
+const migrateUsage = `usage: newsletter-api migrate [up | down [N] | status]`
+
+// runMigrate implements the migrate subcommand.
+func runMigrate(args []string) {
+	ctx := context.Background()
+
+	cmd := "up"
+	if len(args) > 0 {
+		cmd = args[0]
+	}
+
+	switch cmd {
+	case "up":
+		n, err := database.MigrateUp(ctx, database.DB)
+		if err != nil {
+			log.Fatalf("Migration failed: %v", err)
+		}
+		log.Printf("Applied %d migration(s)", n)
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			var err error
+			if steps, err = strconv.Atoi(args[1]); err != nil || steps < 1 {
+				log.Fatal(migrateUsage)
+			}
+		}
+		n, err := database.MigrateDown(ctx, database.DB, steps)
+		if err != nil {
+			log.Fatalf("Migration failed: %v", err)
+		}
+		log.Printf("Reverted %d migration(s)", n)
+	case "status":
+		states, err := database.MigrationStatus(ctx, database.DB)
+		if err != nil {
+			log.Fatalf("Migration status failed: %v", err)
+		}
+		for _, s := range states {
+			applied := "pending"
+			if s.AppliedAt != nil {
+				applied = s.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+			fmt.Printf("%04d  %-24s %s\n", s.Version, s.Name, applied)
+		}
+	default:
+		fmt.Fprintln(os.Stderr, migrateUsage)
+		os.Exit(2)
+	}
+}
@@ -0,0 +1,319 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/pixperk/newsletter/store"
+	"github.com/pixperk/newsletter/utils"
+)
+
+const (
+	// leaseDuration is how long a worker owns a claimed delivery. If the
+	// process dies mid-send the row becomes claimable again afterwards.
+	leaseDuration = 2 * time.Minute
+	pollInterval  = 5 * time.Second
+	// maxAttempts bounds how often a delivery is claimed, whether it came
+	// back because of a retryable provider error or because its worker
+	// disappeared without settling it.
+	maxAttempts = 5
+	// retryDelay is the minimum wait before a delivery that failed with a
+	// retryable error is claimed again; it grows with every attempt.
+	retryDelay = time.Minute
+	// maxClaim bounds a claim for batch senders. Campaign messages carry
+	// their own List-Unsubscribe, which a batch cannot, so utils.SendAll
+	// sends them one by one and all of them must settle within the lease.
+	maxClaim = 50
+)
+
+// Pool drains pending deliveries with a fixed number of workers. Several
+// pools (e.g. replicas) may share a database; the CampaignStore leases
+// rows so each delivery has one owner.
+type Pool struct {
+	campaigns   store.CampaignStore
+	subscribers store.SubscriberStore
+	sender      utils.EmailSender
+	workers     int
+	batchSize   int
+	wake        chan struct{}
+	wg          sync.WaitGroup
+
+	mu    sync.Mutex
+	cache map[int64]*utils.MergeTemplate
+}
+
+func NewPool(campaigns store.CampaignStore, subscribers store.SubscriberStore, sender utils.EmailSender, workers int) *Pool {
+	if workers < 1 {
+		workers = 1
+	}
+	batchSize := 1
+	if bs, ok := sender.(utils.BatchSender); ok {
+		batchSize = min(bs.MaxBatchSize(), maxClaim)
+	}
+	return &Pool{
+		campaigns:   campaigns,
+		subscribers: subscribers,
+		sender:      sender,
+		workers:     workers,
+		batchSize:   batchSize,
+		wake:        make(chan struct{}, workers),
+		cache:       make(map[int64]*utils.MergeTemplate),
+	}
+}
+
+// Start resumes unfinished campaigns and launches the workers. They stop
+// when ctx is cancelled; use Wait to block until they have exited.
+func (p *Pool) Start(ctx context.Context) {
+	p.resume(ctx)
+	for i := 0; i < p.workers; i++ {
+		p.wg.Add(1)
+		go p.run(ctx)
+	}
+}
+
+// Wait blocks until all workers have stopped.
+func (p *Pool) Wait() {
+	p.wg.Wait()
+}
+
+// Notify wakes idle workers so newly enqueued deliveries are picked up
+// without waiting for the next poll.
+func (p *Pool) Notify() {
+	for i := 0; i < p.workers; i++ {
+		select {
+		case p.wake <- struct{}{}:
+		default:
+			return
+		}
+	}
+}
+
+func (p *Pool) resume(ctx context.Context) {
+	// Campaigns whose last delivery settled right before a crash never got
+	// their finished_at stamped.
+	if err := p.campaigns.FinishSettledCampaigns(ctx); err != nil {
+		log.Printf("queue: failed to close finished campaigns: %v", err)
+	}
+
+	campaigns, pending, err := p.campaigns.PendingDeliveries(ctx)
+	if err != nil {
+		log.Printf("queue: failed to inspect unfinished campaigns: %v", err)
+		return
+	}
+	if pending > 0 {
+		log.Printf("queue: resuming %d unfinished campaign(s) with %d pending deliveries", campaigns, pending)
+	}
+}
+
+func (p *Pool) run(ctx context.Context) {
+	defer p.wg.Done()
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	for {
+		worked, err := p.processBatch(ctx)
+		if err != nil && ctx.Err() == nil {
+			log.Printf("queue: %v", err)
+		}
+		if worked {
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-p.wake:
+		case <-ticker.C:
+		}
+	}
+}
+
+// processBatch claims up to batchSize pending deliveries of one campaign
+// and settles them. It reports whether any delivery was found.
+func (p *Pool) processBatch(ctx context.Context) (bool, error) {
+	claimed, err := p.campaigns.ClaimDeliveries(ctx, p.batchSize, leaseDuration)
+	if err != nil {
+		return false, err
+	}
+	if len(claimed) == 0 {
+		return false, nil
+	}
+	campaignID := claimed[0].CampaignID
+	bg := context.WithoutCancel(ctx)
+
+	// Deliveries that keep coming back are settled before anything else
+	// can fail, so a campaign that cannot be loaded is not claimed forever.
+	var live []store.Delivery
+	for _, d := range claimed {
+		if d.Attempts > maxAttempts {
+			if err := p.campaigns.SettleDelivery(bg, d.ID, store.DeliveryFailed, "gave up after repeated attempts"); err != nil {
+				return true, err
+			}
+			continue
+		}
+		live = append(live, d)
+	}
+	if len(live) == 0 {
+		return true, p.finish(bg, campaignID)
+	}
+
+	if err := p.campaigns.MarkCampaignStarted(ctx, campaignID); err != nil {
+		return true, p.postpone(bg, live, err)
+	}
+
+	tmpl, err := p.template(ctx, campaignID)
+	if errors.Is(err, errBadTemplate) {
+		// Every recipient would fail the same way.
+		for _, d := range live {
+			if serr := p.campaigns.SettleDelivery(bg, d.ID, store.DeliveryFailed, err.Error()); serr != nil {
+				return true, serr
+			}
+		}
+		if ferr := p.finish(bg, campaignID); ferr != nil {
+			return true, ferr
+		}
+		return true, err
+	}
+	if err != nil {
+		return true, p.postpone(bg, live, err)
+	}
+
+	var toSend []store.Delivery
+	var msgs []utils.Message
+	for _, d := range live {
+		sub, err := p.subscribers.Subscriber(ctx, d.Email)
+		if err != nil && !errors.Is(err, store.ErrNotFound) {
+			return true, err
+		}
+		if sub == nil || !sub.Sendable() {
+			if err := p.campaigns.SettleDelivery(ctx, d.ID, store.DeliverySkipped, "unsubscribed or suppressed"); err != nil {
+				return true, err
+			}
+			continue
+		}
+
+		contact := utils.Contact{
+			Email:        sub.Email,
+			FirstName:    sub.Profile.FirstName,
+			LastName:     sub.Profile.LastName,
+			SubscribedAt: sub.CreatedAt,
+			Attributes:   sub.Profile.Attributes,
+		}
+		subject, html, text, err := tmpl.Render(contact)
+		if err != nil {
+			if err := p.campaigns.SettleDelivery(ctx, d.ID, store.DeliveryFailed, "render: "+err.Error()); err != nil {
+				return true, err
+			}
+			continue
+		}
+
+		toSend = append(toSend, d)
+		msgs = append(msgs, utils.Message{
+			To:      d.Email,
+			ToName:  contact.Name(),
+			Subject: subject,
+			HTML:    html,
+			Text:    text,
+			Params:  contact.Params(),
+			Headers: utils.UnsubscribeHeaders(d.Email),
+			Tags:    []string{utils.CampaignTag(campaignID)},
+		})
+	}
+
+	errs := utils.SendAll(ctx, p.sender, msgs)
+
+	// Outcomes must be recorded even if shutdown began mid-send,
+	// otherwise recipients would be mailed again after restart.
+	for i, d := range toSend {
+		sendErr := errs[i]
+		switch {
+		case sendErr != nil && utils.OutcomeUnknown(sendErr):
+			// The provider may have delivered it; sending again could
+			// mail the recipient twice.
+			err = p.campaigns.SettleDelivery(bg, d.ID, store.DeliveryFailed, "outcome unknown, not resent: "+sendErr.Error())
+		case sendErr != nil && ctx.Err() != nil:
+			// Shutting down: hand the delivery back untouched.
+			err = p.campaigns.ReleaseDelivery(bg, d.ID)
+		case sendErr != nil && utils.IsRetryable(sendErr) && d.Attempts < maxAttempts:
+			err = p.retryLater(bg, d, sendErr)
+		case sendErr != nil:
+			err = p.campaigns.SettleDelivery(bg, d.ID, store.DeliveryFailed, sendErr.Error())
+		default:
+			err = p.campaigns.SettleDelivery(bg, d.ID, store.DeliverySent, "")
+		}
+		if err != nil {
+			return true, err
+		}
+	}
+
+	return true, p.finish(bg, campaignID)
+}
+
+// finish marks the campaign sent once nothing is left pending.
+func (p *Pool) finish(ctx context.Context, campaignID int64) error {
+	finished, err := p.campaigns.FinishCampaign(ctx, campaignID)
+	if err != nil {
+		return err
+	}
+	if finished {
+		p.mu.Lock()
+		delete(p.cache, campaignID)
+		p.mu.Unlock()
+		log.Printf("queue: campaign %d finished", campaignID)
+	}
+	return nil
+}
+
+// postpone hands claimed deliveries back with backoff after a failure
+// that is not theirs, such as the store being unavailable. Their attempts
+// still count, so a campaign that never loads is eventually given up on.
+// It returns cause unless postponing fails too.
+func (p *Pool) postpone(ctx context.Context, ds []store.Delivery, cause error) error {
+	for _, d := range ds {
+		if err := p.retryLater(ctx, d, cause); err != nil {
+			return err
+		}
+	}
+	return cause
+}
+
+// retryLater keeps a delivery pending but hides it from workers until the
+// provider is likely to accept it again.
+func (p *Pool) retryLater(ctx context.Context, d store.Delivery, sendErr error) error {
+	delay := retryDelay * time.Duration(d.Attempts)
+	if ra := utils.RetryAfter(sendErr); ra > delay {
+		delay = ra
+	}
+	return p.campaigns.RetryDelivery(ctx, d.ID, delay, sendErr.Error())
+}
+
+// errBadTemplate marks a campaign whose stored content does not parse.
+var errBadTemplate = errors.New("campaign template")
+
+// template returns the parsed merge template of a campaign. Parsing once
+// per campaign keeps per-recipient rendering cheap.
+func (p *Pool) template(ctx context.Context, campaignID int64) (*utils.MergeTemplate, error) {
+	p.mu.Lock()
+	t, ok := p.cache[campaignID]
+	p.mu.Unlock()
+	if ok {
+		return t, nil
+	}
+
+	subject, html, text, err := p.campaigns.CampaignContent(ctx, campaignID)
+	if err != nil {
+		return nil, err
+	}
+	if t, err = utils.ParseMergeTemplate(subject, html, text); err != nil {
+		return nil, fmt.Errorf("%w: %v", errBadTemplate, err)
+	}
+
+	p.mu.Lock()
+	p.cache[campaignID] = t
+	p.mu.Unlock()
+	return t, nil
+}
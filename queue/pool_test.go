@@ -0,0 +1,201 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/pixperk/newsletter/store"
+	"github.com/pixperk/newsletter/utils"
+)
+
+// recordingSender is a BatchSender that records what it was asked to send
+// and fails every send with err.
+type recordingSender struct {
+	mu      sync.Mutex
+	err     error
+	batches [][]utils.Message
+	singles []utils.Message
+}
+
+func (s *recordingSender) Send(ctx context.Context, msg utils.Message) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.singles = append(s.singles, msg)
+	return s.err
+}
+
+func (s *recordingSender) SendBatch(ctx context.Context, msgs []utils.Message) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.batches = append(s.batches, msgs)
+	return s.err
+}
+
+func (s *recordingSender) MaxBatchSize() int { return 100 }
+
+func (s *recordingSender) MaxBatchBytes() int { return 0 }
+
+// campaign queues a campaign to the given number of new subscribers.
+func campaign(t *testing.T, st *store.Memory, recipients int) int64 {
+	t.Helper()
+	ctx := context.Background()
+	for i := range recipients {
+		email := fmt.Sprintf("reader%d@example.com", i)
+		if err := st.AddSubscriber(ctx, email, store.Consent{}, store.Profile{}, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	id, n, err := st.CreateCampaign(ctx, "Issue 1", "<p>Hello {{email}}</p>", "Hello {{email}}", store.Audience{})
+	if err != nil || n != recipients {
+		t.Fatalf("CreateCampaign = %d recipients, %v", n, err)
+	}
+	return id
+}
+
+func TestCampaignKeepsPerRecipientHeaders(t *testing.T) {
+	ctx := context.Background()
+	st := store.NewMemory()
+	const recipients = 5
+	id := campaign(t, st, recipients)
+
+	sender := &recordingSender{}
+	p := NewPool(st, st, sender, 1)
+	if worked, err := p.processBatch(ctx); !worked || err != nil {
+		t.Fatalf("processBatch = %v, %v", worked, err)
+	}
+
+	// A batch carries one set of headers, so batching would lose each
+	// recipient's one-click unsubscribe.
+	if len(sender.batches) != 0 || len(sender.singles) != recipients {
+		t.Fatalf("%d batches and %d single sends, want 0 and %d", len(sender.batches), len(sender.singles), recipients)
+	}
+	seen := map[string]bool{}
+	for _, msg := range sender.singles {
+		want := "<" + utils.UnsubscribeURL(msg.To) + ">"
+		if got := msg.Headers["List-Unsubscribe"]; got != want {
+			t.Errorf("List-Unsubscribe for %s = %q, want %q", msg.To, got, want)
+		}
+		seen[msg.Headers["List-Unsubscribe"]] = true
+	}
+	if len(seen) != recipients {
+		t.Errorf("%d distinct unsubscribe links, want %d", len(seen), recipients)
+	}
+
+	c, err := st.Campaign(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Sent != recipients || !c.Done() {
+		t.Errorf("campaign sent %d, done %v; want %d, true", c.Sent, c.Done(), recipients)
+	}
+}
+
+func TestClaimIsBounded(t *testing.T) {
+	ctx := context.Background()
+	st := store.NewMemory()
+	campaign(t, st, maxClaim+10)
+
+	sender := &recordingSender{}
+	p := NewPool(st, st, sender, 1)
+	if _, err := p.processBatch(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if len(sender.singles) != maxClaim {
+		t.Errorf("first claim sent %d messages, want %d", len(sender.singles), maxClaim)
+	}
+}
+
+func TestUnknownOutcomeIsNotResent(t *testing.T) {
+	ctx := context.Background()
+	st := store.NewMemory()
+	id := campaign(t, st, 3)
+
+	sender := &recordingSender{err: &utils.SendError{Err: errors.New("timeout"), Unknown: true}}
+	p := NewPool(st, st, sender, 1)
+	if _, err := p.processBatch(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(sender.singles) != 3 {
+		t.Errorf("%d sends, want one per recipient", len(sender.singles))
+	}
+	c, err := st.Campaign(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Queued != 0 || c.Failed != 3 {
+		t.Errorf("campaign has %d queued and %d failed, want 0 and 3", c.Queued, c.Failed)
+	}
+	if worked, _ := p.processBatch(ctx); worked {
+		t.Error("deliveries with an unknown outcome were claimed again")
+	}
+}
+
+func TestBrokenTemplateFailsTheCampaign(t *testing.T) {
+	ctx := context.Background()
+	st := store.NewMemory()
+	for _, email := range []string{"ada@example.com", "bob@example.com"} {
+		if err := st.AddSubscriber(ctx, email, store.Consent{}, store.Profile{}, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	id, _, err := st.CreateCampaign(ctx, "Issue 1", "<p>Hello {{</p>", "", store.Audience{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sender := &recordingSender{}
+	p := NewPool(st, st, sender, 1)
+	if worked, err := p.processBatch(ctx); !worked || err == nil {
+		t.Fatalf("processBatch = %v, %v; want the template error", worked, err)
+	}
+
+	if len(sender.singles)+len(sender.batches) != 0 {
+		t.Error("sent a campaign whose template does not parse")
+	}
+	c, err := st.Campaign(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Queued != 0 || c.Failed != 2 || !c.Done() {
+		t.Errorf("campaign has %d queued and %d failed, done %v; want 0, 2, true", c.Queued, c.Failed, c.Done())
+	}
+	if worked, _ := p.processBatch(ctx); worked {
+		t.Error("deliveries of a broken campaign were claimed again")
+	}
+}
+
+// unavailableContent is a store whose campaign content cannot be read.
+type unavailableContent struct {
+	*store.Memory
+}
+
+func (unavailableContent) CampaignContent(ctx context.Context, id int64) (string, string, string, error) {
+	return "", "", "", errors.New("connection refused")
+}
+
+func TestUnloadableTemplateIsPostponed(t *testing.T) {
+	ctx := context.Background()
+	st := store.NewMemory()
+	id := campaign(t, st, 3)
+
+	sender := &recordingSender{}
+	p := NewPool(unavailableContent{st}, st, sender, 1)
+	if worked, err := p.processBatch(ctx); !worked || err == nil {
+		t.Fatalf("processBatch = %v, %v; want the store error", worked, err)
+	}
+
+	c, err := st.Campaign(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Queued != 3 || c.Failed != 0 {
+		t.Errorf("campaign has %d queued and %d failed, want 3 and 0", c.Queued, c.Failed)
+	}
+	if worked, _ := p.processBatch(ctx); worked {
+		t.Error("postponed deliveries were claimed again right away")
+	}
+}
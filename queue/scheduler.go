@@ -0,0 +1,80 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/pixperk/newsletter/store"
+)
+
+// scheduleInterval is how often the scheduler looks for due campaigns, and
+// so the worst-case delay of a scheduled send.
+const scheduleInterval = 15 * time.Second
+
+// Scheduler starts scheduled campaigns once they are due and wakes the
+// pool. Every replica runs one; the DraftStore hands each due campaign to
+// exactly one of them. A campaign identical to one queued within
+// duplicateWindow before it goes back to the drafts unless it was forced.
+type Scheduler struct {
+	drafts          store.DraftStore
+	pool            *Pool
+	duplicateWindow time.Duration
+	wg              sync.WaitGroup
+}
+
+func NewScheduler(drafts store.DraftStore, pool *Pool, duplicateWindow time.Duration) *Scheduler {
+	return &Scheduler{drafts: drafts, pool: pool, duplicateWindow: duplicateWindow}
+}
+
+// Start launches the scheduler. It stops when ctx is cancelled; use Wait
+// to block until it has exited.
+func (s *Scheduler) Start(ctx context.Context) {
+	s.wg.Add(1)
+	go s.run(ctx)
+}
+
+// Wait blocks until the scheduler has stopped.
+func (s *Scheduler) Wait() {
+	s.wg.Wait()
+}
+
+func (s *Scheduler) run(ctx context.Context) {
+	defer s.wg.Done()
+	ticker := time.NewTicker(scheduleInterval)
+	defer ticker.Stop()
+
+	for {
+		s.startDue(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// startDue starts every campaign that is due.
+func (s *Scheduler) startDue(ctx context.Context) {
+	for ctx.Err() == nil {
+		id, n, err := s.drafts.StartDueCampaign(ctx, s.duplicateWindow)
+		if errors.Is(err, store.ErrNotFound) {
+			return
+		}
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Printf("queue: scheduler: %v", err)
+			}
+			if id == 0 {
+				return
+			}
+			continue
+		}
+		log.Printf("queue: scheduled campaign %d started with %d deliveries", id, n)
+		if n > 0 {
+			s.pool.Notify()
+		}
+	}
+}
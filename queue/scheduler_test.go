@@ -0,0 +1,47 @@
+package queue
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/pixperk/newsletter/store"
+)
+
+func TestSchedulerChecksDuplicatesAtStart(t *testing.T) {
+	ctx := context.Background()
+	st := store.NewMemory()
+	if err := st.AddSubscriber(ctx, "ada@example.com", store.Consent{}, store.Profile{}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// Both are scheduled before the same issue goes out right away.
+	schedule := func(force bool) int64 {
+		t.Helper()
+		rev, err := st.CreateDraft(ctx, store.Draft{Subject: "Issue 1", Body: "# Hello"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = st.ScheduleDraft(ctx, rev.CampaignID, rev.Number, "Issue 1", "<p>Hello</p>", "", time.Now().Add(-time.Minute), "UTC", force)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return rev.CampaignID
+	}
+	plain, forced := schedule(false), schedule(true)
+	if _, _, err := st.CreateCampaign(ctx, "Issue 1", "<p>Hello</p>", "", store.Audience{}); err != nil {
+		t.Fatal(err)
+	}
+
+	NewScheduler(st, NewPool(st, st, &recordingSender{}, 1), time.Hour).startDue(ctx)
+
+	for id, want := range map[int64]string{plain: store.CampaignDraft, forced: store.CampaignQueued} {
+		c, err := st.Campaign(ctx, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c.Status != want {
+			t.Errorf("campaign %d is %s, want %s", id, c.Status, want)
+		}
+	}
+}
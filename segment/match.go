@@ -0,0 +1,129 @@
+package segment
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Facts is what an expression is evaluated against.
+type Facts struct {
+	Email        string
+	FirstName    string
+	LastName     string
+	Attributes   map[string]any
+	Tags         []string
+	Lists        []string
+	SubscribedAt time.Time
+	Opens        int
+	Clicks       int
+	LastEngaged  time.Time // zero if never
+}
+
+// Match evaluates e for f at time now. It mirrors the SQL the Postgres
+// store generates.
+func Match(e Expr, f Facts, now time.Time) bool {
+	switch e := e.(type) {
+	case And:
+		return Match(e.Left, f, now) && Match(e.Right, f, now)
+	case Or:
+		return Match(e.Left, f, now) || Match(e.Right, f, now)
+	case Not:
+		return !Match(e.X, f, now)
+	case Cond:
+		return matchCond(e, f, now)
+	}
+	return false
+}
+
+func matchCond(c Cond, f Facts, now time.Time) bool {
+	switch c.Field {
+	case "email":
+		return compareText(f.Email, c.Op, c.Value.Str)
+	case "first_name":
+		return compareText(f.FirstName, c.Op, c.Value.Str)
+	case "last_name":
+		return compareText(f.LastName, c.Op, c.Value.Str)
+	case "tag":
+		return slices.Contains(f.Tags, c.Value.Str) == (c.Op == "=")
+	case "list":
+		return slices.Contains(f.Lists, c.Value.Str) == (c.Op == "=")
+	case "subscribed_days":
+		return compareNumber(days(now.Sub(f.SubscribedAt)), c.Op, c.Value.Num)
+	case "opens":
+		return compareNumber(float64(f.Opens), c.Op, c.Value.Num)
+	case "clicks":
+		return compareNumber(float64(f.Clicks), c.Op, c.Value.Num)
+	case "last_engaged_days":
+		if f.LastEngaged.IsZero() {
+			return false
+		}
+		return compareNumber(days(now.Sub(f.LastEngaged)), c.Op, c.Value.Num)
+	case "attr":
+		v, ok := f.Attributes[c.Attr]
+		if !ok || v == nil {
+			// Like SQL NULL, a missing attribute only satisfies !=.
+			return c.Op == "!=" && !c.Value.IsNum
+		}
+		if c.Value.IsNum {
+			n, ok := v.(float64)
+			return ok && compareNumber(n, c.Op, c.Value.Num)
+		}
+		return compareText(attrText(v), c.Op, c.Value.Str)
+	}
+	return false
+}
+
+func days(d time.Duration) float64 {
+	return d.Hours() / 24
+}
+
+func attrText(v any) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	}
+	return fmt.Sprint(v)
+}
+
+func compareText(a, op, b string) bool {
+	switch op {
+	case "=":
+		return a == b
+	case "!=":
+		return a != b
+	case "~":
+		return strings.Contains(strings.ToLower(a), strings.ToLower(b))
+	case "<":
+		return a < b
+	case "<=":
+		return a <= b
+	case ">":
+		return a > b
+	case ">=":
+		return a >= b
+	}
+	return false
+}
+
+func compareNumber(a float64, op string, b float64) bool {
+	switch op {
+	case "=":
+		return a == b
+	case "!=":
+		return a != b
+	case "<":
+		return a < b
+	case "<=":
+		return a <= b
+	case ">":
+		return a > b
+	case ">=":
+		return a >= b
+	}
+	return false
+}
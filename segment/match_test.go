@@ -0,0 +1,69 @@
+package segment
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMatch(t *testing.T) {
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	ada := Facts{
+		Email:        "Ada@Example.com",
+		FirstName:    "Ada",
+		Attributes:   map[string]any{"role": "cto", "seats": float64(12), "beta": true},
+		Tags:         []string{"beta", "vip"},
+		Lists:        []string{"weekly"},
+		SubscribedAt: now.AddDate(0, 0, -45),
+		Opens:        3,
+		Clicks:       0,
+		LastEngaged:  now.AddDate(0, 0, -10),
+	}
+	bob := Facts{Email: "bob@example.com", SubscribedAt: now.AddDate(0, 0, -1)}
+
+	tests := []struct {
+		expr     string
+		ada, bob bool
+	}{
+		{`email ~ "@EXAMPLE.com"`, true, true},
+		{`email = "ada@example.com"`, false, false},
+		{`first_name = "Ada"`, true, false},
+		{`first_name != "Ada"`, false, true},
+		{`last_name = ""`, true, true},
+		{`tag = "beta"`, true, false},
+		{`tag != "beta"`, false, true},
+		{`list = "weekly"`, true, false},
+		{`subscribed_days > 30`, true, false},
+		{`subscribed_days <= 1`, false, true},
+		{`opens >= 3`, true, false},
+		{`clicks = 0`, true, true},
+		{`last_engaged_days < 30`, true, false},
+		// Subscribers who never engaged match no last_engaged_days
+		// condition, not even a negated one.
+		{`last_engaged_days >= 0`, true, false},
+		{`last_engaged_days != 10`, false, false},
+		{`attr.role = "cto"`, true, false},
+		{`attr.role ~ "CT"`, true, false},
+		{`attr.seats > 10`, true, false},
+		{`attr.seats = "12"`, true, false},
+		{`attr.role > 3`, false, false},
+		{`attr.beta = "true"`, true, false},
+		// A missing attribute behaves like SQL NULL.
+		{`attr.role != "cto"`, false, true},
+		{`attr.seats != 12`, false, false},
+		{`tag = "beta" and (attr.role = "cto" or opens >= 3) and subscribed_days > 30`, true, false},
+		{`not tag = "beta" or attr.seats < 5`, false, true},
+		{`not (tag = "vip" and list = "weekly")`, false, true},
+	}
+	for _, tt := range tests {
+		e, err := Parse(tt.expr)
+		if err != nil {
+			t.Fatalf("Parse(%q): %v", tt.expr, err)
+		}
+		if got := Match(e, ada, now); got != tt.ada {
+			t.Errorf("%s for ada = %v, want %v", tt.expr, got, tt.ada)
+		}
+		if got := Match(e, bob, now); got != tt.bob {
+			t.Errorf("%s for bob = %v, want %v", tt.expr, got, tt.bob)
+		}
+	}
+}
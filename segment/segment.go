@@ -0,0 +1,232 @@
+// Package segment parses the filter expressions that define subscriber
+// segments, e.g.
+//
+//	tag = "beta" and (attr.role = "cto" or opens >= 3) and subscribed_days > 30
+//
+// Conditions compare a field with a literal and combine with and, or, not
+// and parentheses. Fields:
+//
+//	email, first_name, last_name   text: = != ~ (contains, case-insensitive)
+//	attr.<name>                    custom attribute: = != ~ < <= > >=
+//	tag, list                      membership: = (has) != (has not)
+//	subscribed_days                days since subscribing: = != < <= > >=
+//	opens, clicks                  engagement counts:      = != < <= > >=
+//	last_engaged_days              days since the last open or click;
+//	                               never-engaged subscribers match nothing
+package segment
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Expr is a parsed filter expression.
+type Expr interface {
+	String() string
+}
+
+// And matches when both sides match.
+type And struct{ Left, Right Expr }
+
+// Or matches when either side matches.
+type Or struct{ Left, Right Expr }
+
+// Not inverts X.
+type Not struct{ X Expr }
+
+// Cond compares a field with a literal.
+type Cond struct {
+	Field string // one of the fields above, "attr" for custom attributes
+	Attr  string // attribute name when Field is "attr"
+	Op    string
+	Value Value
+}
+
+// Value is a string or number literal.
+type Value struct {
+	Str   string
+	Num   float64
+	IsNum bool
+}
+
+func (e And) String() string { return "(" + e.Left.String() + " and " + e.Right.String() + ")" }
+func (e Or) String() string  { return "(" + e.Left.String() + " or " + e.Right.String() + ")" }
+func (e Not) String() string { return "not " + e.X.String() }
+
+func (c Cond) String() string {
+	field := c.Field
+	if field == "attr" {
+		field = "attr." + c.Attr
+	}
+	return field + " " + c.Op + " " + c.Value.String()
+}
+
+func (v Value) String() string {
+	if v.IsNum {
+		return fmt.Sprint(v.Num)
+	}
+	return fmt.Sprintf("%q", v.Str)
+}
+
+// Field kinds decide which operators and literals a field accepts.
+const (
+	kindText   = "text"
+	kindNumber = "number"
+	kindSet    = "set"
+	kindAttr   = "attr"
+)
+
+var fields = map[string]string{
+	"email":             kindText,
+	"first_name":        kindText,
+	"last_name":         kindText,
+	"tag":               kindSet,
+	"list":              kindSet,
+	"subscribed_days":   kindNumber,
+	"opens":             kindNumber,
+	"clicks":            kindNumber,
+	"last_engaged_days": kindNumber,
+}
+
+var operators = map[string][]string{
+	kindText:   {"=", "!=", "~"},
+	kindNumber: {"=", "!=", "<", "<=", ">", ">="},
+	kindSet:    {"=", "!="},
+	kindAttr:   {"=", "!=", "~", "<", "<=", ">", ">="},
+}
+
+// Parse parses a filter expression.
+func Parse(src string) (Expr, error) {
+	toks, err := lex(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{toks: toks}
+	e, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != tokEOF {
+		return nil, fmt.Errorf("unexpected %s at offset %d", t, t.pos)
+	}
+	return e, nil
+}
+
+type parser struct {
+	toks []token
+	pos  int
+}
+
+func (p *parser) peek() token { return p.toks[p.pos] }
+
+func (p *parser) next() token {
+	t := p.toks[p.pos]
+	if t.kind != tokEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *parser) keyword(word string) bool {
+	t := p.peek()
+	if t.kind == tokIdent && strings.EqualFold(t.text, word) {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *parser) or() (Expr, error) {
+	left, err := p.and()
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("or") {
+		right, err := p.and()
+		if err != nil {
+			return nil, err
+		}
+		left = Or{left, right}
+	}
+	return left, nil
+}
+
+func (p *parser) and() (Expr, error) {
+	left, err := p.unary()
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("and") {
+		right, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		left = And{left, right}
+	}
+	return left, nil
+}
+
+func (p *parser) unary() (Expr, error) {
+	if p.keyword("not") {
+		x, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return Not{x}, nil
+	}
+	if p.peek().kind == tokLParen {
+		p.next()
+		e, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		if t := p.next(); t.kind != tokRParen {
+			return nil, fmt.Errorf("expected ) at offset %d, got %s", t.pos, t)
+		}
+		return e, nil
+	}
+	return p.cond()
+}
+
+func (p *parser) cond() (Expr, error) {
+	t := p.next()
+	if t.kind != tokIdent {
+		return nil, fmt.Errorf("expected a field at offset %d, got %s", t.pos, t)
+	}
+
+	c := Cond{Field: strings.ToLower(t.text)}
+	kind, ok := fields[c.Field]
+	if attr, isAttr := strings.CutPrefix(t.text, "attr."); isAttr && attr != "" && !strings.Contains(attr, ".") {
+		c.Field, c.Attr, kind, ok = "attr", attr, kindAttr, true
+	}
+	if !ok {
+		return nil, fmt.Errorf("unknown field %q at offset %d", t.text, t.pos)
+	}
+
+	op := p.next()
+	if op.kind != tokOp || !slices.Contains(operators[kind], op.text) {
+		return nil, fmt.Errorf("operator %s is not allowed for %s at offset %d", op, t.text, op.pos)
+	}
+	c.Op = op.text
+
+	v := p.next()
+	switch v.kind {
+	case tokString:
+		c.Value = Value{Str: v.text}
+	case tokNumber:
+		c.Value = Value{Num: v.num, IsNum: true}
+	default:
+		return nil, fmt.Errorf("expected a value at offset %d, got %s", v.pos, v)
+	}
+
+	switch {
+	case kind == kindNumber && !c.Value.IsNum:
+		return nil, fmt.Errorf("%s compares with a number", t.text)
+	case (kind == kindText || kind == kindSet) && c.Value.IsNum:
+		return nil, fmt.Errorf("%s compares with a string", t.text)
+	case kind == kindAttr && c.Op == "~" && c.Value.IsNum:
+		return nil, fmt.Errorf("~ compares with a string")
+	}
+	return c, nil
+}
@@ -0,0 +1,76 @@
+package segment
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		src, want string
+	}{
+		{`tag = "beta"`, `tag = "beta"`},
+		{`TAG = "beta"`, `tag = "beta"`},
+		{`opens >= 3`, `opens >= 3`},
+		{`subscribed_days > -1.5`, `subscribed_days > -1.5`},
+		{`attr.role = "cto"`, `attr.role = "cto"`},
+		{`attr.seats < 10`, `attr.seats < 10`},
+		{`email ~ "@example.com"`, `email ~ "@example.com"`},
+		{`first_name = "say \"hi\""`, `first_name = "say \"hi\""`},
+		// and binds tighter than or, and both associate to the left.
+		{`tag = "a" or tag = "b" and tag = "c"`, `(tag = "a" or (tag = "b" and tag = "c"))`},
+		{`tag = "a" and tag = "b" and tag = "c"`, `((tag = "a" and tag = "b") and tag = "c")`},
+		{`(tag = "a" or tag = "b") and tag = "c"`, `((tag = "a" or tag = "b") and tag = "c")`},
+		{`not tag = "a" and list = "b"`, `(not tag = "a" and list = "b")`},
+		{`not (tag = "a" and list = "b")`, `not (tag = "a" and list = "b")`},
+		{`NOT tag != "a" OR clicks = 0`, `(not tag != "a" or clicks = 0)`},
+		{
+			`tag = "beta" and (attr.role = "cto" or opens >= 3) and subscribed_days > 30`,
+			`((tag = "beta" and (attr.role = "cto" or opens >= 3)) and subscribed_days > 30)`,
+		},
+	}
+	for _, tt := range tests {
+		e, err := Parse(tt.src)
+		if err != nil {
+			t.Errorf("Parse(%q): %v", tt.src, err)
+			continue
+		}
+		if got := e.String(); got != tt.want {
+			t.Errorf("Parse(%q) = %s, want %s", tt.src, got, tt.want)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		src, want string // want is part of the error
+	}{
+		{``, "expected a field"},
+		{`tag`, "operator"},
+		{`tag =`, "expected a value"},
+		{`country = "de"`, `unknown field "country"`},
+		{`attr. = "x"`, "unknown field"},
+		{`attr.a.b = "x"`, "unknown field"},
+		{`tag ~ "a"`, "operator"},
+		{`opens ~ "3"`, "operator"},
+		{`opens > "3"`, "compares with a number"},
+		{`email = 3`, "compares with a string"},
+		{`tag = 3`, "compares with a string"},
+		{`attr.n ~ 3`, "~ compares with a string"},
+		{`tag = "a" and`, "expected a field"},
+		{`tag = "a" tag = "b"`, "unexpected"},
+		{`(tag = "a"`, "expected )"},
+		{`tag = "a")`, "unexpected"},
+		{`tag = "a`, "unterminated string"},
+		{`tag ! "a"`, "unexpected !"},
+		{`opens = 1.2.3`, "invalid number"},
+		{`tag = 'a'`, "unexpected"},
+		{`tag = "a"; drop table subscribers`, "unexpected"},
+	}
+	for _, tt := range tests {
+		_, err := Parse(tt.src)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("Parse(%q) error = %v, want it to mention %q", tt.src, err, tt.want)
+		}
+	}
+}
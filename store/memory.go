@@ -0,0 +1,563 @@
+package store
+
+import (
+	"context"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Memory is an in-process Store for tests and local experiments. It keeps
+// the same invariants as Postgres but nothing survives a restart.
+type Memory struct {
+	mu            sync.Mutex
+	subscribers   map[string]*memSubscriber
+	suppressions  map[string]string
+	verifications map[string]*Verification
+	campaigns     map[int64]*memCampaign
+	lists         map[string]*List
+	segments      map[string]*Segment
+	idempotency   map[string]*IdempotentRequest
+	deliveries    []*memDelivery
+	events        []Event
+	nextID        int64
+}
+
+type memSubscriber struct {
+	email       string
+	status      string
+	profile     Profile
+	tags        []string
+	lists       []string
+	frequency   string
+	pausedUntil *time.Time
+	consent     Consent
+	consentedAt time.Time
+	confirmedAt *time.Time
+	reason      string
+}
+
+type memCampaign struct {
+	Campaign
+	html      string
+	text      string
+	revisions []Revision
+	queuedAt  time.Time
+	forced    bool
+}
+
+type memDelivery struct {
+	Delivery
+	status      string
+	err         string
+	lockedUntil time.Time
+}
+
+var _ Store = (*Memory)(nil)
+
+func NewMemory() *Memory {
+	return &Memory{
+		subscribers:   map[string]*memSubscriber{},
+		suppressions:  map[string]string{},
+		verifications: map[string]*Verification{},
+		campaigns:     map[int64]*memCampaign{},
+		lists:         map[string]*List{},
+		segments:      map[string]*Segment{},
+		idempotency:   map[string]*IdempotentRequest{},
+	}
+}
+
+func (m *Memory) id() int64 {
+	m.nextID++
+	return m.nextID
+}
+
+func (m *Memory) IsSubscribed(ctx context.Context, email string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s, ok := m.subscribers[email]
+	return ok && s.status == StatusActive, nil
+}
+
+func (m *Memory) SubscriberExists(ctx context.Context, email string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, ok := m.subscribers[email]
+	return ok, nil
+}
+
+func (m *Memory) AddSubscriber(ctx context.Context, email string, c Consent, p Profile, lists []string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.subscribers[email]; ok {
+		return ErrConflict
+	}
+	p.Attributes = maps.Clone(p.Attributes)
+	m.subscribers[email] = &memSubscriber{
+		email:       email,
+		status:      StatusActive,
+		profile:     p,
+		lists:       m.knownLists(nil, lists),
+		frequency:   FrequencyEvery,
+		consent:     c,
+		consentedAt: time.Now(),
+	}
+	return nil
+}
+
+// knownLists adds the slugs of existing lists to have, keeping it sorted
+// and free of duplicates.
+func (m *Memory) knownLists(have, slugs []string) []string {
+	out := slices.Clone(have)
+	for _, slug := range slugs {
+		if _, ok := m.lists[slug]; ok && !slices.Contains(out, slug) {
+			out = append(out, slug)
+		}
+	}
+	slices.Sort(out)
+	return out
+}
+
+func (m *Memory) Subscriber(ctx context.Context, email string) (*Subscriber, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.subscriber(email)
+}
+
+func (m *Memory) subscriber(email string) (*Subscriber, error) {
+	s, ok := m.subscribers[email]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	p := s.profile
+	p.Attributes = maps.Clone(p.Attributes)
+	if p.Attributes == nil {
+		p.Attributes = map[string]any{}
+	}
+	return &Subscriber{
+		Email:       s.email,
+		Status:      s.status,
+		Suppression: m.suppressions[strings.ToLower(email)],
+		Profile:     p,
+		Tags:        slices.Clone(s.tags),
+		Lists:       slices.Clone(s.lists),
+		Frequency:   s.frequency,
+		PausedUntil: s.pausedUntil,
+		Consent:     s.consent,
+		CreatedAt:   s.consentedAt,
+		ConfirmedAt: s.confirmedAt,
+	}, nil
+}
+
+func (m *Memory) UpdateProfile(ctx context.Context, email string, u ProfileUpdate) (*Subscriber, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s, ok := m.subscribers[email]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	if u.FirstName != nil {
+		s.profile.FirstName = *u.FirstName
+	}
+	if u.LastName != nil {
+		s.profile.LastName = *u.LastName
+	}
+	s.profile.Attributes = mergeAttributes(s.profile.Attributes, u.Attributes)
+	if u.Tags != nil {
+		s.tags = slices.Clone(*u.Tags)
+	}
+	if u.Lists != nil {
+		s.lists = m.knownLists(nil, *u.Lists)
+	}
+	if u.Frequency != nil && *u.Frequency != "" {
+		s.frequency = *u.Frequency
+	}
+	if u.PausedUntil != nil {
+		s.pausedUntil = nil
+		if !u.PausedUntil.IsZero() {
+			t := *u.PausedUntil
+			s.pausedUntil = &t
+		}
+	}
+	return m.subscriber(email)
+}
+
+func (m *Memory) AttributeKeys(ctx context.Context) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var keys []string
+	for _, s := range m.subscribers {
+		for key := range s.profile.Attributes {
+			if !slices.Contains(keys, key) {
+				keys = append(keys, key)
+			}
+		}
+	}
+	slices.Sort(keys)
+	return keys, nil
+}
+
+// mergeAttributes applies update to attrs the way the Postgres store
+// does: keys are overwritten and nil values delete them.
+func mergeAttributes(attrs, update map[string]any) map[string]any {
+	out := maps.Clone(attrs)
+	if out == nil {
+		out = map[string]any{}
+	}
+	for k, v := range update {
+		if v == nil {
+			delete(out, k)
+		} else {
+			out[k] = v
+		}
+	}
+	return out
+}
+
+func (m *Memory) Suppression(ctx context.Context, email string) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.suppressions[strings.ToLower(email)], nil
+}
+
+func (m *Memory) Suppress(ctx context.Context, email, status, reason string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.suppressions[strings.ToLower(email)] = status
+	s, ok := m.subscribers[email]
+	if !ok || s.status != StatusActive {
+		return false, nil
+	}
+	s.status = status
+	s.reason = reason
+	return true, nil
+}
+
+func (m *Memory) sendable(email string) bool {
+	s, ok := m.subscribers[email]
+	_, suppressed := m.suppressions[strings.ToLower(email)]
+	return ok && s.status == StatusActive && !suppressed
+}
+
+func (m *Memory) SaveVerification(ctx context.Context, v Verification) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if v.Purpose == "" {
+		v.Purpose = PurposeSubscribe
+	}
+	for token, existing := range m.verifications {
+		if existing.Email == v.Email && existing.Purpose == v.Purpose {
+			delete(m.verifications, token)
+		}
+	}
+	if v.CreatedAt.IsZero() {
+		v.CreatedAt = time.Now()
+	}
+	v.Profile.Attributes = maps.Clone(v.Profile.Attributes)
+	v.Lists = slices.Clone(v.Lists)
+	m.verifications[v.Token] = &v
+	return nil
+}
+
+func (m *Memory) Verification(ctx context.Context, token string) (*Verification, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	v, ok := m.verifications[token]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	cp := *v
+	return &cp, nil
+}
+
+func (m *Memory) ConfirmVerification(ctx context.Context, token string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	v, ok := m.verifications[token]
+	if !ok || v.Purpose != PurposeSubscribe {
+		return ErrNotFound
+	}
+	key := strings.ToLower(v.Email)
+	if !Resubscribable(m.suppressions[key]) {
+		return ErrSuppressed
+	}
+	v.Verified = true
+	now := time.Now()
+	p := v.Profile
+	var tags, lists []string
+	frequency := FrequencyEvery
+	if prev, ok := m.subscribers[v.Email]; ok {
+		tags, lists, frequency = prev.tags, prev.lists, prev.frequency
+		if p.FirstName == "" {
+			p.FirstName = prev.profile.FirstName
+		}
+		if p.LastName == "" {
+			p.LastName = prev.profile.LastName
+		}
+		p.Attributes = mergeAttributes(prev.profile.Attributes, p.Attributes)
+	}
+	m.subscribers[v.Email] = &memSubscriber{
+		email:       v.Email,
+		status:      StatusActive,
+		profile:     p,
+		tags:        tags,
+		lists:       m.knownLists(lists, v.Lists),
+		frequency:   frequency,
+		consent:     v.Consent,
+		consentedAt: v.CreatedAt,
+		confirmedAt: &now,
+	}
+	delete(m.suppressions, key)
+	return nil
+}
+
+func (m *Memory) CreateCampaign(ctx context.Context, subject, htmlBody, textBody string, a Audience) (int64, int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	emails, err := m.audience(a)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	now := time.Now()
+	c := &memCampaign{Campaign: Campaign{ID: m.id(), Subject: subject, Audience: a, Status: CampaignQueued, CreatedAt: now}, html: htmlBody, text: textBody}
+	m.campaigns[c.ID] = c
+
+	m.enqueue(c, emails)
+	return c.ID, len(emails), nil
+}
+
+func (m *Memory) enqueue(c *memCampaign, emails []string) {
+	c.queuedAt = time.Now()
+	for _, email := range emails {
+		m.deliveries = append(m.deliveries, &memDelivery{
+			Delivery: Delivery{ID: m.id(), CampaignID: c.ID, Email: email},
+			status:   DeliveryPending,
+		})
+	}
+	if len(emails) == 0 {
+		now := time.Now()
+		c.Status = CampaignSent
+		c.StartedAt, c.FinishedAt = &now, &now
+	}
+}
+
+func (m *Memory) DuplicateCampaign(ctx context.Context, subject, htmlBody string, a Audience, since time.Time, except int64) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.duplicate(subject, htmlBody, a, since, except, true)
+}
+
+// duplicate finds the latest campaign other than except with the same
+// content and audience queued since the given time, or, with scheduled
+// set, still waiting to start.
+func (m *Memory) duplicate(subject, htmlBody string, a Audience, since time.Time, except int64, scheduled bool) (int64, error) {
+	var dup int64
+	var dupAt time.Time
+	for _, c := range m.campaigns {
+		if c.ID == except || c.Subject != subject || c.html != htmlBody {
+			continue
+		}
+		at, audience := c.queuedAt, c.Audience
+		switch {
+		case c.Status == CampaignScheduled && scheduled:
+			at, audience = *c.ScheduledAt, c.revisions[len(c.revisions)-1].Audience
+		case at.IsZero() || at.Before(since):
+			continue
+		}
+		if audience == a && (dup == 0 || at.After(dupAt)) {
+			dup, dupAt = c.ID, at
+		}
+	}
+	if dup == 0 {
+		return 0, ErrNotFound
+	}
+	return dup, nil
+}
+
+func (m *Memory) Campaign(ctx context.Context, id int64) (*Campaign, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	c, ok := m.campaigns[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	out := c.Campaign
+	out.Errors = nil
+	for _, d := range m.deliveries {
+		if d.CampaignID != id {
+			continue
+		}
+		switch d.status {
+		case DeliveryPending:
+			out.Queued++
+		case DeliverySent:
+			out.Sent++
+		case DeliveryFailed:
+			out.Failed++
+			if len(out.Errors) < MaxDeliveryErrors {
+				out.Errors = append(out.Errors, DeliveryError{Email: d.Email, Error: d.err})
+			}
+		case DeliverySkipped:
+			out.Skipped++
+		}
+	}
+	return &out, nil
+}
+
+func (m *Memory) CampaignContent(ctx context.Context, id int64) (string, string, string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	c, ok := m.campaigns[id]
+	if !ok {
+		return "", "", "", ErrNotFound
+	}
+	return c.Subject, c.html, c.text, nil
+}
+
+func (m *Memory) ClaimDeliveries(ctx context.Context, limit int, lease time.Duration) ([]Delivery, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	var claimed []Delivery
+	var campaignID int64
+	for _, d := range m.deliveries {
+		if len(claimed) >= limit {
+			break
+		}
+		if d.status != DeliveryPending || d.lockedUntil.After(now) {
+			continue
+		}
+		if campaignID == 0 {
+			campaignID = d.CampaignID
+		}
+		if d.CampaignID != campaignID {
+			continue
+		}
+		d.lockedUntil = now.Add(lease)
+		d.Attempts++
+		claimed = append(claimed, d.Delivery)
+	}
+	return claimed, nil
+}
+
+func (m *Memory) MarkCampaignStarted(ctx context.Context, id int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if c, ok := m.campaigns[id]; ok && c.Status == CampaignQueued {
+		now := time.Now()
+		c.Status = CampaignSending
+		c.StartedAt = &now
+	}
+	return nil
+}
+
+func (m *Memory) delivery(id int64) *memDelivery {
+	for _, d := range m.deliveries {
+		if d.ID == id {
+			return d
+		}
+	}
+	return &memDelivery{}
+}
+
+func (m *Memory) SettleDelivery(ctx context.Context, id int64, status, reason string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	d := m.delivery(id)
+	d.status, d.err, d.lockedUntil = status, reason, time.Time{}
+	return nil
+}
+
+func (m *Memory) RetryDelivery(ctx context.Context, id int64, delay time.Duration, reason string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	d := m.delivery(id)
+	d.err, d.lockedUntil = reason, time.Now().Add(delay)
+	return nil
+}
+
+func (m *Memory) ReleaseDelivery(ctx context.Context, id int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	d := m.delivery(id)
+	d.lockedUntil = time.Time{}
+	d.Attempts--
+	return nil
+}
+
+func (m *Memory) FinishCampaign(ctx context.Context, id int64) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.finish(id), nil
+}
+
+func (m *Memory) finish(id int64) bool {
+	c, ok := m.campaigns[id]
+	if !ok || c.FinishedAt != nil || c.Status == CampaignDraft || c.Status == CampaignScheduled {
+		return false
+	}
+	for _, d := range m.deliveries {
+		if d.CampaignID == id && d.status == DeliveryPending {
+			return false
+		}
+	}
+	now := time.Now()
+	c.Status = CampaignSent
+	c.FinishedAt = &now
+	return true
+}
+
+func (m *Memory) FinishSettledCampaigns(ctx context.Context) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for id := range m.campaigns {
+		m.finish(id)
+	}
+	return nil
+}
+
+func (m *Memory) PendingDeliveries(ctx context.Context) (int, int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	campaigns := map[int64]bool{}
+	pending := 0
+	for _, d := range m.deliveries {
+		if d.status == DeliveryPending {
+			campaigns[d.CampaignID] = true
+			pending++
+		}
+	}
+	return len(campaigns), pending, nil
+}
+
+func (m *Memory) RecordEvent(ctx context.Context, ev Event) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, e := range m.events {
+		if e.Email == ev.Email && e.Event == ev.Event && e.MessageID == ev.MessageID && e.OccurredAt.Equal(ev.OccurredAt) {
+			return false, nil
+		}
+	}
+	if _, ok := m.campaigns[ev.CampaignID]; !ok {
+		ev.CampaignID = 0
+	}
+	m.events = append(m.events, ev)
+	return true, nil
+}
+
+func (m *Memory) CountEvents(ctx context.Context, email string, since time.Time, events ...string) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	n := 0
+	for _, e := range m.events {
+		if strings.EqualFold(e.Email, email) && slices.Contains(events, e.Event) && !e.OccurredAt.Before(since) {
+			n++
+		}
+	}
+	return n, nil
+}
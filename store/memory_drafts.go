@@ -0,0 +1,191 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"time"
+)
+
+func (m *Memory) CreateDraft(ctx context.Context, d Draft) (*Revision, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	c := &memCampaign{Campaign: Campaign{ID: m.id(), Subject: d.Subject, Status: CampaignDraft, CreatedAt: time.Now()}}
+	m.campaigns[c.ID] = c
+	return m.addRevision(c, d), nil
+}
+
+func (m *Memory) SaveDraft(ctx context.Context, id int64, d Draft) (*Revision, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	c, err := m.draft(id)
+	if err != nil {
+		return nil, err
+	}
+	return m.addRevision(c, d), nil
+}
+
+// draft returns the unsent campaign id if it is in one of the given
+// states, by default a plain draft.
+func (m *Memory) draft(id int64, states ...string) (*memCampaign, error) {
+	c, ok := m.campaigns[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	if len(states) == 0 {
+		states = []string{CampaignDraft}
+	}
+	if !slices.Contains(states, c.Status) {
+		return nil, ErrConflict
+	}
+	return c, nil
+}
+
+func (m *Memory) addRevision(c *memCampaign, d Draft) *Revision {
+	c.Revision++
+	c.Subject = d.Subject
+	d.Tags = slices.Clone(d.Tags)
+	r := Revision{CampaignID: c.ID, Number: c.Revision, Draft: d, CreatedAt: time.Now()}
+	c.revisions = append(c.revisions, r)
+	return &r
+}
+
+func (m *Memory) Drafts(ctx context.Context) ([]Revision, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	drafts := []Revision{}
+	for _, c := range m.campaigns {
+		if c.Status == CampaignDraft {
+			drafts = append(drafts, c.revisions[len(c.revisions)-1])
+		}
+	}
+	slices.SortFunc(drafts, func(a, b Revision) int { return int(b.CampaignID - a.CampaignID) })
+	return drafts, nil
+}
+
+func (m *Memory) Revisions(ctx context.Context, id int64) ([]Revision, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	c, ok := m.campaigns[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return append([]Revision{}, c.revisions...), nil
+}
+
+func (m *Memory) Revision(ctx context.Context, id int64, number int) (*Revision, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	c, ok := m.campaigns[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	if number == 0 {
+		number = c.Revision
+	}
+	if number < 1 || number > len(c.revisions) {
+		return nil, ErrNotFound
+	}
+	r := c.revisions[number-1]
+	return &r, nil
+}
+
+func (m *Memory) DeleteDraft(ctx context.Context, id int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, err := m.draft(id); err != nil {
+		return err
+	}
+	delete(m.campaigns, id)
+	return nil
+}
+
+func (m *Memory) SendDraft(ctx context.Context, id int64, revision int, subject, htmlBody, textBody string, a Audience) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	c, err := m.draft(id, CampaignDraft, CampaignScheduled)
+	if err != nil {
+		return 0, err
+	}
+	if c.Revision != revision {
+		return 0, ErrConflict
+	}
+	emails, err := m.audience(a)
+	if err != nil {
+		return 0, err
+	}
+
+	c.Subject, c.html, c.text, c.Audience, c.Status, c.ScheduledAt = subject, htmlBody, textBody, a, CampaignQueued, nil
+	m.enqueue(c, emails)
+	return len(emails), nil
+}
+
+func (m *Memory) ScheduleDraft(ctx context.Context, id int64, revision int, subject, htmlBody, textBody string, at time.Time, timeZone string, force bool) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	c, err := m.draft(id, CampaignDraft, CampaignScheduled)
+	if err != nil {
+		return err
+	}
+	if c.Revision != revision {
+		return ErrConflict
+	}
+	c.Subject, c.html, c.text, c.Status, c.ScheduledAt, c.TimeZone = subject, htmlBody, textBody, CampaignScheduled, &at, timeZone
+	c.forced = force
+	return nil
+}
+
+func (m *Memory) UnscheduleDraft(ctx context.Context, id int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	c, err := m.draft(id, CampaignScheduled)
+	if err != nil {
+		return err
+	}
+	c.Status, c.ScheduledAt, c.TimeZone = CampaignDraft, nil, ""
+	return nil
+}
+
+func (m *Memory) StartDueCampaign(ctx context.Context, window time.Duration) (int64, int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var due *memCampaign
+	for _, c := range m.campaigns {
+		if c.Status != CampaignScheduled || c.ScheduledAt.After(time.Now()) {
+			continue
+		}
+		if due == nil || c.ScheduledAt.Before(*due.ScheduledAt) || (c.ScheduledAt.Equal(*due.ScheduledAt) && c.ID < due.ID) {
+			due = c
+		}
+	}
+	if due == nil {
+		return 0, 0, ErrNotFound
+	}
+
+	a := due.revisions[len(due.revisions)-1].Audience
+	if window > 0 && !due.forced {
+		if dup, err := m.duplicate(due.Subject, due.html, a, time.Now().Add(-window), due.ID, false); err == nil {
+			due.Status, due.ScheduledAt, due.TimeZone = CampaignDraft, nil, ""
+			return due.ID, 0, fmt.Errorf("campaign %d: identical to campaign %d sent within the duplicate window, returned to drafts", due.ID, dup)
+		}
+	}
+	emails, err := m.audience(a)
+	if err != nil {
+		due.Status, due.ScheduledAt, due.TimeZone = CampaignDraft, nil, ""
+		return due.ID, 0, fmt.Errorf("campaign %d: list or segment no longer exists, returned to drafts", due.ID)
+	}
+
+	due.Audience, due.Status = a, CampaignQueued
+	m.enqueue(due, emails)
+	return due.ID, len(emails), nil
+}
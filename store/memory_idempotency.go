@@ -0,0 +1,40 @@
+package store
+
+import (
+	"context"
+	"slices"
+	"time"
+)
+
+func (m *Memory) ReserveIdempotencyKey(ctx context.Context, key, requestHash string, stale time.Duration) (*IdempotentRequest, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if req, ok := m.idempotency[key]; ok && (req.Status != 0 || time.Since(req.CreatedAt) < stale) {
+		out := *req
+		out.Response = slices.Clone(req.Response)
+		return &out, nil
+	}
+	m.idempotency[key] = &IdempotentRequest{Key: key, RequestHash: requestHash, CreatedAt: time.Now()}
+	return nil, nil
+}
+
+func (m *Memory) CompleteIdempotencyKey(ctx context.Context, key string, status int, response []byte, campaignID int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if req, ok := m.idempotency[key]; ok {
+		req.Status, req.Response, req.CampaignID = status, slices.Clone(response), campaignID
+	}
+	return nil
+}
+
+func (m *Memory) ReleaseIdempotencyKey(ctx context.Context, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if req, ok := m.idempotency[key]; ok && req.Status == 0 {
+		delete(m.idempotency, key)
+	}
+	return nil
+}
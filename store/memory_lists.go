@@ -0,0 +1,197 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/pixperk/newsletter/segment"
+)
+
+// audience returns the sorted sendable addresses a selects.
+func (m *Memory) audience(a Audience) ([]string, error) {
+	if a.List != "" {
+		if _, ok := m.lists[a.List]; !ok {
+			return nil, ErrNotFound
+		}
+	}
+	var expr segment.Expr
+	if a.Segment != "" {
+		seg, ok := m.segments[a.Segment]
+		if !ok {
+			return nil, ErrNotFound
+		}
+		var err error
+		if expr, err = segment.Parse(seg.Filter); err != nil {
+			return nil, fmt.Errorf("segment %s: %w", a.Segment, err)
+		}
+	}
+
+	frequency := FrequencyEvery
+	if a.Digest {
+		frequency = FrequencyMonthly
+	}
+
+	now := time.Now()
+	var emails []string
+	for email, s := range m.subscribers {
+		if !m.sendable(email) || s.frequency != frequency {
+			continue
+		}
+		if s.pausedUntil != nil && s.pausedUntil.After(now) {
+			continue
+		}
+		if a.List != "" && !slices.Contains(s.lists, a.List) {
+			continue
+		}
+		if expr != nil && !segment.Match(expr, m.facts(s), now) {
+			continue
+		}
+		emails = append(emails, email)
+	}
+	slices.Sort(emails)
+	return emails, nil
+}
+
+func (m *Memory) facts(s *memSubscriber) segment.Facts {
+	f := segment.Facts{
+		Email:        s.email,
+		FirstName:    s.profile.FirstName,
+		LastName:     s.profile.LastName,
+		Attributes:   s.profile.Attributes,
+		Tags:         s.tags,
+		Lists:        s.lists,
+		SubscribedAt: s.consentedAt,
+	}
+	opened := map[string]bool{}
+	for _, e := range m.events {
+		if !strings.EqualFold(e.Email, s.email) {
+			continue
+		}
+		switch e.Event {
+		case "opened", "unique_opened":
+			opened[e.MessageID] = true
+		case "click":
+			f.Clicks++
+		default:
+			continue
+		}
+		if e.OccurredAt.After(f.LastEngaged) {
+			f.LastEngaged = e.OccurredAt
+		}
+	}
+	f.Opens = len(opened)
+	return f
+}
+
+func (m *Memory) CountAudience(ctx context.Context, a Audience) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	emails, err := m.audience(a)
+	return len(emails), err
+}
+
+func (m *Memory) CreateList(ctx context.Context, l List) (*List, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.lists[l.Slug]; ok {
+		return nil, ErrConflict
+	}
+	l.ID = m.id()
+	l.CreatedAt = time.Now()
+	l.Members = 0
+	m.lists[l.Slug] = &l
+	out := l
+	return &out, nil
+}
+
+func (m *Memory) Lists(ctx context.Context) ([]List, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var lists []List
+	for slug := range m.lists {
+		lists = append(lists, m.list(slug))
+	}
+	slices.SortFunc(lists, func(a, b List) int { return strings.Compare(a.Slug, b.Slug) })
+	return lists, nil
+}
+
+func (m *Memory) List(ctx context.Context, slug string) (*List, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.lists[slug]; !ok {
+		return nil, ErrNotFound
+	}
+	l := m.list(slug)
+	return &l, nil
+}
+
+func (m *Memory) list(slug string) List {
+	l := *m.lists[slug]
+	for _, s := range m.subscribers {
+		if s.status == StatusActive && slices.Contains(s.lists, slug) {
+			l.Members++
+		}
+	}
+	return l
+}
+
+func (m *Memory) DeleteList(ctx context.Context, slug string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.lists[slug]; !ok {
+		return ErrNotFound
+	}
+	delete(m.lists, slug)
+	for _, s := range m.subscribers {
+		s.lists = slices.DeleteFunc(s.lists, func(l string) bool { return l == slug })
+	}
+	return nil
+}
+
+func (m *Memory) CreateSegment(ctx context.Context, seg Segment) (*Segment, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.segments[seg.Slug]; ok {
+		return nil, ErrConflict
+	}
+	seg.ID = m.id()
+	seg.CreatedAt = time.Now()
+	m.segments[seg.Slug] = &seg
+	out := seg
+	return &out, nil
+}
+
+func (m *Memory) Segments(ctx context.Context) ([]Segment, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var segments []Segment
+	for _, seg := range m.segments {
+		segments = append(segments, *seg)
+	}
+	slices.SortFunc(segments, func(a, b Segment) int { return strings.Compare(a.Slug, b.Slug) })
+	return segments, nil
+}
+
+func (m *Memory) Segment(ctx context.Context, slug string) (*Segment, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	seg, ok := m.segments[slug]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	out := *seg
+	return &out, nil
+}
+
+func (m *Memory) DeleteSegment(ctx context.Context, slug string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.segments[slug]; !ok {
+		return ErrNotFound
+	}
+	delete(m.segments, slug)
+	return nil
+}
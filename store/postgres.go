@@ -0,0 +1,384 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Postgres implements Store on top of database/sql.
+type Postgres struct {
+	db *sql.DB
+}
+
+var _ Store = (*Postgres)(nil)
+
+func NewPostgres(db *sql.DB) *Postgres {
+	return &Postgres{db: db}
+}
+
+func isUniqueViolation(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "23505"
+}
+
+// execer is satisfied by both *sql.DB and *sql.Tx.
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+func (p *Postgres) IsSubscribed(ctx context.Context, email string) (bool, error) {
+	var exists bool
+	err := p.db.QueryRowContext(ctx, `SELECT EXISTS(SELECT 1 FROM subscribers WHERE email = $1 AND status = $2)`, email, StatusActive).Scan(&exists)
+	return exists, err
+}
+
+func (p *Postgres) SubscriberExists(ctx context.Context, email string) (bool, error) {
+	var exists bool
+	err := p.db.QueryRowContext(ctx, `SELECT EXISTS(SELECT 1 FROM subscribers WHERE email = $1)`, email).Scan(&exists)
+	return exists, err
+}
+
+func (p *Postgres) AddSubscriber(ctx context.Context, email string, c Consent, pr Profile, lists []string) error {
+	attrs, err := encodeAttributes(pr.Attributes)
+	if err != nil {
+		return err
+	}
+
+	tx, err := p.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO subscribers (email, consent_ip, consent_user_agent, consent_source, consented_at, first_name, last_name, attributes)
+		VALUES ($1, $2, $3, $4, now(), NULLIF($5, ''), NULLIF($6, ''), $7)
+	`, email, c.IP, c.UserAgent, c.Source, pr.FirstName, pr.LastName, attrs)
+	if isUniqueViolation(err) {
+		return ErrConflict
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := joinLists(ctx, tx, email, lists); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func encodeAttributes(attrs map[string]any) ([]byte, error) {
+	if attrs == nil {
+		return []byte("{}"), nil
+	}
+	return json.Marshal(attrs)
+}
+
+func decodeAttributes(raw []byte) (map[string]any, error) {
+	attrs := map[string]any{}
+	if len(raw) == 0 {
+		return attrs, nil
+	}
+	return attrs, json.Unmarshal(raw, &attrs)
+}
+
+func (p *Postgres) Subscriber(ctx context.Context, email string) (*Subscriber, error) {
+	s := &Subscriber{}
+	var first, last, ip, ua, source sql.NullString
+	var attrs []byte
+	err := p.db.QueryRowContext(ctx, `
+		SELECT s.email, s.status, COALESCE(x.reason, ''), s.first_name, s.last_name, s.attributes, s.tags,
+			ARRAY(SELECT l.slug FROM list_members m JOIN lists l ON l.id = m.list_id WHERE m.subscriber_id = s.id ORDER BY l.slug),
+			s.frequency, s.paused_until,
+			s.consent_ip, s.consent_user_agent, s.consent_source, s.created_at, s.confirmed_at
+		FROM subscribers s
+		LEFT JOIN suppressions x ON x.email = lower(s.email)
+		WHERE s.email = $1
+	`, email).Scan(&s.Email, &s.Status, &s.Suppression, &first, &last, &attrs, pq.Array(&s.Tags),
+		pq.Array(&s.Lists), &s.Frequency, &s.PausedUntil, &ip, &ua, &source, &s.CreatedAt, &s.ConfirmedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	s.Profile = Profile{FirstName: first.String, LastName: last.String}
+	if s.Profile.Attributes, err = decodeAttributes(attrs); err != nil {
+		return nil, err
+	}
+	s.Consent = Consent{IP: ip.String, UserAgent: ua.String, Source: source.String}
+	return s, nil
+}
+
+func (p *Postgres) UpdateProfile(ctx context.Context, email string, u ProfileUpdate) (*Subscriber, error) {
+	attrs, err := encodeAttributes(u.Attributes)
+	if err != nil {
+		return nil, err
+	}
+	var tags []string
+	if u.Tags != nil {
+		tags = *u.Tags
+	}
+	var pausedUntil *time.Time
+	if u.PausedUntil != nil && !u.PausedUntil.IsZero() {
+		pausedUntil = u.PausedUntil
+	}
+
+	tx, err := p.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// jsonb || merges the top-level keys; stripping nulls afterwards is
+	// what lets a null in the update delete a key.
+	res, err := tx.ExecContext(ctx, `
+		UPDATE subscribers SET
+			first_name = CASE WHEN $2 THEN NULLIF($3, '') ELSE first_name END,
+			last_name = CASE WHEN $4 THEN NULLIF($5, '') ELSE last_name END,
+			attributes = jsonb_strip_nulls(attributes || $6::jsonb),
+			tags = CASE WHEN $7 THEN $8::text[] ELSE tags END,
+			frequency = COALESCE(NULLIF($9, ''), frequency),
+			paused_until = CASE WHEN $10 THEN $11::timestamptz ELSE paused_until END,
+			updated_at = now()
+		WHERE email = $1
+	`, email, u.FirstName != nil, deref(u.FirstName), u.LastName != nil, deref(u.LastName), attrs,
+		u.Tags != nil, pq.Array(tags), deref(u.Frequency), u.PausedUntil != nil, pausedUntil)
+	if err != nil {
+		return nil, err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return nil, ErrNotFound
+	}
+
+	if u.Lists != nil {
+		if err := setLists(ctx, tx, email, *u.Lists); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return p.Subscriber(ctx, email)
+}
+
+func (p *Postgres) AttributeKeys(ctx context.Context) ([]string, error) {
+	rows, err := p.db.QueryContext(ctx, `SELECT DISTINCT jsonb_object_keys(attributes) FROM subscribers ORDER BY 1`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []string
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+func deref(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
+
+func (p *Postgres) Suppression(ctx context.Context, email string) (string, error) {
+	return suppression(ctx, p.db, email)
+}
+
+func suppression(ctx context.Context, q execer, email string) (string, error) {
+	var reason string
+	err := q.QueryRowContext(ctx, `SELECT reason FROM suppressions WHERE email = lower($1)`, email).Scan(&reason)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+	return reason, err
+}
+
+func (p *Postgres) Suppress(ctx context.Context, email, status, reason string) (bool, error) {
+	tx, err := p.db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, `
+		UPDATE subscribers
+		SET status = $2, unsubscribed_at = now(), unsubscribe_reason = NULLIF($3, ''), updated_at = now()
+		WHERE email = $1 AND status = $4
+	`, email, status, reason, StatusActive)
+	if err != nil {
+		return false, err
+	}
+	n, _ := res.RowsAffected()
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO suppressions (email, reason, detail)
+		VALUES (lower($1), $2, NULLIF($3, ''))
+		ON CONFLICT (email) DO UPDATE SET reason = EXCLUDED.reason, detail = EXCLUDED.detail, created_at = now()
+	`, email, status, reason)
+	if err != nil {
+		return false, err
+	}
+	return n > 0, tx.Commit()
+}
+
+func (p *Postgres) SaveVerification(ctx context.Context, v Verification) error {
+	tx, err := p.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if v.Purpose == "" {
+		v.Purpose = PurposeSubscribe
+	}
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM email_verifications WHERE email = $1 AND purpose = $2`, v.Email, v.Purpose); err != nil {
+		return err
+	}
+
+	attrs, err := encodeAttributes(v.Profile.Attributes)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO email_verifications (email, verification_token, expires_at, consent_ip, consent_user_agent, consent_source, first_name, last_name, attributes, lists, purpose)
+		VALUES ($1, $2, $3, $4, $5, $6, NULLIF($7, ''), NULLIF($8, ''), $9, $10, $11)
+	`, v.Email, v.Token, v.ExpiresAt, v.Consent.IP, v.Consent.UserAgent, v.Consent.Source, v.Profile.FirstName, v.Profile.LastName, attrs, pq.Array(v.Lists), v.Purpose)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (p *Postgres) Verification(ctx context.Context, token string) (*Verification, error) {
+	v := &Verification{Token: token}
+	var ip, ua, source, first, last sql.NullString
+	var attrs []byte
+	err := p.db.QueryRowContext(ctx, `
+		SELECT email, expires_at, verified, consent_ip, consent_user_agent, consent_source, created_at,
+			first_name, last_name, attributes, lists, purpose
+		FROM email_verifications
+		WHERE verification_token = $1
+	`, token).Scan(&v.Email, &v.ExpiresAt, &v.Verified, &ip, &ua, &source, &v.CreatedAt, &first, &last, &attrs, pq.Array(&v.Lists), &v.Purpose)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	v.Consent = Consent{IP: ip.String, UserAgent: ua.String, Source: source.String}
+	v.Profile = Profile{FirstName: first.String, LastName: last.String}
+	if v.Profile.Attributes, err = decodeAttributes(attrs); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+func (p *Postgres) ConfirmVerification(ctx context.Context, token string) error {
+	tx, err := p.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var email string
+	var lists []string
+	err = tx.QueryRowContext(ctx, `
+		UPDATE email_verifications
+		SET verified = TRUE
+		WHERE verification_token = $1 AND purpose = $2
+		RETURNING email, lists
+	`, token, PurposeSubscribe).Scan(&email, pq.Array(&lists))
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	reason, err := suppression(ctx, tx, email)
+	if err != nil {
+		return err
+	}
+	if !Resubscribable(reason) {
+		return ErrSuppressed
+	}
+
+	// A confirmed opt-in is the only way back for someone who
+	// unsubscribed earlier, so it reactivates the row and lifts the
+	// suppression.
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO subscribers (email, consent_ip, consent_user_agent, consent_source, consented_at, confirmed_at, first_name, last_name, attributes)
+		SELECT email, consent_ip, consent_user_agent, consent_source, created_at, now(), first_name, last_name, attributes
+		FROM email_verifications
+		WHERE verification_token = $1
+		ON CONFLICT (email) DO UPDATE SET
+			status = 'active',
+			first_name = COALESCE(EXCLUDED.first_name, subscribers.first_name),
+			last_name = COALESCE(EXCLUDED.last_name, subscribers.last_name),
+			attributes = subscribers.attributes || EXCLUDED.attributes,
+			consent_ip = EXCLUDED.consent_ip,
+			consent_user_agent = EXCLUDED.consent_user_agent,
+			consent_source = EXCLUDED.consent_source,
+			consented_at = EXCLUDED.consented_at,
+			confirmed_at = EXCLUDED.confirmed_at,
+			unsubscribed_at = NULL,
+			unsubscribe_reason = NULL,
+			updated_at = now()
+	`, token)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM suppressions WHERE email = lower($1) AND reason = $2`, email, StatusUnsubscribed)
+	if err != nil {
+		return err
+	}
+
+	if err := joinLists(ctx, tx, email, lists); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (p *Postgres) RecordEvent(ctx context.Context, ev Event) (bool, error) {
+	var campaignID *int64
+	if ev.CampaignID != 0 {
+		campaignID = &ev.CampaignID
+	}
+	res, err := p.db.ExecContext(ctx, `
+		INSERT INTO email_events (email, event, campaign_id, message_id, reason, occurred_at)
+		VALUES ($1, $2, (SELECT id FROM campaigns WHERE id = $3), $4, NULLIF($5, ''), $6)
+		ON CONFLICT (email, event, message_id, occurred_at) DO NOTHING
+	`, ev.Email, ev.Event, campaignID, ev.MessageID, ev.Reason, ev.OccurredAt)
+	if err != nil {
+		return false, err
+	}
+	n, _ := res.RowsAffected()
+	return n > 0, nil
+}
+
+func (p *Postgres) CountEvents(ctx context.Context, email string, since time.Time, events ...string) (int, error) {
+	var n int
+	err := p.db.QueryRowContext(ctx, `
+		SELECT COUNT(*) FROM email_events
+		WHERE lower(email) = lower($1) AND event = ANY($2) AND occurred_at >= $3
+	`, email, pq.Array(events), since).Scan(&n)
+	return n, err
+}
@@ -0,0 +1,297 @@
+package store
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+)
+
+func (p *Postgres) CreateCampaign(ctx context.Context, subject, htmlBody, textBody string, a Audience) (int64, int, error) {
+	tx, err := p.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer tx.Rollback()
+
+	args := []any{nil}
+	cond, listID, segmentID, err := audienceSQL(ctx, tx, a, &args)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	var id int64
+	err = tx.QueryRowContext(ctx, `
+		INSERT INTO campaigns (subject, html_body, text_body, status, list_id, segment_id, digest, content_hash, queued_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, now())
+		RETURNING id
+	`, subject, htmlBody, textBody, CampaignQueued, listID, segmentID, a.Digest, contentHash(subject, htmlBody)).Scan(&id)
+	if err != nil {
+		return 0, 0, fmt.Errorf("insert campaign: %w", err)
+	}
+
+	args[0] = id
+	n, err := enqueue(ctx, tx, id, cond, args)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, 0, err
+	}
+	return id, n, nil
+}
+
+// enqueue adds a pending delivery of campaign id for every subscriber
+// matching cond, whose arguments start with id, and closes the campaign
+// right away when nobody matches.
+func enqueue(ctx context.Context, tx *sql.Tx, id int64, cond string, args []any) (int, error) {
+	res, err := tx.ExecContext(ctx, `
+		INSERT INTO deliveries (campaign_id, email)
+		SELECT $1, s.email FROM subscribers s
+		WHERE `+cond+`
+		ON CONFLICT (campaign_id, email) DO NOTHING
+	`, args...)
+	if err != nil {
+		return 0, fmt.Errorf("insert deliveries: %w", err)
+	}
+	n, _ := res.RowsAffected()
+
+	if n == 0 {
+		_, err = tx.ExecContext(ctx, `
+			UPDATE campaigns SET status = $2, started_at = now(), finished_at = now()
+			WHERE id = $1
+		`, id, CampaignSent)
+		if err != nil {
+			return 0, err
+		}
+	}
+	return int(n), nil
+}
+
+// contentHash identifies what a campaign sends for duplicate detection.
+func contentHash(subject, htmlBody string) string {
+	sum := sha256.Sum256([]byte(subject + "\x00" + htmlBody))
+	return hex.EncodeToString(sum[:])
+}
+
+func (p *Postgres) DuplicateCampaign(ctx context.Context, subject, htmlBody string, a Audience, since time.Time, except int64) (int64, error) {
+	return duplicateCampaign(ctx, p.db, contentHash(subject, htmlBody), a, since, except, true)
+}
+
+// duplicateCampaign finds the latest campaign other than except with the
+// given content hash and audience queued since the given time, or, with
+// scheduled set, still waiting to start. A scheduled campaign gets its
+// audience from its latest revision when it starts.
+func duplicateCampaign(ctx context.Context, q execer, hash string, a Audience, since time.Time, except int64, scheduled bool) (int64, error) {
+	var id int64
+	err := q.QueryRowContext(ctx, `
+		SELECT c.id FROM campaigns c
+		LEFT JOIN lists l ON l.id = c.list_id
+		LEFT JOIN segments g ON g.id = c.segment_id
+		LEFT JOIN campaign_revisions r ON r.campaign_id = c.id AND r.revision = c.revision
+		WHERE c.content_hash = $1 AND c.id <> $6 AND (
+			c.queued_at >= $2
+			AND COALESCE(l.slug, '') = $3 AND COALESCE(g.slug, '') = $4 AND c.digest = $5
+			OR $7 AND c.status = $8
+			AND r.list_slug = $3 AND r.segment_slug = $4 AND r.digest = $5
+		)
+		ORDER BY COALESCE(c.queued_at, c.scheduled_at) DESC
+		LIMIT 1
+	`, hash, since, a.List, a.Segment, a.Digest, except, scheduled, CampaignScheduled).Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, ErrNotFound
+	}
+	return id, err
+}
+
+func (p *Postgres) Campaign(ctx context.Context, id int64) (*Campaign, error) {
+	c := &Campaign{ID: id}
+	var startedAt, finishedAt, scheduledAt sql.NullTime
+	err := p.db.QueryRowContext(ctx, `
+		SELECT c.subject, c.status, c.created_at, c.started_at, c.finished_at,
+			COALESCE(l.slug, ''), COALESCE(g.slug, ''), c.digest, c.revision,
+			c.scheduled_at, c.time_zone
+		FROM campaigns c
+		LEFT JOIN lists l ON l.id = c.list_id
+		LEFT JOIN segments g ON g.id = c.segment_id
+		WHERE c.id = $1
+	`, id).Scan(&c.Subject, &c.Status, &c.CreatedAt, &startedAt, &finishedAt, &c.Audience.List, &c.Audience.Segment, &c.Audience.Digest, &c.Revision, &scheduledAt, &c.TimeZone)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if startedAt.Valid {
+		c.StartedAt = &startedAt.Time
+	}
+	if finishedAt.Valid {
+		c.FinishedAt = &finishedAt.Time
+	}
+	if scheduledAt.Valid {
+		c.ScheduledAt = &scheduledAt.Time
+	}
+
+	rows, err := p.db.QueryContext(ctx, `
+		SELECT status, COUNT(*) FROM deliveries WHERE campaign_id = $1 GROUP BY status
+	`, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var status string
+		var n int
+		if err := rows.Scan(&status, &n); err != nil {
+			return nil, err
+		}
+		switch status {
+		case DeliveryPending:
+			c.Queued = n
+		case DeliverySent:
+			c.Sent = n
+		case DeliveryFailed:
+			c.Failed = n
+		case DeliverySkipped:
+			c.Skipped = n
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if c.Failed > 0 {
+		errRows, err := p.db.QueryContext(ctx, `
+			SELECT email, COALESCE(error, '') FROM deliveries
+			WHERE campaign_id = $1 AND status = $2
+			ORDER BY id
+			LIMIT $3
+		`, id, DeliveryFailed, MaxDeliveryErrors)
+		if err != nil {
+			return nil, err
+		}
+		defer errRows.Close()
+		for errRows.Next() {
+			var de DeliveryError
+			if err := errRows.Scan(&de.Email, &de.Error); err != nil {
+				return nil, err
+			}
+			c.Errors = append(c.Errors, de)
+		}
+		if err := errRows.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	return c, nil
+}
+
+func (p *Postgres) CampaignContent(ctx context.Context, id int64) (string, string, string, error) {
+	var subject, htmlBody, textBody string
+	err := p.db.QueryRowContext(ctx, `SELECT subject, html_body, text_body FROM campaigns WHERE id = $1`, id).Scan(&subject, &htmlBody, &textBody)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", "", "", ErrNotFound
+	}
+	return subject, htmlBody, textBody, err
+}
+
+func (p *Postgres) ClaimDeliveries(ctx context.Context, limit int, lease time.Duration) ([]Delivery, error) {
+	rows, err := p.db.QueryContext(ctx, `
+		UPDATE deliveries
+		SET locked_until = now() + make_interval(secs => $1), attempts = attempts + 1, updated_at = now()
+		WHERE id IN (
+			SELECT id FROM deliveries
+			WHERE status = $2 AND (locked_until IS NULL OR locked_until < now())
+			AND campaign_id = (
+				SELECT campaign_id FROM deliveries
+				WHERE status = $2 AND (locked_until IS NULL OR locked_until < now())
+				ORDER BY id
+				LIMIT 1
+			)
+			ORDER BY id
+			FOR UPDATE SKIP LOCKED
+			LIMIT $3
+		)
+		RETURNING id, campaign_id, email, attempts
+	`, lease.Seconds(), DeliveryPending, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var claimed []Delivery
+	for rows.Next() {
+		var d Delivery
+		if err := rows.Scan(&d.ID, &d.CampaignID, &d.Email, &d.Attempts); err != nil {
+			return nil, err
+		}
+		claimed = append(claimed, d)
+	}
+	return claimed, rows.Err()
+}
+
+func (p *Postgres) MarkCampaignStarted(ctx context.Context, id int64) error {
+	_, err := p.db.ExecContext(ctx, `
+		UPDATE campaigns SET status = $2, started_at = COALESCE(started_at, now())
+		WHERE id = $1 AND status = $3
+	`, id, CampaignSending, CampaignQueued)
+	return err
+}
+
+func (p *Postgres) SettleDelivery(ctx context.Context, id int64, status, reason string) error {
+	_, err := p.db.ExecContext(ctx, `
+		UPDATE deliveries
+		SET status = $2, error = NULLIF($3, ''), locked_until = NULL, updated_at = now(),
+			sent_at = CASE WHEN $2 = 'sent' THEN now() ELSE sent_at END
+		WHERE id = $1
+	`, id, status, reason)
+	return err
+}
+
+func (p *Postgres) RetryDelivery(ctx context.Context, id int64, delay time.Duration, reason string) error {
+	_, err := p.db.ExecContext(ctx, `
+		UPDATE deliveries
+		SET locked_until = now() + make_interval(secs => $2), error = $3, updated_at = now()
+		WHERE id = $1
+	`, id, delay.Seconds(), reason)
+	return err
+}
+
+func (p *Postgres) ReleaseDelivery(ctx context.Context, id int64) error {
+	_, err := p.db.ExecContext(ctx, `UPDATE deliveries SET locked_until = NULL, attempts = attempts - 1 WHERE id = $1`, id)
+	return err
+}
+
+func (p *Postgres) FinishCampaign(ctx context.Context, id int64) (bool, error) {
+	res, err := p.db.ExecContext(ctx, `
+		UPDATE campaigns SET status = $2, finished_at = now()
+		WHERE id = $1 AND finished_at IS NULL
+		AND NOT EXISTS (SELECT 1 FROM deliveries WHERE campaign_id = $1 AND status = $3)
+	`, id, CampaignSent, DeliveryPending)
+	if err != nil {
+		return false, err
+	}
+	n, _ := res.RowsAffected()
+	return n > 0, nil
+}
+
+func (p *Postgres) FinishSettledCampaigns(ctx context.Context) error {
+	_, err := p.db.ExecContext(ctx, `
+		UPDATE campaigns c SET status = $1, finished_at = now()
+		WHERE finished_at IS NULL AND status NOT IN ($3, $4)
+		AND NOT EXISTS (SELECT 1 FROM deliveries d WHERE d.campaign_id = c.id AND d.status = $2)
+	`, CampaignSent, DeliveryPending, CampaignDraft, CampaignScheduled)
+	return err
+}
+
+func (p *Postgres) PendingDeliveries(ctx context.Context) (int, int, error) {
+	var campaigns, pending int
+	err := p.db.QueryRowContext(ctx, `
+		SELECT COUNT(DISTINCT campaign_id), COUNT(*) FROM deliveries WHERE status = $1
+	`, DeliveryPending).Scan(&campaigns, &pending)
+	return campaigns, pending, err
+}
@@ -0,0 +1,360 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+const revisionColumns = `campaign_id, revision, subject, preheader, body, theme, list_slug, segment_slug, digest, tags, utm_campaign, trusted_html, created_at`
+
+func scanRevision(row interface{ Scan(...any) error }) (*Revision, error) {
+	r := &Revision{}
+	err := row.Scan(&r.CampaignID, &r.Number, &r.Subject, &r.Preheader, &r.Body, &r.Theme,
+		&r.Audience.List, &r.Audience.Segment, &r.Audience.Digest, pq.Array(&r.Tags), &r.UTMCampaign, &r.TrustedHTML, &r.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (p *Postgres) CreateDraft(ctx context.Context, d Draft) (*Revision, error) {
+	tx, err := p.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var id int64
+	err = tx.QueryRowContext(ctx, `
+		INSERT INTO campaigns (subject, html_body, status, revision)
+		VALUES ($1, '', $2, 1)
+		RETURNING id
+	`, d.Subject, CampaignDraft).Scan(&id)
+	if err != nil {
+		return nil, fmt.Errorf("insert campaign: %w", err)
+	}
+
+	r, err := insertRevision(ctx, tx, id, 1, d)
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (p *Postgres) SaveDraft(ctx context.Context, id int64, d Draft) (*Revision, error) {
+	tx, err := p.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	latest, err := lockDraft(ctx, tx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := tx.ExecContext(ctx, `UPDATE campaigns SET subject = $2, revision = $3 WHERE id = $1`, id, d.Subject, latest+1); err != nil {
+		return nil, err
+	}
+	r, err := insertRevision(ctx, tx, id, latest+1, d)
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// lockDraft locks an unsent campaign for the rest of tx and returns its
+// latest revision number. It returns ErrConflict unless the campaign is in
+// one of the given states, by default a plain draft.
+func lockDraft(ctx context.Context, tx *sql.Tx, id int64, states ...string) (int, error) {
+	var status string
+	var revision int
+	err := tx.QueryRowContext(ctx, `SELECT status, revision FROM campaigns WHERE id = $1 FOR UPDATE`, id).Scan(&status, &revision)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, ErrNotFound
+	}
+	if err != nil {
+		return 0, err
+	}
+	if len(states) == 0 {
+		states = []string{CampaignDraft}
+	}
+	if !slices.Contains(states, status) {
+		return 0, ErrConflict
+	}
+	return revision, nil
+}
+
+func insertRevision(ctx context.Context, tx *sql.Tx, id int64, number int, d Draft) (*Revision, error) {
+	return scanRevision(tx.QueryRowContext(ctx, `
+		INSERT INTO campaign_revisions (campaign_id, revision, subject, preheader, body, theme, list_slug, segment_slug, digest, tags, utm_campaign, trusted_html)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, COALESCE($10, '{}'::text[]), $11, $12)
+		RETURNING `+revisionColumns,
+		id, number, d.Subject, d.Preheader, d.Body, d.Theme, d.Audience.List, d.Audience.Segment, d.Audience.Digest, pq.Array(d.Tags), d.UTMCampaign, d.TrustedHTML))
+}
+
+func (p *Postgres) Drafts(ctx context.Context) ([]Revision, error) {
+	rows, err := p.db.QueryContext(ctx, `
+		SELECT r.campaign_id, r.revision, r.subject, r.preheader, r.body, r.theme, r.list_slug, r.segment_slug, r.digest, r.tags, r.utm_campaign, r.trusted_html, r.created_at
+		FROM campaign_revisions r
+		JOIN campaigns c ON c.id = r.campaign_id AND c.revision = r.revision
+		WHERE c.status = $1
+		ORDER BY c.id DESC
+	`, CampaignDraft)
+	if err != nil {
+		return nil, err
+	}
+	return scanRevisions(rows)
+}
+
+func (p *Postgres) Revisions(ctx context.Context, id int64) ([]Revision, error) {
+	rows, err := p.db.QueryContext(ctx, `
+		SELECT `+revisionColumns+` FROM campaign_revisions
+		WHERE campaign_id = $1
+		ORDER BY revision
+	`, id)
+	if err != nil {
+		return nil, err
+	}
+	revisions, err := scanRevisions(rows)
+	if err != nil || len(revisions) > 0 {
+		return revisions, err
+	}
+
+	// Campaigns sent inline have no revisions.
+	var exists bool
+	if err := p.db.QueryRowContext(ctx, `SELECT EXISTS(SELECT 1 FROM campaigns WHERE id = $1)`, id).Scan(&exists); err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, ErrNotFound
+	}
+	return revisions, nil
+}
+
+func scanRevisions(rows *sql.Rows) ([]Revision, error) {
+	defer rows.Close()
+	revisions := []Revision{}
+	for rows.Next() {
+		r, err := scanRevision(rows)
+		if err != nil {
+			return nil, err
+		}
+		revisions = append(revisions, *r)
+	}
+	return revisions, rows.Err()
+}
+
+func (p *Postgres) Revision(ctx context.Context, id int64, number int) (*Revision, error) {
+	return scanRevision(p.db.QueryRowContext(ctx, `
+		SELECT `+revisionColumns+` FROM campaign_revisions
+		WHERE campaign_id = $1
+		AND revision = CASE WHEN $2 = 0 THEN (SELECT revision FROM campaigns WHERE id = $1) ELSE $2 END
+	`, id, number))
+}
+
+func (p *Postgres) DeleteDraft(ctx context.Context, id int64) error {
+	tx, err := p.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := lockDraft(ctx, tx, id); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM campaigns WHERE id = $1`, id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (p *Postgres) SendDraft(ctx context.Context, id int64, revision int, subject, htmlBody, textBody string, a Audience) (int, error) {
+	tx, err := p.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	latest, err := lockDraft(ctx, tx, id, CampaignDraft, CampaignScheduled)
+	if err != nil {
+		return 0, err
+	}
+	if latest != revision {
+		return 0, ErrConflict
+	}
+
+	args := []any{id}
+	cond, listID, segmentID, err := audienceSQL(ctx, tx, a, &args)
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		UPDATE campaigns
+		SET subject = $2, html_body = $3, text_body = $4, status = $5, list_id = $6, segment_id = $7, digest = $8,
+			scheduled_at = NULL, content_hash = $9, queued_at = now()
+		WHERE id = $1
+	`, id, subject, htmlBody, textBody, CampaignQueued, listID, segmentID, a.Digest, contentHash(subject, htmlBody))
+	if err != nil {
+		return 0, fmt.Errorf("queue campaign: %w", err)
+	}
+
+	n, err := enqueue(ctx, tx, id, cond, args)
+	if err != nil {
+		return 0, err
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+func (p *Postgres) ScheduleDraft(ctx context.Context, id int64, revision int, subject, htmlBody, textBody string, at time.Time, timeZone string, force bool) error {
+	tx, err := p.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	latest, err := lockDraft(ctx, tx, id, CampaignDraft, CampaignScheduled)
+	if err != nil {
+		return err
+	}
+	if latest != revision {
+		return ErrConflict
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		UPDATE campaigns
+		SET subject = $2, html_body = $3, text_body = $4, status = $5, scheduled_at = $6, time_zone = $7, content_hash = $8, forced = $9
+		WHERE id = $1
+	`, id, subject, htmlBody, textBody, CampaignScheduled, at, timeZone, contentHash(subject, htmlBody), force)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (p *Postgres) UnscheduleDraft(ctx context.Context, id int64) error {
+	tx, err := p.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := lockDraft(ctx, tx, id, CampaignScheduled); err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, `
+		UPDATE campaigns SET status = $2, scheduled_at = NULL, time_zone = ''
+		WHERE id = $1
+	`, id, CampaignDraft)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (p *Postgres) StartDueCampaign(ctx context.Context, window time.Duration) (int64, int, error) {
+	tx, err := p.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer tx.Rollback()
+
+	// SKIP LOCKED lets every replica poll: a campaign being started by one
+	// is invisible to the others, and once committed it is no longer
+	// scheduled.
+	var id int64
+	var a Audience
+	var hash sql.NullString
+	var forced bool
+	err = tx.QueryRowContext(ctx, `
+		SELECT c.id, r.list_slug, r.segment_slug, r.digest, c.content_hash, c.forced
+		FROM campaigns c
+		JOIN campaign_revisions r ON r.campaign_id = c.id AND r.revision = c.revision
+		WHERE c.status = $1 AND c.scheduled_at <= now()
+		ORDER BY c.scheduled_at, c.id
+		LIMIT 1
+		FOR UPDATE OF c SKIP LOCKED
+	`, CampaignScheduled).Scan(&id, &a.List, &a.Segment, &a.Digest, &hash, &forced)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, 0, ErrNotFound
+	}
+	if err != nil {
+		return 0, 0, err
+	}
+
+	// Scheduled campaigns are checked against each other when scheduled;
+	// this catches what was queued since, or raced that check.
+	if window > 0 && !forced {
+		dup, err := duplicateCampaign(ctx, tx, hash.String, a, time.Now().Add(-window), id, false)
+		if err == nil {
+			if err := unscheduleDue(ctx, tx, id); err != nil {
+				return 0, 0, err
+			}
+			return id, 0, fmt.Errorf("campaign %d: identical to campaign %d sent within the duplicate window, returned to drafts", id, dup)
+		}
+		if !errors.Is(err, ErrNotFound) {
+			return 0, 0, err
+		}
+	}
+
+	args := []any{id}
+	cond, listID, segmentID, err := audienceSQL(ctx, tx, a, &args)
+	if errors.Is(err, ErrNotFound) {
+		if err := unscheduleDue(ctx, tx, id); err != nil {
+			return 0, 0, err
+		}
+		return id, 0, fmt.Errorf("campaign %d: list or segment no longer exists, returned to drafts", id)
+	}
+	if err != nil {
+		return 0, 0, err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		UPDATE campaigns SET status = $2, list_id = $3, segment_id = $4, digest = $5, queued_at = now()
+		WHERE id = $1
+	`, id, CampaignQueued, listID, segmentID, a.Digest)
+	if err != nil {
+		return 0, 0, fmt.Errorf("queue campaign: %w", err)
+	}
+
+	n, err := enqueue(ctx, tx, id, cond, args)
+	if err != nil {
+		return 0, 0, err
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, 0, err
+	}
+	return id, n, nil
+}
+
+// unscheduleDue turns a due campaign that cannot start back into a draft
+// and commits tx.
+func unscheduleDue(ctx context.Context, tx *sql.Tx, id int64) error {
+	_, err := tx.ExecContext(ctx, `
+		UPDATE campaigns SET status = $2, scheduled_at = NULL, time_zone = ''
+		WHERE id = $1
+	`, id, CampaignDraft)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
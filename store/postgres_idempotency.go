@@ -0,0 +1,54 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+func (p *Postgres) ReserveIdempotencyKey(ctx context.Context, key, requestHash string, stale time.Duration) (*IdempotentRequest, error) {
+	res, err := p.db.ExecContext(ctx, `
+		INSERT INTO idempotency_keys (key, request_hash) VALUES ($1, $2)
+		ON CONFLICT (key) DO UPDATE SET request_hash = EXCLUDED.request_hash, created_at = now()
+		WHERE idempotency_keys.status IS NULL
+		AND idempotency_keys.created_at < now() - make_interval(secs => $3)
+	`, key, requestHash, stale.Seconds())
+	if err != nil {
+		return nil, err
+	}
+	if n, _ := res.RowsAffected(); n > 0 {
+		return nil, nil
+	}
+
+	req := &IdempotentRequest{Key: key}
+	var status sql.NullInt64
+	var campaignID sql.NullInt64
+	err = p.db.QueryRowContext(ctx, `
+		SELECT request_hash, status, response, campaign_id, created_at
+		FROM idempotency_keys WHERE key = $1
+	`, key).Scan(&req.RequestHash, &status, &req.Response, &campaignID, &req.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		// Released between the two statements; let the caller retry.
+		return nil, ErrConflict
+	}
+	if err != nil {
+		return nil, err
+	}
+	req.Status = int(status.Int64)
+	req.CampaignID = campaignID.Int64
+	return req, nil
+}
+
+func (p *Postgres) CompleteIdempotencyKey(ctx context.Context, key string, status int, response []byte, campaignID int64) error {
+	_, err := p.db.ExecContext(ctx, `
+		UPDATE idempotency_keys SET status = $2, response = $3, campaign_id = NULLIF($4, 0)
+		WHERE key = $1
+	`, key, status, response, campaignID)
+	return err
+}
+
+func (p *Postgres) ReleaseIdempotencyKey(ctx context.Context, key string) error {
+	_, err := p.db.ExecContext(ctx, `DELETE FROM idempotency_keys WHERE key = $1 AND status IS NULL`, key)
+	return err
+}
@@ -0,0 +1,308 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/lib/pq"
+	"github.com/pixperk/newsletter/segment"
+)
+
+// Engagement events as reported by Brevo.
+const (
+	openEvents   = `('opened', 'unique_opened')`
+	engageEvents = `('opened', 'unique_opened', 'click')`
+)
+
+func bind(args *[]any, v any) string {
+	*args = append(*args, v)
+	return fmt.Sprintf("$%d", len(*args))
+}
+
+// segmentSQL compiles e into a condition on subscribers s. Literals are
+// bound as arguments. segment.Match is the in-memory equivalent.
+func segmentSQL(e segment.Expr, args *[]any) string {
+	switch e := e.(type) {
+	case segment.And:
+		return "(" + segmentSQL(e.Left, args) + " AND " + segmentSQL(e.Right, args) + ")"
+	case segment.Or:
+		return "(" + segmentSQL(e.Left, args) + " OR " + segmentSQL(e.Right, args) + ")"
+	case segment.Not:
+		return "NOT COALESCE(" + segmentSQL(e.X, args) + ", FALSE)"
+	case segment.Cond:
+		return condSQL(e, args)
+	}
+	return "FALSE"
+}
+
+func condSQL(c segment.Cond, args *[]any) string {
+	switch c.Field {
+	case "email":
+		return textSQL("s.email", c.Op, bind(args, c.Value.Str))
+	case "first_name":
+		return textSQL("COALESCE(s.first_name, '')", c.Op, bind(args, c.Value.Str))
+	case "last_name":
+		return textSQL("COALESCE(s.last_name, '')", c.Op, bind(args, c.Value.Str))
+	case "tag":
+		cond := bind(args, c.Value.Str) + "::text = ANY(s.tags)"
+		if c.Op == "!=" {
+			return "NOT (" + cond + ")"
+		}
+		return cond
+	case "list":
+		cond := `EXISTS (SELECT 1 FROM list_members m JOIN lists l ON l.id = m.list_id
+			WHERE m.subscriber_id = s.id AND l.slug = ` + bind(args, c.Value.Str) + `)`
+		if c.Op == "!=" {
+			return "NOT " + cond
+		}
+		return cond
+	case "subscribed_days":
+		return "(EXTRACT(EPOCH FROM now() - s.created_at) / 86400) " + c.Op + " " + bind(args, c.Value.Num) + "::numeric"
+	case "opens":
+		return `(SELECT COUNT(DISTINCT e.message_id) FROM email_events e
+			WHERE lower(e.email) = lower(s.email) AND e.event IN ` + openEvents + `) ` + c.Op + " " + bind(args, c.Value.Num) + "::numeric"
+	case "clicks":
+		return `(SELECT COUNT(*) FROM email_events e
+			WHERE lower(e.email) = lower(s.email) AND e.event = 'click') ` + c.Op + " " + bind(args, c.Value.Num) + "::numeric"
+	case "last_engaged_days":
+		return `(SELECT EXTRACT(EPOCH FROM now() - max(e.occurred_at)) / 86400 FROM email_events e
+			WHERE lower(e.email) = lower(s.email) AND e.event IN ` + engageEvents + `) ` + c.Op + " " + bind(args, c.Value.Num) + "::numeric"
+	case "attr":
+		key := bind(args, c.Attr) + "::text"
+		if c.Value.IsNum {
+			return "(CASE WHEN jsonb_typeof(s.attributes -> " + key + ") = 'number' THEN (s.attributes ->> " + key + ")::numeric END) " +
+				c.Op + " " + bind(args, c.Value.Num) + "::numeric"
+		}
+		col := "(s.attributes ->> " + key + ")"
+		if c.Op == "!=" {
+			// A missing attribute differs from every string.
+			return col + " IS DISTINCT FROM " + bind(args, c.Value.Str) + "::text"
+		}
+		return textSQL(col, c.Op, bind(args, c.Value.Str))
+	}
+	return "FALSE"
+}
+
+func textSQL(col, op, arg string) string {
+	switch op {
+	case "~":
+		return "strpos(lower(" + col + "), lower(" + arg + "::text)) > 0"
+	case "!=":
+		return col + " <> " + arg + "::text"
+	case "=":
+		return col + " = " + arg + "::text"
+	}
+	// Byte order, like the in-memory comparison.
+	return col + ` COLLATE "C" ` + op + " " + arg + "::text"
+}
+
+// audienceSQL returns the condition on subscribers s that selects a,
+// binding its arguments after those already in args, and the IDs to
+// record on the campaign.
+func audienceSQL(ctx context.Context, q execer, a Audience, args *[]any) (cond string, listID, segmentID *int64, err error) {
+	frequency := FrequencyEvery
+	if a.Digest {
+		frequency = FrequencyMonthly
+	}
+	conds := "s.status = " + bind(args, StatusActive) +
+		" AND NOT EXISTS (SELECT 1 FROM suppressions x WHERE x.email = lower(s.email))" +
+		" AND (s.paused_until IS NULL OR s.paused_until <= now())" +
+		" AND s.frequency = " + bind(args, frequency)
+
+	if a.List != "" {
+		var id int64
+		err := q.QueryRowContext(ctx, `SELECT id FROM lists WHERE slug = $1`, a.List).Scan(&id)
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", nil, nil, ErrNotFound
+		}
+		if err != nil {
+			return "", nil, nil, err
+		}
+		conds += " AND EXISTS (SELECT 1 FROM list_members m WHERE m.subscriber_id = s.id AND m.list_id = " + bind(args, id) + ")"
+		listID = &id
+	}
+
+	if a.Segment != "" {
+		var id int64
+		var filter string
+		err := q.QueryRowContext(ctx, `SELECT id, filter FROM segments WHERE slug = $1`, a.Segment).Scan(&id, &filter)
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", nil, nil, ErrNotFound
+		}
+		if err != nil {
+			return "", nil, nil, err
+		}
+		expr, err := segment.Parse(filter)
+		if err != nil {
+			return "", nil, nil, fmt.Errorf("segment %s: %w", a.Segment, err)
+		}
+		conds += " AND COALESCE(" + segmentSQL(expr, args) + ", FALSE)"
+		segmentID = &id
+	}
+	return conds, listID, segmentID, nil
+}
+
+func (p *Postgres) CountAudience(ctx context.Context, a Audience) (int, error) {
+	var args []any
+	cond, _, _, err := audienceSQL(ctx, p.db, a, &args)
+	if err != nil {
+		return 0, err
+	}
+	var n int
+	err = p.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM subscribers s WHERE `+cond, args...).Scan(&n)
+	return n, err
+}
+
+func (p *Postgres) CreateList(ctx context.Context, l List) (*List, error) {
+	err := p.db.QueryRowContext(ctx, `
+		INSERT INTO lists (slug, name, description, public)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, created_at
+	`, l.Slug, l.Name, l.Description, l.Public).Scan(&l.ID, &l.CreatedAt)
+	if isUniqueViolation(err) {
+		return nil, ErrConflict
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &l, nil
+}
+
+const listColumns = `
+	SELECT l.id, l.slug, l.name, l.description, l.public, l.created_at,
+		(SELECT COUNT(*) FROM list_members m JOIN subscribers s ON s.id = m.subscriber_id
+		 WHERE m.list_id = l.id AND s.status = 'active')
+	FROM lists l`
+
+func scanList(row interface{ Scan(...any) error }) (List, error) {
+	var l List
+	err := row.Scan(&l.ID, &l.Slug, &l.Name, &l.Description, &l.Public, &l.CreatedAt, &l.Members)
+	return l, err
+}
+
+func (p *Postgres) Lists(ctx context.Context) ([]List, error) {
+	rows, err := p.db.QueryContext(ctx, listColumns+` ORDER BY l.slug`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var lists []List
+	for rows.Next() {
+		l, err := scanList(rows)
+		if err != nil {
+			return nil, err
+		}
+		lists = append(lists, l)
+	}
+	return lists, rows.Err()
+}
+
+func (p *Postgres) List(ctx context.Context, slug string) (*List, error) {
+	l, err := scanList(p.db.QueryRowContext(ctx, listColumns+` WHERE l.slug = $1`, slug))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &l, nil
+}
+
+func (p *Postgres) DeleteList(ctx context.Context, slug string) error {
+	res, err := p.db.ExecContext(ctx, `DELETE FROM lists WHERE slug = $1`, slug)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (p *Postgres) CreateSegment(ctx context.Context, s Segment) (*Segment, error) {
+	err := p.db.QueryRowContext(ctx, `
+		INSERT INTO segments (slug, name, filter)
+		VALUES ($1, $2, $3)
+		RETURNING id, created_at
+	`, s.Slug, s.Name, s.Filter).Scan(&s.ID, &s.CreatedAt)
+	if isUniqueViolation(err) {
+		return nil, ErrConflict
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+func (p *Postgres) Segments(ctx context.Context) ([]Segment, error) {
+	rows, err := p.db.QueryContext(ctx, `SELECT id, slug, name, filter, created_at FROM segments ORDER BY slug`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var segments []Segment
+	for rows.Next() {
+		var s Segment
+		if err := rows.Scan(&s.ID, &s.Slug, &s.Name, &s.Filter, &s.CreatedAt); err != nil {
+			return nil, err
+		}
+		segments = append(segments, s)
+	}
+	return segments, rows.Err()
+}
+
+func (p *Postgres) Segment(ctx context.Context, slug string) (*Segment, error) {
+	s := &Segment{}
+	err := p.db.QueryRowContext(ctx, `
+		SELECT id, slug, name, filter, created_at FROM segments WHERE slug = $1
+	`, slug).Scan(&s.ID, &s.Slug, &s.Name, &s.Filter, &s.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (p *Postgres) DeleteSegment(ctx context.Context, slug string) error {
+	res, err := p.db.ExecContext(ctx, `DELETE FROM segments WHERE slug = $1`, slug)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// setLists replaces the list memberships of email with the given slugs.
+func setLists(ctx context.Context, q execer, email string, slugs []string) error {
+	_, err := q.ExecContext(ctx, `
+		DELETE FROM list_members
+		WHERE subscriber_id = (SELECT id FROM subscribers WHERE email = $1)
+		AND list_id NOT IN (SELECT id FROM lists WHERE slug = ANY($2))
+	`, email, pq.Array(slugs))
+	if err != nil {
+		return err
+	}
+	return joinLists(ctx, q, email, slugs)
+}
+
+// joinLists adds email to the lists with the given slugs.
+func joinLists(ctx context.Context, q execer, email string, slugs []string) error {
+	if len(slugs) == 0 {
+		return nil
+	}
+	_, err := q.ExecContext(ctx, `
+		INSERT INTO list_members (list_id, subscriber_id)
+		SELECT l.id, s.id FROM lists l, subscribers s
+		WHERE l.slug = ANY($2) AND s.email = $1
+		ON CONFLICT DO NOTHING
+	`, email, pq.Array(slugs))
+	return err
+}
@@ -0,0 +1,422 @@
+// Package store is the persistence layer. Handlers and the send queue
+// depend on the interfaces declared here; Postgres backs them in
+// production and Memory in tests and local experiments.
+package store
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+)
+
+var (
+	ErrNotFound   = errors.New("not found")
+	ErrSuppressed = errors.New("address is suppressed")
+	ErrConflict   = errors.New("already exists")
+)
+
+// Subscriber statuses. Only active subscribers receive campaigns.
+const (
+	StatusActive       = "active"
+	StatusUnsubscribed = "unsubscribed"
+	StatusBounced      = "bounced"
+	StatusComplained   = "complained"
+)
+
+// Delivery states. Every recipient of a campaign starts as pending and ends
+// in exactly one of the other three.
+const (
+	DeliveryPending = "pending"
+	DeliverySent    = "sent"
+	DeliveryFailed  = "failed"
+	DeliverySkipped = "skipped"
+)
+
+// Campaign states. Drafts are edited through revisions until they are
+// sent, after which the campaign is queued. A scheduled campaign is queued
+// by the scheduler once its time has come.
+const (
+	CampaignDraft     = "draft"
+	CampaignScheduled = "scheduled"
+	CampaignQueued    = "queued"
+	CampaignSending   = "sending"
+	CampaignSent      = "sent"
+)
+
+// Delivery frequencies a subscriber can choose.
+const (
+	FrequencyEvery   = "every"   // every issue
+	FrequencyMonthly = "monthly" // only the monthly digest
+)
+
+// Verification purposes. Subscribe tokens confirm a double opt-in;
+// preferences tokens open the preference center.
+const (
+	PurposeSubscribe   = "subscribe"
+	PurposePreferences = "preferences"
+)
+
+// Resubscribable reports whether a suppression may be lifted by the owner
+// confirming a new double opt-in. Bounces and complaints never are.
+func Resubscribable(reason string) bool {
+	return reason == "" || reason == StatusUnsubscribed
+}
+
+// Consent is the evidence recorded with every subscription.
+type Consent struct {
+	IP        string
+	UserAgent string
+	Source    string
+}
+
+// Profile is the optional personal data of a subscriber.
+type Profile struct {
+	FirstName  string
+	LastName   string
+	Attributes map[string]any // custom fields such as company or role
+}
+
+// Name returns the full name, or "" when neither part is known.
+func (p Profile) Name() string {
+	return strings.TrimSpace(p.FirstName + " " + p.LastName)
+}
+
+// ProfileUpdate changes part of a profile. Nil names are left alone;
+// Attributes are merged into the existing ones and a nil value removes
+// the key. Non-nil Tags and Lists replace the current sets; unknown list
+// slugs are ignored.
+type ProfileUpdate struct {
+	FirstName  *string
+	LastName   *string
+	Attributes map[string]any
+	Tags       *[]string
+	Lists      *[]string
+	Frequency  *string
+	// PausedUntil pauses delivery until the given time; a zero time
+	// resumes it.
+	PausedUntil *time.Time
+}
+
+// Subscriber is a row of the subscriber list.
+type Subscriber struct {
+	Email       string
+	Status      string
+	Suppression string // reason the address is on the suppression list, or ""
+	Profile     Profile
+	Tags        []string
+	Lists       []string // slugs
+	Frequency   string
+	PausedUntil *time.Time
+	Consent     Consent
+	CreatedAt   time.Time
+	ConfirmedAt *time.Time
+}
+
+// Paused reports whether the subscriber has paused delivery.
+func (s *Subscriber) Paused() bool {
+	return s.PausedUntil != nil && s.PausedUntil.After(time.Now())
+}
+
+// Sendable reports whether the subscriber may receive campaigns now.
+func (s *Subscriber) Sendable() bool {
+	return s.Status == StatusActive && s.Suppression == "" && !s.Paused()
+}
+
+// Verification is a pending double opt-in.
+type Verification struct {
+	Email     string
+	Token     string
+	Purpose   string // PurposeSubscribe when empty
+	ExpiresAt time.Time
+	Verified  bool
+	Consent   Consent
+	Profile   Profile
+	Lists     []string // slugs to join once confirmed
+	CreatedAt time.Time
+}
+
+// List is a named group subscribers can belong to.
+type List struct {
+	ID          int64
+	Slug        string
+	Name        string
+	Description string
+	Public      bool // joinable from the subscribe endpoints
+	CreatedAt   time.Time
+	Members     int // active members
+}
+
+// Segment is a saved filter expression (see package segment).
+type Segment struct {
+	ID        int64
+	Slug      string
+	Name      string
+	Filter    string
+	CreatedAt time.Time
+}
+
+// Audience selects the recipients of a campaign by list or segment slug.
+// The zero value is every active subscriber who wants every issue; Digest
+// selects those on the monthly digest instead. Paused subscribers are
+// never selected.
+type Audience struct {
+	List    string
+	Segment string
+	Digest  bool
+}
+
+// Campaign is a newsletter issue together with the progress of its
+// deliveries.
+type Campaign struct {
+	ID        int64
+	Subject   string
+	Audience  Audience
+	Revision  int // latest draft revision, 0 for campaigns sent inline
+	Status    string
+	CreatedAt time.Time
+	// ScheduledAt is when a scheduled campaign starts; TimeZone is the IANA
+	// name it was scheduled in.
+	ScheduledAt *time.Time
+	TimeZone    string
+	StartedAt   *time.Time
+	FinishedAt  *time.Time
+	Queued      int
+	Sent        int
+	Failed      int
+	Skipped     int
+	// Errors are the first MaxDeliveryErrors failed deliveries; Failed
+	// counts all of them.
+	Errors []DeliveryError
+}
+
+// MaxDeliveryErrors bounds the failed deliveries Campaign lists, so the
+// status of a large campaign stays small during a provider outage.
+const MaxDeliveryErrors = 100
+
+// Done reports whether every delivery of the campaign has been settled.
+func (c *Campaign) Done() bool {
+	return c.FinishedAt != nil
+}
+
+// Draft is the editable content of a campaign.
+type Draft struct {
+	Subject   string
+	Preheader string
+	Body      string // Markdown
+	Theme     string // "" for the default theme
+	Audience  Audience
+	// Tags label the draft for the admin; they are not sent.
+	Tags []string
+	// UTMCampaign, if set, is added to the links of the campaign.
+	UTMCampaign string
+	// TrustedHTML keeps raw HTML in the body as written instead of
+	// sanitizing it.
+	TrustedHTML bool
+}
+
+// Revision is an immutable saved version of a draft. Numbers start at 1.
+type Revision struct {
+	CampaignID int64
+	Number     int
+	Draft
+	CreatedAt time.Time
+}
+
+// DeliveryError describes a recipient that could not be mailed.
+type DeliveryError struct {
+	Email string
+	Error string
+}
+
+// Delivery is a claimed row of a campaign's recipient list.
+type Delivery struct {
+	ID         int64
+	CampaignID int64
+	Email      string
+	Attempts   int
+}
+
+// IdempotentRequest is a request recorded under its Idempotency-Key.
+type IdempotentRequest struct {
+	Key         string
+	RequestHash string
+	Status      int // 0 while the request is still being handled
+	Response    []byte
+	CampaignID  int64
+	CreatedAt   time.Time
+}
+
+// Event is delivery feedback reported by the email provider.
+type Event struct {
+	Email      string
+	Event      string
+	CampaignID int64 // 0 when unknown
+	MessageID  string
+	Reason     string
+	OccurredAt time.Time
+}
+
+type SubscriberStore interface {
+	// IsSubscribed reports whether email is an active subscriber.
+	IsSubscribed(ctx context.Context, email string) (bool, error)
+	// SubscriberExists reports whether email has a subscriber row in any
+	// status.
+	SubscriberExists(ctx context.Context, email string) (bool, error)
+	// AddSubscriber inserts an active subscriber without confirmation and
+	// adds it to the given lists.
+	AddSubscriber(ctx context.Context, email string, c Consent, p Profile, lists []string) error
+	// Subscriber returns the subscriber row for email or ErrNotFound.
+	Subscriber(ctx context.Context, email string) (*Subscriber, error)
+	// UpdateProfile applies u and returns the updated subscriber, or
+	// ErrNotFound.
+	UpdateProfile(ctx context.Context, email string, u ProfileUpdate) (*Subscriber, error)
+	// AttributeKeys lists the custom attribute names in use.
+	AttributeKeys(ctx context.Context) ([]string, error)
+	// Suppression returns the reason email is suppressed, or "".
+	Suppression(ctx context.Context, email string) (string, error)
+	// Suppress flags the subscriber with status (unsubscribed, bounced or
+	// complained) and adds the address to the global suppression list.
+	// It reports whether an active subscriber was flagged.
+	Suppress(ctx context.Context, email, status, reason string) (bool, error)
+}
+
+type VerificationStore interface {
+	// SaveVerification replaces any pending verification for v.Email
+	// with the same purpose.
+	SaveVerification(ctx context.Context, v Verification) error
+	// Verification returns the verification for token or ErrNotFound.
+	Verification(ctx context.Context, token string) (*Verification, error)
+	// ConfirmVerification marks the token verified and (re)activates the
+	// subscriber with the recorded consent and lists, lifting an earlier
+	// unsubscribe.
+	// It returns ErrSuppressed for bounced or complained addresses and
+	// ErrNotFound for tokens of another purpose.
+	ConfirmVerification(ctx context.Context, token string) error
+}
+
+type CampaignStore interface {
+	// CreateCampaign stores a rendered newsletter with one pending
+	// delivery per sendable subscriber in the audience and returns the
+	// campaign ID and recipient count. An unknown list or segment is
+	// ErrNotFound. textBody is the plain-text alternative and may be empty.
+	CreateCampaign(ctx context.Context, subject, htmlBody, textBody string, a Audience) (int64, int, error)
+	// Campaign returns a campaign with its delivery counts and first
+	// failed deliveries, or ErrNotFound.
+	Campaign(ctx context.Context, id int64) (*Campaign, error)
+	// CampaignContent returns what to send for a campaign.
+	CampaignContent(ctx context.Context, id int64) (subject, htmlBody, textBody string, err error)
+	// ClaimDeliveries leases up to limit pending deliveries of a single
+	// campaign. Leased rows are invisible to other claimers until the
+	// lease expires or they are settled.
+	ClaimDeliveries(ctx context.Context, limit int, lease time.Duration) ([]Delivery, error)
+	MarkCampaignStarted(ctx context.Context, id int64) error
+	SettleDelivery(ctx context.Context, id int64, status, reason string) error
+	// RetryDelivery keeps a delivery pending but hides it for delay.
+	RetryDelivery(ctx context.Context, id int64, delay time.Duration, reason string) error
+	// ReleaseDelivery returns a claimed delivery untouched.
+	ReleaseDelivery(ctx context.Context, id int64) error
+	// FinishCampaign marks a campaign sent once nothing is pending and
+	// reports whether it did.
+	FinishCampaign(ctx context.Context, id int64) (bool, error)
+	// FinishSettledCampaigns closes every campaign with nothing pending.
+	FinishSettledCampaigns(ctx context.Context) error
+	// PendingDeliveries counts unfinished campaigns and their deliveries.
+	PendingDeliveries(ctx context.Context) (campaigns, deliveries int, err error)
+	// DuplicateCampaign returns the ID of the latest campaign other than
+	// except with the same subject, HTML and audience that was queued
+	// since the given time or is scheduled to start, or ErrNotFound.
+	DuplicateCampaign(ctx context.Context, subject, htmlBody string, a Audience, since time.Time, except int64) (int64, error)
+}
+
+type DraftStore interface {
+	// CreateDraft stores a new draft campaign with d as its first revision.
+	CreateDraft(ctx context.Context, d Draft) (*Revision, error)
+	// SaveDraft appends d as a new revision of a draft campaign. It returns
+	// ErrNotFound for an unknown campaign and ErrConflict once the campaign
+	// has been sent.
+	SaveDraft(ctx context.Context, id int64, d Draft) (*Revision, error)
+	// Drafts returns the latest revision of every unsent draft, newest
+	// first.
+	Drafts(ctx context.Context) ([]Revision, error)
+	// Revisions returns every revision of a campaign, oldest first, or
+	// ErrNotFound.
+	Revisions(ctx context.Context, id int64) ([]Revision, error)
+	// Revision returns revision number of a campaign, or the latest one for
+	// number 0. It returns ErrNotFound if either does not exist.
+	Revision(ctx context.Context, id int64, number int) (*Revision, error)
+	// DeleteDraft removes an unsent draft with its revisions. It returns
+	// ErrConflict once the campaign has been scheduled or sent.
+	DeleteDraft(ctx context.Context, id int64) error
+	// SendDraft queues a draft or scheduled campaign right away like
+	// CreateCampaign, with the content rendered from revision, and returns
+	// the recipient count. It returns ErrConflict if the campaign has
+	// started or revision is not its latest, and ErrNotFound for an
+	// unknown campaign, list or segment.
+	SendDraft(ctx context.Context, id int64, revision int, subject, htmlBody, textBody string, a Audience) (int, error)
+	// ScheduleDraft stores the content rendered from revision and
+	// schedules a draft to start at the given time, or moves the time of a
+	// campaign that is already scheduled. Force skips the duplicate check
+	// when it starts. Errors are as for SendDraft.
+	ScheduleDraft(ctx context.Context, id int64, revision int, subject, htmlBody, textBody string, at time.Time, timeZone string, force bool) error
+	// UnscheduleDraft turns a scheduled campaign back into a draft. It
+	// returns ErrConflict if the campaign is not scheduled.
+	UnscheduleDraft(ctx context.Context, id int64) error
+	// StartDueCampaign queues the deliveries of one scheduled campaign
+	// whose time has come, for the audience of its latest revision, and
+	// returns its ID and recipient count. It returns ErrNotFound when
+	// nothing is due. Concurrent callers, also in other processes, never
+	// start the same campaign. A campaign whose list or segment has been
+	// deleted, or that was not forced and duplicates a campaign queued
+	// within window before it (see DuplicateCampaign), is turned back into
+	// a draft and reported with an error. A zero window skips that check.
+	StartDueCampaign(ctx context.Context, window time.Duration) (int64, int, error)
+}
+
+type ListStore interface {
+	// CreateList returns ErrConflict if the slug is taken.
+	CreateList(ctx context.Context, l List) (*List, error)
+	Lists(ctx context.Context) ([]List, error)
+	// List returns the list with slug or ErrNotFound.
+	List(ctx context.Context, slug string) (*List, error)
+	DeleteList(ctx context.Context, slug string) error
+	// CreateSegment returns ErrConflict if the slug is taken. The filter
+	// must already have been validated with segment.Parse.
+	CreateSegment(ctx context.Context, s Segment) (*Segment, error)
+	Segments(ctx context.Context) ([]Segment, error)
+	// Segment returns the segment with slug or ErrNotFound.
+	Segment(ctx context.Context, slug string) (*Segment, error)
+	DeleteSegment(ctx context.Context, slug string) error
+	// CountAudience counts the sendable subscribers a selects.
+	CountAudience(ctx context.Context, a Audience) (int, error)
+}
+
+type IdempotencyStore interface {
+	// ReserveIdempotencyKey records key for a new request and returns nil,
+	// or returns the request recorded earlier under key. A reservation
+	// that got no response within stale is taken over.
+	ReserveIdempotencyKey(ctx context.Context, key, requestHash string, stale time.Duration) (*IdempotentRequest, error)
+	// CompleteIdempotencyKey stores the response to a reserved request.
+	CompleteIdempotencyKey(ctx context.Context, key string, status int, response []byte, campaignID int64) error
+	// ReleaseIdempotencyKey drops a reservation so the request can be
+	// retried.
+	ReleaseIdempotencyKey(ctx context.Context, key string) error
+}
+
+type EventStore interface {
+	// RecordEvent stores ev and reports whether it was new.
+	RecordEvent(ctx context.Context, ev Event) (bool, error)
+	// CountEvents counts events of the given kinds for email since a time.
+	CountEvents(ctx context.Context, email string, since time.Time, events ...string) (int, error)
+}
+
+// Store is the full persistence layer.
+type Store interface {
+	SubscriberStore
+	VerificationStore
+	CampaignStore
+	DraftStore
+	ListStore
+	IdempotencyStore
+	EventStore
+}
@@ -0,0 +1,178 @@
+// Package templates renders emails through named themes. A theme is a
+// directory of html/template files that together define the "layout",
+// "header", "footer" and "verification" templates. The dark and light
+// themes are built in; a directory on disk can add more or replace them.
+package templates
+
+import (
+	"bytes"
+	"embed"
+	"errors"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/pixperk/newsletter/utils"
+)
+
+//go:embed themes
+var builtin embed.FS
+
+// DefaultTheme is used when DEFAULT_THEME is unset.
+const DefaultTheme = "dark"
+
+// ErrUnknownTheme is returned for a theme name the registry does not have.
+var ErrUnknownTheme = errors.New("unknown theme")
+
+// requiredTemplates are the templates every theme must define.
+var requiredTemplates = []string{"layout", "header", "footer", "verification"}
+
+var funcs = template.FuncMap{
+	// preheaderPadding follows the preheader so clients do not fill the
+	// inbox preview with the start of the body.
+	"preheaderPadding": func() template.HTML {
+		return template.HTML(strings.Repeat("&#847;&zwnj;&nbsp;", 40))
+	},
+}
+
+// Page is the data a theme's templates are executed with.
+type Page struct {
+	Title     string
+	Preheader string
+	// Label follows the brand in the header, e.g. "newsletter".
+	Label   string
+	Content template.HTML
+	// URL is the link the verification email asks to open.
+	URL  string
+	Year int
+}
+
+// Theme is a parsed set of templates.
+type Theme struct {
+	Name string
+	tmpl *template.Template
+}
+
+// Registry holds the themes available to campaigns.
+type Registry struct {
+	themes       map[string]*Theme
+	defaultTheme string
+}
+
+// Load returns the built-in themes plus those in the subdirectories of
+// dir, if set; a subdirectory named like a built-in theme replaces it.
+// defaultTheme, DefaultTheme if empty, is used by campaigns that do not
+// choose one and must exist.
+func Load(dir, defaultTheme string) (*Registry, error) {
+	if defaultTheme == "" {
+		defaultTheme = DefaultTheme
+	}
+	reg := &Registry{themes: map[string]*Theme{}, defaultTheme: defaultTheme}
+
+	themes, err := fs.Sub(builtin, "themes")
+	if err != nil {
+		return nil, err
+	}
+	if err := reg.load(themes); err != nil {
+		return nil, err
+	}
+	if dir != "" {
+		if err := reg.load(os.DirFS(dir)); err != nil {
+			return nil, fmt.Errorf("themes in %s: %w", dir, err)
+		}
+	}
+
+	if _, ok := reg.themes[defaultTheme]; !ok {
+		return nil, fmt.Errorf("default theme %q: %w", defaultTheme, ErrUnknownTheme)
+	}
+	return reg, nil
+}
+
+// load parses every subdirectory of fsys as a theme.
+func (reg *Registry) load(fsys fs.FS) error {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		t, err := template.New(e.Name()).Funcs(funcs).ParseFS(fsys, e.Name()+"/*.html")
+		if err != nil {
+			return fmt.Errorf("theme %s: %w", e.Name(), err)
+		}
+		for _, name := range requiredTemplates {
+			if t.Lookup(name) == nil {
+				return fmt.Errorf("theme %s: no %q template", e.Name(), name)
+			}
+		}
+		reg.themes[e.Name()] = &Theme{Name: e.Name(), tmpl: t}
+	}
+	return nil
+}
+
+// Theme returns the named theme, or the default one for "".
+func (reg *Registry) Theme(name string) (*Theme, error) {
+	if name == "" {
+		name = reg.defaultTheme
+	}
+	t, ok := reg.themes[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownTheme, name)
+	}
+	return t, nil
+}
+
+// Default returns the default theme.
+func (reg *Registry) Default() *Theme {
+	return reg.themes[reg.defaultTheme]
+}
+
+// Names returns the names of the available themes in order.
+func (reg *Registry) Names() []string {
+	return slices.Sorted(maps.Keys(reg.themes))
+}
+
+// Newsletter wraps the HTML body of a campaign (including its footer) in
+// the theme's layout. A non-empty preheader becomes the hidden inbox
+// preview text. Merge tags in the subject and preheader are kept as they
+// are for the per-recipient rendering.
+func (t *Theme) Newsletter(subject, preheader, htmlBody string) (string, error) {
+	var g utils.MergeTagGuard
+	out, err := t.execute("layout", Page{
+		Title:     g.Protect(subject),
+		Preheader: g.Protect(preheader),
+		Label:     "newsletter",
+		Content:   template.HTML(htmlBody),
+		Year:      time.Now().Year(),
+	})
+	if err != nil {
+		return "", err
+	}
+	return g.Restore(out), nil
+}
+
+// Verification renders the email that asks a new subscriber to open url.
+func (t *Theme) Verification(subject, url string) (string, error) {
+	p := Page{Title: subject, Label: "verify", URL: url, Year: time.Now().Year()}
+	content, err := t.execute("verification", p)
+	if err != nil {
+		return "", err
+	}
+	p.Content = template.HTML(content)
+	return t.execute("layout", p)
+}
+
+func (t *Theme) execute(name string, p Page) (string, error) {
+	var buf bytes.Buffer
+	if err := t.tmpl.ExecuteTemplate(&buf, name, p); err != nil {
+		return "", fmt.Errorf("theme %s: %w", t.Name, err)
+	}
+	return buf.String(), nil
+}
@@ -0,0 +1,233 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"net/http/httptrace"
+	"slices"
+	"sync/atomic"
+	"time"
+)
+
+const brevoEndpoint = "https://api.brevo.com/v3/smtp/email"
+
+// brevoMaxVersions is Brevo's limit on messageVersions per request.
+const brevoMaxVersions = 1000
+
+// brevoMaxBatchBytes caps the JSON body of a messageVersions request.
+// Content rendered per recipient is repeated in every version, so a full
+// batch of a long issue would otherwise run to tens of megabytes.
+const brevoMaxBatchBytes = 4 << 20
+
+// brevoRequestTimeout bounds a single API call, including reading the
+// response, so a hung connection cannot stall a worker. It leaves room for
+// full messageVersions batches.
+const brevoRequestTimeout = 30 * time.Second
+
+type Recipient struct {
+	Email string `json:"email"`
+	Name  string `json:"name"`
+}
+
+type BrevoEmail struct {
+	Sender          Sender                `json:"sender"`
+	To              []Recipient           `json:"to,omitempty"`
+	Subject         string                `json:"subject"`
+	HtmlContent     string                `json:"htmlContent"`
+	TextContent     string                `json:"textContent,omitempty"`
+	Params          map[string]string     `json:"params,omitempty"`
+	Headers         map[string]string     `json:"headers,omitempty"`
+	Tags            []string              `json:"tags,omitempty"`
+	MessageVersions []BrevoMessageVersion `json:"messageVersions,omitempty"`
+}
+
+// BrevoMessageVersion is one personalized copy of a batched email. Subject
+// and content are only set when they differ from the base message. Brevo
+// documents no per-version headers, so headers can only be set for the
+// whole batch.
+type BrevoMessageVersion struct {
+	To          []Recipient       `json:"to"`
+	Params      map[string]string `json:"params,omitempty"`
+	Subject     string            `json:"subject,omitempty"`
+	HtmlContent string            `json:"htmlContent,omitempty"`
+	TextContent string            `json:"textContent,omitempty"`
+}
+
+// BrevoSender delivers mail through the Brevo transactional HTTP API.
+type BrevoSender struct {
+	apiKey   string
+	sender   Sender
+	endpoint string
+	client   *http.Client
+	retry    RetryPolicy
+}
+
+func NewBrevoSender(apiKey string, sender Sender) (*BrevoSender, error) {
+	if apiKey == "" {
+		return nil, errors.New("BREVO_API_KEY is not set")
+	}
+	if sender.Email == "" {
+		return nil, errors.New("sender email is not set")
+	}
+	return &BrevoSender{
+		apiKey:   apiKey,
+		sender:   sender,
+		endpoint: brevoEndpoint,
+		client:   httpClient,
+		retry:    DefaultRetryPolicy,
+	}, nil
+}
+
+// Send delivers msg, retrying throttled and transient failures with
+// backoff. The returned error is a *SendError.
+func (b *BrevoSender) Send(ctx context.Context, msg Message) error {
+	name := msg.ToName
+	if name == "" {
+		name = msg.To
+	}
+
+	email := BrevoEmail{
+		Sender: b.sender,
+		To: []Recipient{
+			{Email: msg.To, Name: name},
+		},
+		Subject:     msg.Subject,
+		HtmlContent: msg.HTML,
+		TextContent: msg.Text,
+		Params:      msg.Params,
+		Headers:     msg.Headers,
+		Tags:        msg.Tags,
+	}
+
+	payload, _ := json.Marshal(email)
+
+	return b.retry.Do(ctx, func(ctx context.Context) error {
+		return b.post(ctx, payload)
+	})
+}
+
+func (b *BrevoSender) MaxBatchSize() int {
+	return brevoMaxVersions
+}
+
+func (b *BrevoSender) MaxBatchBytes() int {
+	return brevoMaxBatchBytes
+}
+
+// SendBatch delivers msgs in one request using messageVersions. The first
+// message provides the base subject, content, headers and tags. Messages
+// must share headers and tags; SendAll only batches such runs.
+func (b *BrevoSender) SendBatch(ctx context.Context, msgs []Message) error {
+	if len(msgs) == 0 {
+		return nil
+	}
+	if len(msgs) > brevoMaxVersions {
+		return &SendError{Err: fmt.Errorf("batch of %d exceeds %d message versions", len(msgs), brevoMaxVersions)}
+	}
+
+	base := msgs[0]
+	if slices.ContainsFunc(msgs, func(m Message) bool {
+		return !maps.Equal(m.Headers, base.Headers) || !slices.Equal(m.Tags, base.Tags)
+	}) {
+		return &SendError{Err: errors.New("batched messages must share headers and tags")}
+	}
+	email := BrevoEmail{
+		Sender:      b.sender,
+		Subject:     base.Subject,
+		HtmlContent: base.HTML,
+		TextContent: base.Text,
+		Headers:     base.Headers,
+		Tags:        base.Tags,
+	}
+	for _, msg := range msgs {
+		name := msg.ToName
+		if name == "" {
+			name = msg.To
+		}
+		v := BrevoMessageVersion{
+			To:     []Recipient{{Email: msg.To, Name: name}},
+			Params: msg.Params,
+		}
+		if msg.Subject != base.Subject {
+			v.Subject = msg.Subject
+		}
+		if msg.HTML != base.HTML {
+			v.HtmlContent = msg.HTML
+		}
+		if msg.Text != base.Text {
+			v.TextContent = msg.Text
+		}
+		email.MessageVersions = append(email.MessageVersions, v)
+	}
+
+	payload, _ := json.Marshal(email)
+	if len(payload) > brevoMaxBatchBytes {
+		return &SendError{Err: fmt.Errorf("batch of %d bytes exceeds %d", len(payload), brevoMaxBatchBytes)}
+	}
+
+	return b.retry.Do(ctx, func(ctx context.Context) error {
+		return b.post(ctx, payload)
+	})
+}
+
+func (b *BrevoSender) post(parent context.Context, payload []byte) error {
+	ctx, cancel := context.WithTimeout(parent, brevoRequestTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, "POST", b.endpoint, bytes.NewReader(payload))
+	if err != nil {
+		return &SendError{Err: err}
+	}
+
+	req.Header.Set("accept", "application/json")
+	req.Header.Set("content-type", "application/json")
+	req.Header.Set("api-key", b.apiKey)
+
+	var written atomic.Bool
+	req = req.WithContext(httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+		WroteRequest: func(info httptrace.WroteRequestInfo) {
+			if info.Err == nil {
+				written.Store(true)
+			}
+		},
+	}))
+
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return networkError(parent, written.Load(), fmt.Errorf("failed to send request to Brevo: %w", err))
+	}
+	defer resp.Body.Close()
+
+	// Read response body for detailed error information
+	body, err := io.ReadAll(resp.Body)
+	if err != nil && resp.StatusCode < 400 {
+		// The status line already says the email was accepted.
+		return nil
+	}
+	if err != nil {
+		return classifyStatus(resp, fmt.Errorf("brevo API error (%s), failed to read response: %w", resp.Status, err))
+	}
+
+	if resp.StatusCode >= 400 {
+		// Try to parse Brevo error response
+		var brevoError struct {
+			Message string `json:"message"`
+			Code    string `json:"code"`
+		}
+
+		if json.Unmarshal(body, &brevoError) == nil && brevoError.Message != "" {
+			return classifyStatus(resp, fmt.Errorf("brevo API error (%s): %s", resp.Status, brevoError.Message))
+		}
+
+		// Fallback to status and raw body if parsing fails
+		return classifyStatus(resp, fmt.Errorf("brevo API error (%s): %s", resp.Status, string(body)))
+	}
+
+	return nil
+}
@@ -0,0 +1,163 @@
+package utils
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// brevoServer returns a sender posting to a test server that decodes every
+// request into the returned slice.
+func brevoServer(t *testing.T, handler http.HandlerFunc) (*BrevoSender, *[]BrevoEmail) {
+	t.Helper()
+	var got []BrevoEmail
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var email BrevoEmail
+		if err := json.NewDecoder(r.Body).Decode(&email); err != nil {
+			t.Errorf("decode request: %v", err)
+		}
+		got = append(got, email)
+		if handler != nil {
+			handler(w, r)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"messageIds":["<1@smtp-relay.mailin.fr>"]}`))
+	}))
+	t.Cleanup(srv.Close)
+
+	b, err := NewBrevoSender("key", Sender{Name: "News", Email: "news@example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.endpoint = srv.URL
+	b.client = srv.Client()
+	return b, &got
+}
+
+func TestPerRecipientHeadersAreSentSingly(t *testing.T) {
+	b, got := brevoServer(t, nil)
+
+	var msgs []Message
+	for _, to := range []string{"a@example.com", "b@example.com", "c@example.com"} {
+		msgs = append(msgs, Message{
+			To:      to,
+			Subject: "Issue 1",
+			HTML:    "<p>Hi</p>",
+			Headers: UnsubscribeHeaders(to),
+			Tags:    []string{CampaignTag(7)},
+		})
+	}
+	for i, err := range SendAll(context.Background(), b, msgs) {
+		if err != nil {
+			t.Fatalf("message %d: %v", i, err)
+		}
+	}
+
+	// Brevo has no per-version headers, so a batch would have to drop
+	// each recipient's one-click unsubscribe.
+	if len(*got) != len(msgs) {
+		t.Fatalf("%d requests, want one per message", len(*got))
+	}
+	for i, email := range *got {
+		want := "<" + UnsubscribeURL(msgs[i].To) + ">"
+		if len(email.MessageVersions) != 0 || email.To[0].Email != msgs[i].To {
+			t.Errorf("request %d = %+v, want a single message to %s", i, email, msgs[i].To)
+		}
+		if email.Headers["List-Unsubscribe"] != want || email.Headers["List-Unsubscribe-Post"] != "List-Unsubscribe=One-Click" {
+			t.Errorf("request %d headers = %v, want List-Unsubscribe %s with one-click", i, email.Headers, want)
+		}
+	}
+}
+
+func TestSendBatchSharedHeaders(t *testing.T) {
+	b, got := brevoServer(t, nil)
+
+	headers := map[string]string{"List-Unsubscribe": "<mailto:leave@example.com>", "X-Mailin-custom": "issue-1"}
+	var msgs []Message
+	for _, to := range []string{"a@example.com", "b@example.com", "c@example.com"} {
+		msgs = append(msgs, Message{To: to, Subject: "Issue 1", HTML: "<p>Hi " + to + "</p>", Headers: headers})
+	}
+	for i, err := range SendAll(context.Background(), b, msgs) {
+		if err != nil {
+			t.Fatalf("message %d: %v", i, err)
+		}
+	}
+
+	if len(*got) != 1 {
+		t.Fatalf("%d requests, want one batch", len(*got))
+	}
+	email := (*got)[0]
+	if len(email.MessageVersions) != len(msgs) {
+		t.Fatalf("%d message versions, want %d", len(email.MessageVersions), len(msgs))
+	}
+	// Headers on the batch reach every recipient.
+	if !maps.Equal(email.Headers, headers) {
+		t.Errorf("batch headers = %v, want %v", email.Headers, headers)
+	}
+	for i, v := range email.MessageVersions {
+		want := msgs[i].HTML
+		if i == 0 {
+			want = "" // the base content
+		}
+		if v.To[0].Email != msgs[i].To || v.HtmlContent != want {
+			t.Errorf("version %d = %+v, want %s with %q", i, v, msgs[i].To, want)
+		}
+	}
+}
+
+func TestSendBatchRejectsDifferentHeaders(t *testing.T) {
+	b, got := brevoServer(t, nil)
+
+	err := b.SendBatch(context.Background(), []Message{
+		{To: "a@example.com", Headers: UnsubscribeHeaders("a@example.com")},
+		{To: "b@example.com", Headers: UnsubscribeHeaders("b@example.com")},
+	})
+	if err == nil || IsRetryable(err) {
+		t.Errorf("err = %v, want a permanent error", err)
+	}
+	if len(*got) != 0 {
+		t.Errorf("%d requests, want none", len(*got))
+	}
+}
+
+func TestSendBatchStaysWithinPayloadLimit(t *testing.T) {
+	var sizes []int64
+	b, got := brevoServer(t, func(w http.ResponseWriter, r *http.Request) {
+		sizes = append(sizes, r.ContentLength)
+		w.WriteHeader(http.StatusCreated)
+	})
+
+	// Personalized bodies differ per recipient, so every version repeats
+	// the whole issue.
+	issue := strings.Repeat("<p>A long paragraph of the issue.</p>", 3000)
+	var msgs []Message
+	for i := range 100 {
+		to := fmt.Sprintf("reader%d@example.com", i)
+		msgs = append(msgs, Message{To: to, Subject: "Issue 1", HTML: "<p>Hi " + to + "</p>" + issue, Tags: []string{CampaignTag(7)}})
+	}
+	for i, err := range SendAll(context.Background(), b, msgs) {
+		if err != nil {
+			t.Fatalf("message %d: %v", i, err)
+		}
+	}
+
+	if len(*got) < 2 {
+		t.Errorf("%d requests, want the batch split by size", len(*got))
+	}
+	versions := 0
+	for i, email := range *got {
+		if sizes[i] > brevoMaxBatchBytes {
+			t.Errorf("request %d is %d bytes, over %d", i, sizes[i], brevoMaxBatchBytes)
+		}
+		versions += len(email.MessageVersions)
+	}
+	if versions != len(msgs) {
+		t.Errorf("%d message versions, want %d", versions, len(msgs))
+	}
+}
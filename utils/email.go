@@ -1,82 +1,187 @@
 package utils
 
 import (
-	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
-	"io"
-	"net/http"
+	"log"
+	"maps"
 	"os"
+	"slices"
+	"strconv"
+	"strings"
 )
 
-type Sender struct {
-	Name  string `json:"name"`
-	Email string `json:"email"`
+// Message is a single outgoing email, independent of the transport used to
+// deliver it.
+type Message struct {
+	To      string
+	ToName  string
+	Subject string
+	HTML    string
+	// Text is the plain-text alternative; empty sends HTML only.
+	Text string
+	// Params are per-recipient values for providers with server-side
+	// templating (Brevo's {{ params.NAME }}).
+	Params map[string]string
+	// Headers are extra MIME headers such as List-Unsubscribe.
+	Headers map[string]string
+	// Tags label the message at the provider so webhook events can be
+	// traced back, e.g. to a campaign (see CampaignTag).
+	Tags []string
 }
 
-type Recipient struct {
-	Email string `json:"email"`
-	Name  string `json:"name"`
+// CampaignTag is the provider tag attached to every message of a campaign.
+func CampaignTag(id int64) string {
+	return "campaign-" + strconv.FormatInt(id, 10)
 }
 
-type BrevoEmail struct {
-	Sender      Sender      `json:"sender"`
-	To          []Recipient `json:"to"`
-	Subject     string      `json:"subject"`
-	HtmlContent string      `json:"htmlContent"`
+// ParseCampaignTag finds the campaign ID among provider tags.
+func ParseCampaignTag(tags []string) (int64, bool) {
+	for _, tag := range tags {
+		if rest, ok := strings.CutPrefix(tag, "campaign-"); ok {
+			if id, err := strconv.ParseInt(rest, 10, 64); err == nil {
+				return id, true
+			}
+		}
+	}
+	return 0, false
 }
 
-func SendEmail(to, subject, htmlBody string) error {
-	email := BrevoEmail{
-		Sender: Sender{
-			Name:  "Yashaswi",
-			Email: os.Getenv("BREVO_SENDER_EMAIL"),
-		},
-		To: []Recipient{
-			{Email: to, Name: to},
-		},
-		Subject:     subject,
-		HtmlContent: htmlBody,
-	}
+// EmailSender delivers messages. Handlers depend on this interface rather
+// than on a particular provider so the transport can be chosen at startup.
+type EmailSender interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// BatchSender is implemented by providers that can deliver several
+// messages in a single request. SendBatch is all-or-nothing.
+type BatchSender interface {
+	EmailSender
+	SendBatch(ctx context.Context, msgs []Message) error
+	MaxBatchSize() int
+	// MaxBatchBytes bounds the encoded size of the messages in one batch;
+	// zero means no limit.
+	MaxBatchBytes() int
+}
 
-	payload, _ := json.Marshal(email)
+// SendAll delivers msgs and returns one error per message. Batch-capable
+// senders get runs of up to MaxBatchSize consecutive messages, at most
+// MaxBatchBytes in size, with the same headers and tags, which a batch
+// can only carry once. Messages with their own headers, such as a
+// per-recipient List-Unsubscribe, are therefore sent one by one. A batch
+// that fails as a whole is retried message by message so one bad address
+// cannot sink the rest; a batch the provider may have accepted is never
+// resent.
+func SendAll(ctx context.Context, sender EmailSender, msgs []Message) []error {
+	errs := make([]error, len(msgs))
 
-	req, err := http.NewRequest("POST", "https://api.brevo.com/v3/smtp/email", bytes.NewBuffer(payload))
-	if err != nil {
-		return err
+	bs, ok := sender.(BatchSender)
+	if !ok || bs.MaxBatchSize() < 2 {
+		for i, msg := range msgs {
+			errs[i] = sender.Send(ctx, msg)
+		}
+		return errs
 	}
 
-	req.Header.Set("accept", "application/json")
-	req.Header.Set("content-type", "application/json")
-	req.Header.Set("api-key", os.Getenv("BREVO_API_KEY"))
+	for start, end := 0, 0; start < len(msgs); start = end {
+		end = batchEnd(msgs, start, bs.MaxBatchSize(), bs.MaxBatchBytes())
+		chunk := msgs[start:end]
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		return fmt.Errorf("failed to send request to Brevo: %w", err)
+		if len(chunk) > 1 {
+			err := bs.SendBatch(ctx, chunk)
+			if err == nil {
+				continue
+			}
+			if ctx.Err() != nil || OutcomeUnknown(err) {
+				for i := start; i < end; i++ {
+					errs[i] = err
+				}
+				continue
+			}
+			log.Printf("batch of %d failed, falling back to single sends: %v", len(chunk), err)
+		}
+
+		for i := start; i < end; i++ {
+			errs[i] = sender.Send(ctx, msgs[i])
+		}
 	}
-	defer resp.Body.Close()
+	return errs
+}
 
-	// Read response body for detailed error information
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return fmt.Errorf("failed to read Brevo response: %w", err)
+// Sender identifies the From address of outgoing mail.
+type Sender struct {
+	Name  string `json:"name"`
+	Email string `json:"email"`
+}
+
+// SenderFromEnv returns the From identity shared by every backend.
+// EMAIL_FROM takes precedence; BREVO_SENDER_EMAIL is kept for existing
+// deployments.
+func SenderFromEnv() Sender {
+	name := os.Getenv("EMAIL_FROM_NAME")
+	if name == "" {
+		name = "Yashaswi"
+	}
+	email := os.Getenv("EMAIL_FROM")
+	if email == "" {
+		email = os.Getenv("BREVO_SENDER_EMAIL")
 	}
+	return Sender{Name: name, Email: email}
+}
 
-	if resp.StatusCode >= 400 {
-		// Try to parse Brevo error response
-		var brevoError struct {
-			Message string `json:"message"`
-			Code    string `json:"code"`
+// NewEmailSenderFromEnv builds the sender selected by EMAIL_BACKEND
+// ("brevo", "smtp" or "file"). Brevo is the default.
+func NewEmailSenderFromEnv() (EmailSender, error) {
+	backend := strings.ToLower(strings.TrimSpace(os.Getenv("EMAIL_BACKEND")))
+	switch backend {
+	case "", "brevo":
+		return NewBrevoSender(os.Getenv("BREVO_API_KEY"), SenderFromEnv())
+	case "smtp":
+		return NewSMTPSender(SMTPConfig{
+			Host:     os.Getenv("SMTP_HOST"),
+			Port:     os.Getenv("SMTP_PORT"),
+			Username: os.Getenv("SMTP_USERNAME"),
+			Password: os.Getenv("SMTP_PASSWORD"),
+		}, SenderFromEnv())
+	case "file":
+		dir := os.Getenv("MAIL_SINK_DIR")
+		if dir == "" {
+			dir = "mail"
 		}
+		return NewFileSender(dir, SenderFromEnv())
+	default:
+		return nil, fmt.Errorf("unknown EMAIL_BACKEND %q", backend)
+	}
+}
 
-		if json.Unmarshal(body, &brevoError) == nil && brevoError.Message != "" {
-			return fmt.Errorf("brevo API error (%s): %s", resp.Status, brevoError.Message)
+// batchEnd returns the end of the batch that starts at msgs[start]: at
+// most size messages, and maxBytes of them when that is set, sharing the
+// headers and tags a batch carries once. Subject and content can differ
+// per message. Personalized content is repeated for
+// every message, so the byte limit is what keeps large issues in bounds.
+func batchEnd(msgs []Message, start, size, maxBytes int) int {
+	end := start + 1
+	bytes := encodedSize(msgs[start])
+	for end < len(msgs) && end-start < size && sameEnvelope(msgs[end], msgs[start]) {
+		if maxBytes > 0 {
+			bytes += encodedSize(msgs[end])
+			if bytes > maxBytes {
+				break
+			}
 		}
-
-		// Fallback to status and raw body if parsing fails
-		return fmt.Errorf("brevo API error (%s): %s", resp.Status, string(body))
+		end++
 	}
+	return end
+}
+
+// sameEnvelope reports whether a and b can share a batch.
+func sameEnvelope(a, b Message) bool {
+	return maps.Equal(a.Headers, b.Headers) && slices.Equal(a.Tags, b.Tags)
+}
 
-	return nil
+// encodedSize estimates how much msg adds to a JSON batch request.
+func encodedSize(msg Message) int {
+	b, _ := json.Marshal(msg)
+	return len(b)
 }
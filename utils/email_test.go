@@ -0,0 +1,114 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// fakeBatchSender records batches and single sends. Batches fail with
+// batchErr and single sends to failTo fail.
+type fakeBatchSender struct {
+	max      int
+	maxBytes int
+	batchErr error
+	failTo   string
+	batches  [][]string
+	singles  []string
+}
+
+func (f *fakeBatchSender) Send(ctx context.Context, msg Message) error {
+	f.singles = append(f.singles, msg.To)
+	if msg.To == f.failTo {
+		return errors.New("rejected")
+	}
+	return nil
+}
+
+func (f *fakeBatchSender) SendBatch(ctx context.Context, msgs []Message) error {
+	var to []string
+	for _, msg := range msgs {
+		to = append(to, msg.To)
+	}
+	f.batches = append(f.batches, to)
+	return f.batchErr
+}
+
+func (f *fakeBatchSender) MaxBatchSize() int { return f.max }
+
+func (f *fakeBatchSender) MaxBatchBytes() int { return f.maxBytes }
+
+func messages(tags ...string) []Message {
+	var msgs []Message
+	for i, tag := range tags {
+		to := string(rune('a'+i)) + "@example.com"
+		msgs = append(msgs, Message{To: to, Headers: map[string]string{"X-Mailin-custom": tag}, Tags: []string{tag}})
+	}
+	return msgs
+}
+
+func TestSendAllBatches(t *testing.T) {
+	tests := []struct {
+		name    string
+		max     int
+		tags    []string
+		batches []int // sizes
+		singles int
+	}{
+		{"one campaign", 10, []string{"c1", "c1", "c1"}, []int{3}, 0},
+		{"split by size", 2, []string{"c1", "c1", "c1", "c1", "c1"}, []int{2, 2}, 1},
+		{"split by tags", 10, []string{"c1", "c1", "c2", "c2", "c2"}, []int{2, 3}, 0},
+		{"lone message", 10, []string{"c1", "c2", "c2"}, []int{2}, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := &fakeBatchSender{max: tt.max}
+			for i, err := range SendAll(context.Background(), f, messages(tt.tags...)) {
+				if err != nil {
+					t.Errorf("message %d: %v", i, err)
+				}
+			}
+			var sizes []int
+			for _, b := range f.batches {
+				sizes = append(sizes, len(b))
+			}
+			if len(sizes) != len(tt.batches) {
+				t.Fatalf("batches %v, want sizes %v", sizes, tt.batches)
+			}
+			for i := range sizes {
+				if sizes[i] != tt.batches[i] {
+					t.Errorf("batches %v, want sizes %v", sizes, tt.batches)
+				}
+			}
+			if len(f.singles) != tt.singles {
+				t.Errorf("%d single sends, want %d", len(f.singles), tt.singles)
+			}
+		})
+	}
+}
+
+func TestSendAllSplitsByBytes(t *testing.T) {
+	msgs := messages("c1", "c1", "c1", "c1", "c1")
+	for i := range msgs {
+		msgs[i].HTML = strings.Repeat("x", 1000)
+	}
+	f := &fakeBatchSender{max: 10, maxBytes: 2 * encodedSize(msgs[0])}
+	SendAll(context.Background(), f, msgs)
+
+	if len(f.batches) != 2 || len(f.batches[0]) != 2 || len(f.batches[1]) != 2 || len(f.singles) != 1 {
+		t.Errorf("batches %v and %d single sends, want two batches of 2 and one single", f.batches, len(f.singles))
+	}
+}
+
+func TestSendAllFallsBackToSingleSends(t *testing.T) {
+	f := &fakeBatchSender{max: 10, batchErr: &SendError{Err: errors.New("invalid address")}, failTo: "b@example.com"}
+	errs := SendAll(context.Background(), f, messages("c1", "c1", "c1"))
+
+	if len(f.batches) != 1 || len(f.singles) != 3 {
+		t.Fatalf("%d batches and %d single sends, want 1 and 3", len(f.batches), len(f.singles))
+	}
+	if errs[0] != nil || errs[1] == nil || errs[2] != nil {
+		t.Errorf("errors = %v, want only the second message to fail", errs)
+	}
+}
@@ -0,0 +1,55 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+)
+
+// FileSender writes every message as an .eml file into a maildir-style
+// directory (tmp/ then renamed into new/) instead of delivering it. It is
+// meant for local development and tests.
+type FileSender struct {
+	dir    string
+	sender Sender
+	seq    atomic.Uint64
+}
+
+func NewFileSender(dir string, sender Sender) (*FileSender, error) {
+	for _, sub := range []string{"tmp", "new", "cur"} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0o755); err != nil {
+			return nil, fmt.Errorf("failed to create mail sink: %w", err)
+		}
+	}
+	if sender.Email == "" {
+		sender.Email = "newsletter@localhost"
+	}
+	return &FileSender{dir: dir, sender: sender}, nil
+}
+
+func (f *FileSender) Send(ctx context.Context, msg Message) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	raw, err := buildMIME(f.sender, msg)
+	if err != nil {
+		return fmt.Errorf("failed to build message: %w", err)
+	}
+
+	host, _ := os.Hostname()
+	name := fmt.Sprintf("%d.%d_%d.%s.eml", time.Now().UnixNano(), os.Getpid(), f.seq.Add(1), host)
+
+	tmp := filepath.Join(f.dir, "tmp", name)
+	if err := os.WriteFile(tmp, raw, 0o644); err != nil {
+		return fmt.Errorf("failed to write message: %w", err)
+	}
+	if err := os.Rename(tmp, filepath.Join(f.dir, "new", name)); err != nil {
+		return fmt.Errorf("failed to deliver message to sink: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,105 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// FrontMatter is the metadata block at the top of an issue written as a
+// single Markdown file, either YAML between "---" lines or TOML between
+// "+++" lines.
+type FrontMatter struct {
+	Subject     string       `yaml:"subject" toml:"subject"`
+	Preheader   string       `yaml:"preheader" toml:"preheader"`
+	Theme       string       `yaml:"theme" toml:"theme"`
+	List        string       `yaml:"list" toml:"list"`
+	Segment     string       `yaml:"segment" toml:"segment"`
+	ScheduledAt ScheduleTime `yaml:"scheduled_at" toml:"scheduled_at"`
+	TimeZone    string       `yaml:"time_zone" toml:"time_zone"`
+	Tags        []string     `yaml:"tags" toml:"tags"`
+	UTMCampaign string       `yaml:"utm_campaign" toml:"utm_campaign"`
+	TrustedHTML bool         `yaml:"trusted_html" toml:"trusted_html"`
+}
+
+// ScheduleTime is a scheduled time as written in front matter. TOML
+// date-times are converted back to text so both formats can be read like
+// ScheduleRequest.ScheduledAt.
+type ScheduleTime string
+
+// UnmarshalTOML implements toml.Unmarshaler.
+func (s *ScheduleTime) UnmarshalTOML(v any) error {
+	switch v := v.(type) {
+	case string:
+		*s = ScheduleTime(v)
+	case time.Time:
+		if v.Location().String() == "datetime-local" {
+			*s = ScheduleTime(v.Format("2006-01-02T15:04:05"))
+		} else {
+			*s = ScheduleTime(v.Format(time.RFC3339))
+		}
+	default:
+		return fmt.Errorf("scheduled_at must be a date-time, not %T", v)
+	}
+	return nil
+}
+
+// SplitFrontMatter separates the front matter of md from its body. Without
+// front matter it returns a zero FrontMatter and md unchanged. Unknown keys
+// are an error so that typos are not silently ignored.
+func SplitFrontMatter(md string) (FrontMatter, string, error) {
+	var fm FrontMatter
+	md = strings.TrimPrefix(md, "\ufeff")
+	delim := firstLine(md)
+	if delim != "---" && delim != "+++" {
+		return fm, md, nil
+	}
+
+	rest := md[len(firstLineRaw(md)):]
+	var block, body string
+	for off := 0; ; {
+		line := firstLineRaw(rest[off:])
+		if line == "" {
+			return fm, md, fmt.Errorf("front matter is not closed with %s", delim)
+		}
+		if strings.TrimRight(line, " \t\r\n") == delim {
+			block, body = rest[:off], rest[off+len(line):]
+			break
+		}
+		off += len(line)
+	}
+
+	if delim == "---" {
+		dec := yaml.NewDecoder(strings.NewReader(block))
+		dec.KnownFields(true)
+		if err := dec.Decode(&fm); err != nil && !errors.Is(err, io.EOF) {
+			return fm, md, fmt.Errorf("front matter: %w", err)
+		}
+	} else {
+		meta, err := toml.NewDecoder(strings.NewReader(block)).Decode(&fm)
+		if err != nil {
+			return fm, md, fmt.Errorf("front matter: %w", err)
+		}
+		if undecoded := meta.Undecoded(); len(undecoded) > 0 {
+			return fm, md, fmt.Errorf("front matter: unknown key %s", undecoded[0])
+		}
+	}
+	return fm, strings.TrimLeft(body, "\r\n"), nil
+}
+
+// firstLineRaw returns the first line of s including its line break.
+func firstLineRaw(s string) string {
+	if i := strings.IndexByte(s, '\n'); i >= 0 {
+		return s[:i+1]
+	}
+	return s
+}
+
+func firstLine(s string) string {
+	return strings.TrimRight(firstLineRaw(s), " \t\r\n")
+}
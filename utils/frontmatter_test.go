@@ -0,0 +1,114 @@
+package utils
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSplitFrontMatter(t *testing.T) {
+	want := FrontMatter{
+		Subject:     "Issue #42",
+		Preheader:   "What's new",
+		Theme:       "light",
+		List:        "weekly",
+		ScheduledAt: "2026-03-01T09:00:00",
+		TimeZone:    "Europe/Berlin",
+		Tags:        []string{"launch", "q1"},
+		UTMCampaign: "issue-42",
+		TrustedHTML: true,
+	}
+	tests := []struct {
+		name, md string
+		want     FrontMatter
+	}{
+		{"yaml", `---
+subject: "Issue #42"
+preheader: What's new
+theme: light
+list: weekly
+scheduled_at: 2026-03-01T09:00:00   # local to time_zone
+time_zone: Europe/Berlin
+tags: [launch, q1]
+utm_campaign: issue-42
+trusted_html: true
+---
+
+# Hello
+`, want},
+		{"toml", `+++
+subject = "Issue #42"
+preheader = "What's new"
+theme = "light"
+list = "weekly"
+scheduled_at = 2026-03-01T09:00:00
+time_zone = "Europe/Berlin"
+tags = ["launch", "q1"]
+utm_campaign = "issue-42"
+trusted_html = true
++++
+
+# Hello
+`, want},
+		{"toml offset date-time", "+++\nscheduled_at = 2026-03-01T09:00:00+01:00\n+++\n# Hello\n",
+			FrontMatter{ScheduledAt: "2026-03-01T09:00:00+01:00"}},
+		{"toml string date-time", "+++\nscheduled_at = \"2026-03-01T09:00\"\n+++\n# Hello\n",
+			FrontMatter{ScheduledAt: "2026-03-01T09:00"}},
+		{"byte order mark and CRLF", "\ufeff---\r\nsubject: Hi\r\n---\r\n# Hello\n", FrontMatter{Subject: "Hi"}},
+		{"empty block", "---\n---\n# Hello\n", FrontMatter{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fm, body, err := SplitFrontMatter(tt.md)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(fm, tt.want) {
+				t.Errorf("front matter = %+v, want %+v", fm, tt.want)
+			}
+			if body != "# Hello\n" {
+				t.Errorf("body = %q, want %q", body, "# Hello\n")
+			}
+		})
+	}
+}
+
+func TestSplitFrontMatterWithout(t *testing.T) {
+	for _, md := range []string{
+		"# Hello\n",
+		"",
+		// A thematic break further down is not front matter.
+		"Intro\n\n---\n\nMore\n",
+		"----\ntitle\n----\n",
+	} {
+		fm, body, err := SplitFrontMatter(md)
+		if err != nil || !reflect.DeepEqual(fm, FrontMatter{}) || body != md {
+			t.Errorf("SplitFrontMatter(%q) = %+v, %q, %v; want it unchanged", md, fm, body, err)
+		}
+	}
+}
+
+func TestSplitFrontMatterErrors(t *testing.T) {
+	tests := []struct {
+		name, md, want string
+	}{
+		{"unclosed yaml", "---\nsubject: Hi\n# Hello\n", "not closed with ---"},
+		{"unclosed toml", "+++\nsubject = \"Hi\"\n---\n", "not closed with +++"},
+		{"unknown yaml key", "---\nsubjcet: Hi\n---\n", "subjcet"},
+		{"unknown toml key", "+++\nsubjcet = \"Hi\"\n+++\n", "unknown key subjcet"},
+		{"invalid yaml", "---\ntags: [a\n---\n", "front matter"},
+		{"invalid toml", "+++\nsubject = Hi\n+++\n", "front matter"},
+		{"toml scheduled_at number", "+++\nscheduled_at = 5\n+++\n", "scheduled_at must be a date-time"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, body, err := SplitFrontMatter(tt.md)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("error = %v, want it to mention %q", err, tt.want)
+			}
+			if body != tt.md {
+				t.Errorf("body = %q, want the input back", body)
+			}
+		})
+	}
+}
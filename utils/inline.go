@@ -0,0 +1,265 @@
+package utils
+
+import (
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/andybalholm/cascadia"
+	"golang.org/x/net/html"
+)
+
+var (
+	cssCommentPattern = regexp.MustCompile(`(?s)/\*.*?\*/`)
+	// dynamicPattern matches selectors that depend on interaction or
+	// generate content, which a style attribute cannot express.
+	dynamicPattern = regexp.MustCompile(`(?i)::|:(hover|active|focus|focus-within|focus-visible|visited|target|link)\b`)
+)
+
+// cssRule is one selector of a style rule with its declarations.
+type cssRule struct {
+	selector     cascadia.Sel
+	declarations []cssDeclaration
+	order        int
+}
+
+type cssDeclaration struct {
+	property  string
+	value     string
+	important bool
+}
+
+// InlineCSS copies the rules of the document's <style> blocks into the
+// style attribute of every element they match, because Gmail's apps and
+// Outlook ignore or strip stylesheets. At-rules such as @media, and rules
+// that only apply on interaction, stay in a <style> block; everything
+// else is removed from it. Declarations already in a style attribute win
+// over the stylesheet unless the stylesheet marks them !important.
+//
+// Merge tags survive unchanged. If the document cannot be parsed it is
+// returned as is.
+func InlineCSS(document string) string {
+	var g MergeTagGuard
+	doc, err := html.Parse(strings.NewReader(g.Protect(document)))
+	if err != nil {
+		return document
+	}
+
+	var rules []cssRule
+	var styles []*html.Node
+	for n := range doc.Descendants() {
+		if n.Type != html.ElementNode || n.Data != "style" || n.FirstChild == nil {
+			continue
+		}
+		kept := parseStylesheet(n.FirstChild.Data, &rules)
+		n.FirstChild.Data = kept
+		styles = append(styles, n)
+	}
+	if len(rules) == 0 {
+		return document
+	}
+	for _, n := range styles {
+		if strings.TrimSpace(n.FirstChild.Data) == "" {
+			n.Parent.RemoveChild(n)
+		} else {
+			n.FirstChild.Data = "\n" + n.FirstChild.Data + "\n  "
+		}
+	}
+
+	for n := range doc.Descendants() {
+		if n.Type == html.ElementNode {
+			applyRules(n, rules)
+		}
+	}
+
+	var out strings.Builder
+	if err := html.Render(&out, doc); err != nil {
+		return document
+	}
+	return g.Restore(out.String())
+}
+
+// parseStylesheet appends the inlinable rules of css to rules and returns
+// the CSS that has to stay in the stylesheet.
+func parseStylesheet(css string, rules *[]cssRule) string {
+	css = cssCommentPattern.ReplaceAllString(css, "")
+	var kept []string
+	for {
+		css = strings.TrimSpace(css)
+		open := strings.IndexByte(css, '{')
+		if open < 0 {
+			break
+		}
+		prelude := strings.TrimSpace(css[:open])
+		end := matchingBrace(css, open)
+		if end < 0 {
+			// Unbalanced; leave the rest to the client.
+			kept = append(kept, css)
+			break
+		}
+		block := css[open+1 : end]
+		rule := css[:end+1]
+		css = css[end+1:]
+
+		if strings.HasPrefix(prelude, "@") {
+			kept = append(kept, "    "+rule)
+			continue
+		}
+
+		declarations := parseDeclarations(block)
+		var dynamic []string
+		for _, s := range strings.Split(prelude, ",") {
+			s = strings.TrimSpace(s)
+			if s == "" {
+				continue
+			}
+			sel, err := cascadia.Parse(s)
+			if err != nil || dynamicPattern.MatchString(s) {
+				dynamic = append(dynamic, s)
+				continue
+			}
+			*rules = append(*rules, cssRule{selector: sel, declarations: declarations, order: len(*rules)})
+		}
+		if len(dynamic) > 0 {
+			kept = append(kept, "    "+strings.Join(dynamic, ", ")+" {"+block+"}")
+		}
+	}
+	return strings.Join(kept, "\n")
+}
+
+// matchingBrace returns the index of the brace closing the one at open,
+// or -1.
+func matchingBrace(s string, open int) int {
+	depth := 0
+	for i := open; i < len(s); i++ {
+		switch s[i] {
+		case '{':
+			depth++
+		case '}':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+func parseDeclarations(block string) []cssDeclaration {
+	var out []cssDeclaration
+	for _, d := range splitDeclarations(block) {
+		property, value, ok := strings.Cut(d, ":")
+		property, value = strings.ToLower(strings.TrimSpace(property)), strings.TrimSpace(value)
+		if !ok || property == "" || value == "" {
+			continue
+		}
+		important := false
+		if v, ok := strings.CutSuffix(value, "!important"); ok {
+			value, important = strings.TrimSpace(v), true
+		}
+		out = append(out, cssDeclaration{property: property, value: value, important: important})
+	}
+	return out
+}
+
+// splitDeclarations splits a declaration block at the semicolons that end
+// declarations, skipping those inside quotes or parentheses such as in
+// url(data:image/png;base64,...).
+func splitDeclarations(block string) []string {
+	var parts []string
+	var quote byte
+	depth, start := 0, 0
+	for i := 0; i < len(block); i++ {
+		switch c := block[i]; {
+		case quote != 0:
+			if c == '\\' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '(':
+			depth++
+		case c == ')' && depth > 0:
+			depth--
+		case c == ';' && depth == 0:
+			parts = append(parts, block[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, block[start:])
+}
+
+// applyRules sets the style attribute of n to the declarations that apply
+// to it, in cascade order: stylesheet rules by specificity and position,
+// then the element's own style, then !important stylesheet declarations.
+func applyRules(n *html.Node, rules []cssRule) {
+	var matched []cssRule
+	for _, r := range rules {
+		if r.selector.Match(n) {
+			matched = append(matched, r)
+		}
+	}
+	if len(matched) == 0 {
+		return
+	}
+	slices.SortStableFunc(matched, func(a, b cssRule) int {
+		as, bs := a.selector.Specificity(), b.selector.Specificity()
+		switch {
+		case as.Less(bs):
+			return -1
+		case bs.Less(as):
+			return 1
+		}
+		return a.order - b.order
+	})
+
+	var names []string
+	values := map[string]string{}
+	set := func(d cssDeclaration) {
+		if _, ok := values[d.property]; !ok {
+			names = append(names, d.property)
+		}
+		v := d.value
+		if d.important {
+			v += " !important"
+		}
+		values[d.property] = v
+	}
+
+	for _, r := range matched {
+		for _, d := range r.declarations {
+			if !d.important {
+				set(d)
+			}
+		}
+	}
+	styleIndex := -1
+	for i, a := range n.Attr {
+		if a.Key == "style" {
+			styleIndex = i
+			for _, d := range parseDeclarations(a.Val) {
+				set(d)
+			}
+		}
+	}
+	for _, r := range matched {
+		for _, d := range r.declarations {
+			if d.important {
+				set(d)
+			}
+		}
+	}
+
+	parts := make([]string, len(names))
+	for i, name := range names {
+		parts[i] = name + ": " + values[name]
+	}
+	style := strings.Join(parts, "; ") + ";"
+	if styleIndex >= 0 {
+		n.Attr[styleIndex].Val = style
+	} else {
+		n.Attr = append(n.Attr, html.Attribute{Key: "style", Val: style})
+	}
+}
@@ -0,0 +1,145 @@
+package utils_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/pixperk/newsletter/templates"
+	"github.com/pixperk/newsletter/utils"
+	"golang.org/x/net/html"
+)
+
+// render runs a Markdown body through the pipeline used for campaigns:
+// Markdown with merge tags, the default theme, then CSS inlining.
+func render(t *testing.T, md string) string {
+	t.Helper()
+	reg, err := templates.Load("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc, err := reg.Default().Newsletter("Issue 1", "", utils.MarkdownTemplateToHTML(md))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return utils.InlineCSS(doc)
+}
+
+// find returns the first element called tag inside the content cell.
+func find(t *testing.T, document, tag string) *html.Node {
+	t.Helper()
+	doc, err := html.Parse(strings.NewReader(document))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var wrapper *html.Node
+	for n := range doc.Descendants() {
+		if n.Type == html.ElementNode && strings.Contains(" "+attr(n, "class")+" ", " wrapper ") {
+			wrapper = n
+			break
+		}
+	}
+	if wrapper == nil {
+		t.Fatal("no content cell in document")
+	}
+	for n := range wrapper.Descendants() {
+		if n.Type == html.ElementNode && n.Data == tag {
+			return n
+		}
+	}
+	t.Fatalf("no <%s> in content", tag)
+	return nil
+}
+
+func attr(n *html.Node, key string) string {
+	for _, a := range n.Attr {
+		if a.Key == key {
+			return a.Val
+		}
+	}
+	return ""
+}
+
+func TestInlineCSSElements(t *testing.T) {
+	tests := []struct {
+		name string
+		md   string
+		tag  string
+		want []string
+	}{
+		{"heading", "# Title", "h1", []string{"font-size: 26px", "font-weight: 600", "color: #ececec"}},
+		{"paragraph", "Some text.", "p", []string{"font-size: 15px", "color: #9a9a9a", "line-height: 1.75"}},
+		{"code block", "```\nfmt.Println()\n```", "pre", []string{"padding: 18px", "border-radius: 10px", "overflow-x: auto"}},
+		{"inline code", "Run `go test`.", "code", []string{"font-family: 'SF Mono'", "background: rgba(255,255,255,0.06)", "padding: 2px 7px"}},
+		{"code in block", "```\nx\n```", "code", []string{"font-family: 'SF Mono'", "font-size: 13.5px"}},
+		{"blockquote", "> Quoted", "blockquote", []string{"border-left: 2px solid rgba(255,255,255,0.15)", "font-style: italic"}},
+		{"link", "[site](https://example.com)", "a", []string{"color: #ccc", "text-decoration: none"}},
+		{"image", "![alt](https://example.com/a.png)", "img", []string{"max-width: 100%", "height: auto", "border-radius: 8px"}},
+		{"table", "| a | b |\n|---|---|\n| 1 | 2 |", "table", []string{"border-collapse: collapse", "width: 100%"}},
+		{"table header", "| a | b |\n|---|---|\n| 1 | 2 |", "th", []string{"padding: 8px 12px", "font-weight: 600", "color: #d4d4d4"}},
+		{"table cell", "| a | b |\n|---|---|\n| 1 | 2 |", "td", []string{"padding: 8px 12px", "color: #9a9a9a"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			style := attr(find(t, render(t, tt.md), tt.tag), "style")
+			for _, want := range tt.want {
+				if !strings.Contains(style, want) {
+					t.Errorf("style of <%s> = %q, want %q", tt.tag, style, want)
+				}
+			}
+		})
+	}
+}
+
+func TestInlineCSSKeepsOwnStyle(t *testing.T) {
+	// Chroma sets the colors of highlighted code blocks itself; the theme
+	// must not override them.
+	out := render(t, "```go\nfunc main() {}\n```")
+	style := attr(find(t, out, "pre"), "style")
+	theme, own := strings.Index(style, "background: #0a0a0a"), strings.Index(style, "background-color: #272822")
+	if own < 0 || own < theme {
+		t.Errorf("style of highlighted <pre> = %q, want chroma's background to win", style)
+	}
+}
+
+func TestInlineCSSKeepsMediaQueries(t *testing.T) {
+	out := render(t, "# Title\n\nText")
+	start, end := strings.Index(out, "<style"), strings.Index(out, "</style>")
+	if start < 0 || end < start {
+		t.Fatal("no <style> block left")
+	}
+	sheet := out[start:end]
+	for _, want := range []string{"@media only screen and (max-width: 640px)", ".container { padding: 8px !important", "@media all"} {
+		if !strings.Contains(sheet, want) {
+			t.Errorf("stylesheet lost %q:\n%s", want, sheet)
+		}
+	}
+	if strings.Contains(sheet, ".wrapper h1 {") || strings.Contains(sheet, ".wrapper p {") {
+		t.Errorf("inlined rules left in stylesheet:\n%s", sheet)
+	}
+}
+
+func TestInlineCSSMergeTags(t *testing.T) {
+	out := render(t, `Hi {{first_name | default "there"}}, [manage]({{unsubscribe_url}})`)
+	for _, want := range []string{`{{first_name | default "there"}}`, `href="{{unsubscribe_url}}"`} {
+		if !strings.Contains(out, want) {
+			t.Errorf("merge tag %s was changed", want)
+		}
+	}
+}
+
+func TestInlineCSSDeclarationsWithSemicolons(t *testing.T) {
+	doc := `<html><head><style>p { color: red; background: url("data:image/png;base64,AAAA") no-repeat; }</style></head>` +
+		`<body><p style="font-family: 'a;b', serif; background-image: url(data:image/gif;base64,R0lG)">x</p></body></html>`
+	n := find(t, strings.Replace(utils.InlineCSS(doc), "<body>", `<body><div class="wrapper">`, 1), "p")
+	style := attr(n, "style")
+	for _, want := range []string{
+		"color: red",
+		`background: url("data:image/png;base64,AAAA") no-repeat`,
+		"font-family: 'a;b', serif",
+		"background-image: url(data:image/gif;base64,R0lG)",
+	} {
+		if !strings.Contains(style, want) {
+			t.Errorf("style = %q, want %q", style, want)
+		}
+	}
+}
@@ -0,0 +1,353 @@
+package utils
+
+import (
+	"bytes"
+	"fmt"
+	htmltemplate "html/template"
+	"regexp"
+	"slices"
+	"strings"
+	texttemplate "text/template"
+	"text/template/parse"
+	"time"
+
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/text"
+)
+
+// MergeVariables are the merge tags every recipient has. Custom
+// attributes are available under their own names as well.
+var MergeVariables = []string{"email", "first_name", "last_name", "name", "unsubscribe_url", "subscribed_since"}
+
+// mergeHelpers are the functions available to merge tags besides the
+// text/template builtins.
+var mergeHelpers = []string{"default", "upper", "lower"}
+
+var templateBuiltins = []string{
+	"and", "call", "html", "index", "slice", "js", "len", "not", "or",
+	"print", "printf", "println", "urlquery", "eq", "ge", "gt", "le", "lt", "ne",
+}
+
+// MergeTemplate is a newsletter whose subject, HTML and plain-text
+// alternative contain merge tags such as {{first_name | default "there"}}.
+// The subject and text are rendered as plain text and the HTML with
+// html/template, so subscriber data is escaped for the context it lands
+// in.
+type MergeTemplate struct {
+	subject    *texttemplate.Template
+	html       *htmltemplate.Template
+	text       *texttemplate.Template
+	attributes []string
+}
+
+// ParseMergeTemplate parses a subject, an HTML body and an optional
+// plain-text body. Identifiers that are neither built-in variables nor
+// helpers are treated as custom attributes; see Attributes. Use Validate
+// before accepting a template.
+//
+// Every "{{" outside Markdown code starts a merge tag; write {{"{{"}} for
+// a literal one. html/template drops HTML comments from the body,
+// Outlook conditional comments included.
+func ParseMergeTemplate(subject, htmlBody, textBody string) (*MergeTemplate, error) {
+	var attrs []string
+	for _, part := range []struct{ name, text string }{{"subject", subject}, {"body", htmlBody}, {"text", textBody}} {
+		partAttrs, err := templateAttributes(part.name, part.text)
+		if err != nil {
+			return nil, err
+		}
+		for _, a := range partAttrs {
+			if !slices.Contains(attrs, a) {
+				attrs = append(attrs, a)
+			}
+		}
+	}
+	slices.Sort(attrs)
+
+	t := &MergeTemplate{attributes: attrs}
+	funcs := mergeFuncs(Contact{}, attrs)
+	var err error
+	if t.subject, err = texttemplate.New("subject").Funcs(texttemplate.FuncMap(funcs)).Parse(subject); err != nil {
+		return nil, err
+	}
+	if t.html, err = htmltemplate.New("body").Funcs(funcs).Parse(htmlBody); err != nil {
+		return nil, err
+	}
+	if t.text, err = texttemplate.New("text").Funcs(texttemplate.FuncMap(funcs)).Parse(textBody); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// Attributes returns the custom attribute names the template uses.
+func (t *MergeTemplate) Attributes() []string {
+	return t.attributes
+}
+
+// Validate rejects templates that use attributes outside known, then
+// renders once for an empty contact so other mistakes surface before
+// anything is sent.
+func (t *MergeTemplate) Validate(known []string) error {
+	var unknown []string
+	for _, a := range t.attributes {
+		if !slices.Contains(known, a) {
+			unknown = append(unknown, a)
+		}
+	}
+	if len(unknown) > 0 {
+		return fmt.Errorf("unknown merge tags: %s", strings.Join(unknown, ", "))
+	}
+	_, _, _, err := t.Render(Contact{})
+	return err
+}
+
+// Render personalizes the template for c. It is safe for concurrent use.
+func (t *MergeTemplate) Render(c Contact) (subject, html, text string, err error) {
+	funcs := mergeFuncs(c, t.attributes)
+
+	st, err := t.subject.Clone()
+	if err != nil {
+		return "", "", "", err
+	}
+	var buf bytes.Buffer
+	if err := st.Funcs(texttemplate.FuncMap(funcs)).Execute(&buf, noData{}); err != nil {
+		return "", "", "", err
+	}
+	// Header values must stay on one line.
+	subject = strings.Join(strings.Fields(buf.String()), " ")
+
+	ht, err := t.html.Clone()
+	if err != nil {
+		return "", "", "", err
+	}
+	buf.Reset()
+	if err := ht.Funcs(funcs).Execute(&buf, noData{}); err != nil {
+		return "", "", "", err
+	}
+	html = buf.String()
+
+	tt, err := t.text.Clone()
+	if err != nil {
+		return "", "", "", err
+	}
+	buf.Reset()
+	if err := tt.Funcs(texttemplate.FuncMap(funcs)).Execute(&buf, noData{}); err != nil {
+		return "", "", "", err
+	}
+	return subject, html, buf.String(), nil
+}
+
+// noData is the template's dot. Merge tags are functions, and an empty
+// struct makes field references like {{.Name}} fail instead of printing
+// nothing.
+type noData struct{}
+
+func mergeFuncs(c Contact, attributes []string) htmltemplate.FuncMap {
+	funcs := htmltemplate.FuncMap{
+		"email":            func() string { return c.Email },
+		"first_name":       func() string { return c.FirstName },
+		"last_name":        func() string { return c.LastName },
+		"name":             func() string { return c.Name() },
+		"unsubscribe_url":  func() string { return unsubscribeURLFor(c.Email) },
+		"subscribed_since": func() string { return formatDate(c.SubscribedAt) },
+		"default": func(fallback string, v any) any {
+			if v == nil || fmt.Sprint(v) == "" {
+				return fallback
+			}
+			return v
+		},
+		"upper": strings.ToUpper,
+		"lower": strings.ToLower,
+	}
+	for _, name := range attributes {
+		funcs[name] = func() any {
+			if v, ok := c.Attributes[name]; ok && v != nil {
+				return v
+			}
+			return ""
+		}
+	}
+	return funcs
+}
+
+func unsubscribeURLFor(email string) string {
+	if email == "" {
+		return PublicURL() + "/unsubscribe"
+	}
+	return UnsubscribeURL(email)
+}
+
+func formatDate(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format("January 2, 2006")
+}
+
+// templateAttributes lists the identifiers text uses that are not
+// built-in variables, helpers or template builtins.
+func templateAttributes(name, text string) ([]string, error) {
+	// Skipping the function check is what lets unknown names through to
+	// be reported as attributes instead of failing the parse.
+	tree := parse.New(name)
+	tree.Mode = parse.SkipFuncCheck
+	trees := map[string]*parse.Tree{}
+	if _, err := tree.Parse(text, "{{", "}}", trees); err != nil {
+		return nil, err
+	}
+	var attrs []string
+	for _, tree := range trees {
+		if tree.Root == nil {
+			continue
+		}
+		walkIdentifiers(tree.Root, func(ident string) {
+			if slices.Contains(MergeVariables, ident) || slices.Contains(mergeHelpers, ident) ||
+				slices.Contains(templateBuiltins, ident) || slices.Contains(attrs, ident) {
+				return
+			}
+			attrs = append(attrs, ident)
+		})
+	}
+	return attrs, nil
+}
+
+func walkIdentifiers(node parse.Node, visit func(string)) {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return
+		}
+		for _, child := range n.Nodes {
+			walkIdentifiers(child, visit)
+		}
+	case *parse.ActionNode:
+		walkIdentifiers(n.Pipe, visit)
+	case *parse.PipeNode:
+		if n == nil {
+			return
+		}
+		for _, cmd := range n.Cmds {
+			walkIdentifiers(cmd, visit)
+		}
+	case *parse.CommandNode:
+		for _, arg := range n.Args {
+			walkIdentifiers(arg, visit)
+		}
+	case *parse.IdentifierNode:
+		visit(n.Ident)
+	case *parse.ChainNode:
+		walkIdentifiers(n.Node, visit)
+	case *parse.IfNode:
+		walkBranch(&n.BranchNode, visit)
+	case *parse.RangeNode:
+		walkBranch(&n.BranchNode, visit)
+	case *parse.WithNode:
+		walkBranch(&n.BranchNode, visit)
+	case *parse.TemplateNode:
+		walkIdentifiers(n.Pipe, visit)
+	}
+}
+
+func walkBranch(b *parse.BranchNode, visit func(string)) {
+	walkIdentifiers(b.Pipe, visit)
+	walkIdentifiers(b.List, visit)
+	walkIdentifiers(b.ElseList, visit)
+}
+
+var mergeTagPattern = regexp.MustCompile(`(?s)\{\{.*?\}\}`)
+
+// MarkdownTemplateToHTML converts Markdown that contains merge tags. The
+// tags are set aside during conversion so Markdown neither escapes their
+// quotes nor formats their contents. Braces inside code spans and code
+// blocks are not merge tags; see literalCodeBraces.
+func MarkdownTemplateToHTML(md string) string {
+	var g MergeTagGuard
+	md = literalCodeBraces(md)
+	return restoreCodeBraces(g.Restore(MarkdownToHTML(g.Protect(md))))
+}
+
+// codeBracesPlaceholder stands in for "{{" inside Markdown code until the
+// Markdown is converted. It is a plain word so neither Markdown nor the
+// syntax highlighter changes it.
+const codeBracesPlaceholder = "MERGEBRACESMERGE"
+
+// literalCodeBraces replaces the "{{" of code spans and code blocks in md
+// with a placeholder, so a code sample showing Go, Handlebars or Jinja
+// templates is neither taken for merge tags nor fails the send.
+// restoreCodeBraces turns the placeholders into {{"{{"}}, which the
+// template pass prints as a literal "{{".
+func literalCodeBraces(md string) string {
+	source := []byte(md)
+	var code []text.Segment
+	ast.Walk(textParser.Parse(text.NewReader(source)), func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if !entering {
+			return ast.WalkContinue, nil
+		}
+		switch n := n.(type) {
+		case *ast.CodeSpan:
+			for c := n.FirstChild(); c != nil; c = c.NextSibling() {
+				if t, ok := c.(*ast.Text); ok {
+					code = append(code, t.Segment)
+				}
+			}
+			return ast.WalkSkipChildren, nil
+		case *ast.CodeBlock, *ast.FencedCodeBlock:
+			lines := n.Lines()
+			for i := 0; i < lines.Len(); i++ {
+				code = append(code, lines.At(i))
+			}
+			return ast.WalkSkipChildren, nil
+		}
+		return ast.WalkContinue, nil
+	})
+	if len(code) == 0 {
+		return md
+	}
+
+	var b strings.Builder
+	last := 0
+	for _, seg := range code {
+		if seg.Start < last {
+			continue
+		}
+		b.Write(source[last:seg.Start])
+		b.WriteString(strings.ReplaceAll(string(seg.Value(source)), "{{", codeBracesPlaceholder))
+		last = seg.Stop
+	}
+	b.Write(source[last:])
+	return b.String()
+}
+
+func restoreCodeBraces(s string) string {
+	return strings.ReplaceAll(s, codeBracesPlaceholder, `{{"{{"}}`)
+}
+
+// MergeTagGuard sets merge tags aside while text passes through something
+// that would escape or reformat them, such as Markdown conversion or HTML
+// parsing. The zero value is ready to use.
+type MergeTagGuard struct {
+	tags []string
+}
+
+// Protect replaces the merge tags in s with plain placeholders.
+func (g *MergeTagGuard) Protect(s string) string {
+	return mergeTagPattern.ReplaceAllStringFunc(s, func(tag string) string {
+		g.tags = append(g.tags, tag)
+		return mergeTagPlaceholder(len(g.tags) - 1)
+	})
+}
+
+// Restore puts back the merge tags of every string protected so far.
+func (g *MergeTagGuard) Restore(s string) string {
+	if len(g.tags) == 0 {
+		return s
+	}
+	pairs := make([]string, 0, 2*len(g.tags))
+	for i, tag := range g.tags {
+		pairs = append(pairs, mergeTagPlaceholder(i), tag)
+	}
+	return strings.NewReplacer(pairs...).Replace(s)
+}
+
+func mergeTagPlaceholder(i int) string {
+	return fmt.Sprintf("MERGETAG%dMERGETAG", i)
+}
@@ -0,0 +1,138 @@
+package utils
+
+import (
+	"html"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// renderMarkdown runs md through the merge-tag pipeline for both parts
+// and renders it for c. The HTML is reduced to its text.
+func renderMarkdown(t *testing.T, md string, c Contact) (htmlText, text string) {
+	t.Helper()
+	tmpl, err := ParseMergeTemplate("s", MarkdownTemplateToHTML(md), MarkdownTemplateToText(md))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tmpl.Validate(nil); err != nil {
+		t.Fatal(err)
+	}
+	_, body, text, err := tmpl.Render(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return html.UnescapeString(regexp.MustCompile(`<[^>]*>`).ReplaceAllString(body, "")), text
+}
+
+func TestMergeTagsRender(t *testing.T) {
+	md := "Hi {{first_name | default \"there\"}}, this went to **{{email}}**."
+	for _, tt := range []struct {
+		contact Contact
+		want    string
+	}{
+		{Contact{Email: "ada@example.com", FirstName: "Ada"}, "Hi Ada, this went to ada@example.com."},
+		{Contact{Email: "bob@example.com"}, "Hi there, this went to bob@example.com."},
+	} {
+		htmlText, text := renderMarkdown(t, md, tt.contact)
+		if !strings.Contains(htmlText, tt.want) || !strings.Contains(text, tt.want) {
+			t.Errorf("rendered %q and %q, want both to contain %q", htmlText, text, tt.want)
+		}
+	}
+}
+
+func TestMergeTagsUnknownAttribute(t *testing.T) {
+	tmpl, err := ParseMergeTemplate("Hi {{nickname}}", "<p>{{plan | upper}}</p>", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(tmpl.Attributes(), ","); got != "nickname,plan" {
+		t.Errorf("Attributes() = %q, want nickname,plan", got)
+	}
+	if err := tmpl.Validate([]string{"plan"}); err == nil || !strings.Contains(err.Error(), "nickname") {
+		t.Errorf("Validate = %v, want nickname reported", err)
+	}
+	if err := tmpl.Validate([]string{"nickname", "plan"}); err != nil {
+		t.Errorf("Validate with both known = %v", err)
+	}
+}
+
+func TestMergeTagsLeaveCodeAlone(t *testing.T) {
+	md := strings.Join([]string{
+		"Hi {{first_name}}, use `{{ .Name }}` in Go templates:",
+		"",
+		"```go",
+		`{{ range .Items }}{{ printf "%q" . }}{{ end }}`,
+		"```",
+		"",
+		"Handlebars:",
+		"",
+		"    {{#each people}}{{name}}{{/each}}",
+	}, "\n")
+
+	htmlText, text := renderMarkdown(t, md, Contact{Email: "ada@example.com", FirstName: "Ada"})
+	for _, part := range []struct{ name, out string }{{"html", htmlText}, {"text", text}} {
+		for _, want := range []string{
+			"Hi Ada",
+			"{{ .Name }}",
+			`{{ range .Items }}{{ printf "%q" . }}{{ end }}`,
+			"{{#each people}}{{name}}{{/each}}",
+		} {
+			if !strings.Contains(part.out, want) {
+				t.Errorf("%s part %q does not contain %q", part.name, part.out, want)
+			}
+		}
+	}
+}
+
+func TestMergeTagsEscapedBraces(t *testing.T) {
+	htmlText, text := renderMarkdown(t, `Write {{"{{"}}first_name}} to greet {{first_name}}.`, Contact{FirstName: "Ada"})
+	want := "Write {{first_name}} to greet Ada."
+	if !strings.Contains(htmlText, want) || !strings.Contains(text, want) {
+		t.Errorf("rendered %q and %q, want both to contain %q", htmlText, text, want)
+	}
+}
+
+func TestMergeTemplateDropsComments(t *testing.T) {
+	// html/template removes comments, conditional ones included; the
+	// merge-tag documentation says so.
+	tmpl, err := ParseMergeTemplate("s", `<!--[if mso]><table><tr><td><![endif]--><p>{{email}}</p><!-- note -->`, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, body, _, err := tmpl.Render(Contact{Email: "ada@example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body != "<p>ada@example.com</p>" {
+		t.Errorf("rendered %q, want the comments dropped", body)
+	}
+}
+
+func TestMergeTagGuard(t *testing.T) {
+	var g MergeTagGuard
+	in := `<a href="{{unsubscribe_url}}">x</a> {{first_name | default "there"}} {{email}}`
+	protected := g.Protect(in)
+	if strings.Contains(protected, "{{") || strings.Contains(protected, "there") {
+		t.Errorf("Protect(%q) = %q, want the tags replaced", in, protected)
+	}
+	if got := g.Restore(protected); got != in {
+		t.Errorf("Restore = %q, want %q", got, in)
+	}
+
+	// A tag spanning lines is one tag.
+	multi := g.Protect("{{first_name\n| default \"there\"}}")
+	if strings.Contains(multi, "{{") {
+		t.Errorf("Protect of a multi-line tag = %q", multi)
+	}
+
+	// Restore puts back the tags of every string protected so far.
+	if got := g.Restore(protected + multi); got != in+"{{first_name\n| default \"there\"}}" {
+		t.Errorf("Restore of both = %q", got)
+	}
+
+	var empty MergeTagGuard
+	if got := empty.Restore("MERGETAG0MERGETAG"); got != "MERGETAG0MERGETAG" {
+		t.Errorf("zero guard Restore = %q, want input unchanged", got)
+	}
+}
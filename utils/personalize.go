@@ -0,0 +1,52 @@
+package utils
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+)
+
+// Contact is what the templating layer knows about a recipient.
+type Contact struct {
+	Email        string
+	FirstName    string
+	LastName     string
+	SubscribedAt time.Time
+	Attributes   map[string]any
+}
+
+// Name returns the display name for the To header, or "" if unknown.
+func (c Contact) Name() string {
+	return strings.TrimSpace(c.FirstName + " " + c.LastName)
+}
+
+// Params flattens the contact into provider template parameters, named
+// after Brevo's contact attributes: EMAIL, FIRSTNAME, LASTNAME and every
+// custom attribute upper-cased. Non-string attributes are JSON-encoded.
+func (c Contact) Params() map[string]string {
+	params := map[string]string{"EMAIL": c.Email}
+	for key, value := range c.Attributes {
+		params[strings.ToUpper(key)] = attributeString(value)
+	}
+	if c.FirstName != "" {
+		params["FIRSTNAME"] = c.FirstName
+	}
+	if c.LastName != "" {
+		params["LASTNAME"] = c.LastName
+	}
+	return params
+}
+
+func attributeString(v any) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return ""
+	}
+	return string(b)
+}
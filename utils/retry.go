@@ -0,0 +1,147 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// SendError is a classified delivery failure. Retryable errors are worth
+// trying again later (throttling, provider outages, network trouble before
+// the request went out); everything else is permanent for this message.
+type SendError struct {
+	Err        error
+	StatusCode int
+	Retryable  bool
+	RetryAfter time.Duration
+	// Unknown is set when the request reached the provider but no answer
+	// came back. The message may have been accepted, so it must not be
+	// sent again.
+	Unknown bool
+}
+
+func (e *SendError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *SendError) Unwrap() error {
+	return e.Err
+}
+
+// IsRetryable reports whether err is a SendError that may succeed later.
+func IsRetryable(err error) bool {
+	var se *SendError
+	return errors.As(err, &se) && se.Retryable
+}
+
+// OutcomeUnknown reports whether err is a SendError for a request the
+// provider may have accepted.
+func OutcomeUnknown(err error) bool {
+	var se *SendError
+	return errors.As(err, &se) && se.Unknown
+}
+
+// RetryAfter returns the delay requested by the provider, if any.
+func RetryAfter(err error) time.Duration {
+	var se *SendError
+	if errors.As(err, &se) {
+		return se.RetryAfter
+	}
+	return 0
+}
+
+// RetryPolicy bounds exponential backoff with full jitter.
+type RetryPolicy struct {
+	MaxAttempts int
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+}
+
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts: 4,
+	BaseDelay:   500 * time.Millisecond,
+	MaxDelay:    30 * time.Second,
+}
+
+// Backoff returns the wait before retry number attempt (starting at 1).
+// A provider supplied Retry-After wins over the computed delay.
+func (p RetryPolicy) Backoff(attempt int, retryAfter time.Duration) time.Duration {
+	if retryAfter > 0 {
+		return retryAfter
+	}
+	d := p.BaseDelay << (attempt - 1)
+	if d <= 0 || d > p.MaxDelay {
+		d = p.MaxDelay
+	}
+	return d/2 + rand.N(d/2+1)
+}
+
+// Do calls fn until it succeeds, fails permanently, the attempts run out or
+// ctx is done. A Retry-After longer than MaxDelay is not waited out here;
+// the retryable error is returned so the caller can reschedule instead.
+func (p RetryPolicy) Do(ctx context.Context, fn func(context.Context) error) error {
+	var err error
+	for attempt := 1; ; attempt++ {
+		err = fn(ctx)
+		if err == nil || !IsRetryable(err) || attempt >= p.MaxAttempts {
+			return err
+		}
+
+		wait := p.Backoff(attempt, RetryAfter(err))
+		if wait > p.MaxDelay {
+			return err
+		}
+
+		t := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return err
+		case <-t.C:
+		}
+	}
+}
+
+// classifyStatus turns an HTTP error response into a SendError.
+func classifyStatus(resp *http.Response, err error) *SendError {
+	se := &SendError{Err: err, StatusCode: resp.StatusCode}
+	switch {
+	case resp.StatusCode == http.StatusTooManyRequests,
+		resp.StatusCode == http.StatusRequestTimeout,
+		resp.StatusCode >= 500:
+		se.Retryable = true
+		se.RetryAfter = parseRetryAfter(resp.Header.Get("Retry-After"))
+	}
+	return se
+}
+
+// parseRetryAfter understands both forms allowed by RFC 9110: a number of
+// seconds or an HTTP date.
+func parseRetryAfter(v string) time.Duration {
+	if v == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(v); err == nil && secs >= 0 {
+		return time.Duration(secs) * time.Second
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		if d := time.Until(t); d > 0 {
+			return d
+		}
+	}
+	return 0
+}
+
+// networkError classifies a transport failure. Failures before the request
+// was written, such as DNS or dial errors, are retryable unless the caller
+// was cancelled. Once it has been written the provider may have accepted
+// it, so a timeout or reset leaves the outcome unknown and is not retried.
+func networkError(ctx context.Context, written bool, err error) *SendError {
+	if written {
+		return &SendError{Err: err, Unknown: true}
+	}
+	return &SendError{Err: err, Retryable: ctx.Err() == nil}
+}
@@ -0,0 +1,111 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+var fastRetry = RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: 10 * time.Millisecond}
+
+func TestBrevoRetriesServerErrors(t *testing.T) {
+	var hits atomic.Int32
+	b, _ := brevoServer(t, func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})
+	b.retry = fastRetry
+
+	err := b.Send(context.Background(), Message{To: "a@example.com"})
+	if !IsRetryable(err) || OutcomeUnknown(err) {
+		t.Errorf("err = %#v, want retryable with a known outcome", err)
+	}
+	if hits.Load() != 3 {
+		t.Errorf("%d requests, want 3", hits.Load())
+	}
+}
+
+func TestBrevoDoesNotRetryPermanentErrors(t *testing.T) {
+	var hits atomic.Int32
+	b, _ := brevoServer(t, func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"code":"invalid_parameter","message":"email is not valid"}`))
+	})
+	b.retry = fastRetry
+
+	err := b.Send(context.Background(), Message{To: "a@example.com"})
+	if err == nil || IsRetryable(err) {
+		t.Errorf("err = %v, want permanent", err)
+	}
+	if hits.Load() != 1 {
+		t.Errorf("%d requests, want 1", hits.Load())
+	}
+}
+
+func TestBrevoDoesNotResendAfterWriting(t *testing.T) {
+	var hits atomic.Int32
+	release := make(chan struct{})
+	b, _ := brevoServer(t, func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		<-release // accepted, but the answer never arrives in time
+	})
+	defer close(release)
+	b.retry = fastRetry
+	b.client = &http.Client{Timeout: 50 * time.Millisecond}
+
+	err := b.Send(context.Background(), Message{To: "a@example.com"})
+	if !OutcomeUnknown(err) || IsRetryable(err) {
+		t.Errorf("err = %#v, want an unknown outcome that is not retried", err)
+	}
+	if hits.Load() != 1 {
+		t.Errorf("%d requests, want 1", hits.Load())
+	}
+}
+
+func TestBrevoRetriesDialErrors(t *testing.T) {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	srv.Close() // nothing listens any more
+
+	b, err := NewBrevoSender("key", Sender{Email: "news@example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.endpoint = srv.URL
+	b.retry = fastRetry
+
+	err = b.Send(context.Background(), Message{To: "a@example.com"})
+	if !IsRetryable(err) || OutcomeUnknown(err) {
+		t.Errorf("err = %#v, want retryable with a known outcome", err)
+	}
+}
+
+func TestSendAllDoesNotFallBackAfterUnknownOutcome(t *testing.T) {
+	f := &fakeBatchSender{max: 10, batchErr: &SendError{Err: errors.New("timeout"), Unknown: true}}
+	errs := SendAll(context.Background(), f, messages("c1", "c1", "c1"))
+
+	if len(f.singles) != 0 {
+		t.Errorf("%d single sends after an unknown batch outcome, want none", len(f.singles))
+	}
+	for i, err := range errs {
+		if !OutcomeUnknown(err) {
+			t.Errorf("message %d: err = %v, want the batch error", i, err)
+		}
+	}
+}
+
+func TestBackoffHonoursRetryAfter(t *testing.T) {
+	p := RetryPolicy{BaseDelay: time.Second, MaxDelay: 8 * time.Second}
+	if d := p.Backoff(1, 5*time.Second); d != 5*time.Second {
+		t.Errorf("Backoff with Retry-After = %v, want 5s", d)
+	}
+	for attempt := 1; attempt <= 6; attempt++ {
+		if d := p.Backoff(attempt, 0); d <= 0 || d > p.MaxDelay {
+			t.Errorf("Backoff(%d) = %v, want within (0, %v]", attempt, d, p.MaxDelay)
+		}
+	}
+}
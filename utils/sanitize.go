@@ -0,0 +1,227 @@
+package utils
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// allowedTags are the elements email clients render and that cannot run
+// code or submit data.
+var allowedTags = map[string]bool{
+	"a": true, "abbr": true, "b": true, "blockquote": true, "br": true, "caption": true,
+	"center": true, "cite": true, "code": true, "col": true, "colgroup": true, "dd": true,
+	"del": true, "div": true, "dl": true, "dt": true, "em": true, "figcaption": true,
+	"figure": true, "font": true, "h1": true, "h2": true, "h3": true, "h4": true, "h5": true,
+	"h6": true, "hr": true, "i": true, "img": true, "ins": true, "kbd": true,
+	"li": true, "mark": true, "ol": true, "p": true, "pre": true, "q": true, "s": true,
+	"small": true, "span": true, "strike": true, "strong": true, "sub": true, "sup": true,
+	"table": true, "tbody": true, "td": true, "tfoot": true, "th": true, "thead": true,
+	"tr": true, "u": true, "ul": true,
+}
+
+// droppedTags are removed together with their content; any other tag that
+// is not allowed is unwrapped and its content kept.
+var droppedTags = map[string]bool{
+	"script": true, "style": true, "iframe": true, "frame": true, "frameset": true,
+	"object": true, "embed": true, "applet": true, "form": true, "input": true,
+	"button": true, "select": true, "textarea": true, "noscript": true, "template": true,
+	"svg": true, "math": true, "link": true, "meta": true, "base": true, "audio": true,
+	"video": true, "canvas": true, "title": true, "head": true,
+}
+
+var allowedAttributes = map[string]bool{
+	"align": true, "alt": true, "bgcolor": true, "border": true, "cellpadding": true,
+	"cellspacing": true, "class": true, "color": true, "colspan": true, "dir": true,
+	"face": true, "height": true, "href": true, "id": true, "lang": true, "rel": true,
+	"role": true, "rowspan": true, "scope": true, "size": true, "src": true, "start": true,
+	"style": true, "tabindex": true, "target": true, "title": true, "type": true, "valign": true,
+	"width": true,
+}
+
+// allowedSchemes are the URL schemes links and images may use. URLs
+// without a scheme, including merge tags, are left to the preview
+// warnings.
+var allowedSchemes = map[string][]string{
+	"href": {"http", "https", "mailto", "tel"},
+	"src":  {"http", "https", "cid"},
+}
+
+// unsafeStyle matches CSS that can run script or load content in old
+// clients.
+var unsafeStyle = regexp.MustCompile(`(?i)expression\s*\(|javascript:|vbscript:|behavior\s*:|-moz-binding|@import`)
+
+// SanitizeHTML removes from an HTML fragment whatever is not on the
+// email-safe allowlist: script-capable and interactive elements with their
+// content, unknown elements (keeping their content), event handlers and
+// other attributes, URLs with schemes other than http(s), mailto and tel
+// (cid for images), and styles that can run script. It returns the cleaned
+// fragment and a description of each kind of thing it removed.
+//
+// Merge tags survive unchanged. A fragment that cannot be parsed or
+// rendered again is an error; it is never passed through unchecked.
+func SanitizeHTML(fragment string) (string, []string, error) {
+	var g MergeTagGuard
+	body := &html.Node{Type: html.ElementNode, Data: "body", DataAtom: atom.Body}
+	nodes, err := html.ParseFragment(strings.NewReader(g.Protect(fragment)), body)
+	if err != nil {
+		return "", nil, fmt.Errorf("sanitize HTML: %w", err)
+	}
+	for _, n := range nodes {
+		body.AppendChild(n)
+	}
+
+	s := &sanitizer{counts: map[string]int{}}
+	s.children(body)
+	if !s.changed {
+		return fragment, nil, nil
+	}
+
+	var out strings.Builder
+	for c := body.FirstChild; c != nil; c = c.NextSibling {
+		if err := html.Render(&out, c); err != nil {
+			return "", nil, fmt.Errorf("sanitize HTML: %w", err)
+		}
+	}
+	return g.Restore(out.String()), s.report(), nil
+}
+
+type sanitizer struct {
+	changed bool
+	order   []string
+	counts  map[string]int
+}
+
+func (s *sanitizer) strip(format string, args ...any) {
+	s.changed = true
+	what := fmt.Sprintf(format, args...)
+	if s.counts[what] == 0 {
+		s.order = append(s.order, what)
+	}
+	s.counts[what]++
+}
+
+func (s *sanitizer) report() []string {
+	out := make([]string, len(s.order))
+	for i, what := range s.order {
+		out[i] = what
+		if n := s.counts[what]; n > 1 {
+			out[i] += fmt.Sprintf(" (%d times)", n)
+		}
+	}
+	return out
+}
+
+// children sanitizes the children of n.
+func (s *sanitizer) children(n *html.Node) {
+	for c := n.FirstChild; c != nil; {
+		next := c.NextSibling
+		switch c.Type {
+		case html.CommentNode:
+			// Conditional comments are how Outlook is fed markup.
+			n.RemoveChild(c)
+			s.strip("HTML comment removed")
+		case html.ElementNode:
+			next = s.element(n, c)
+		}
+		c = next
+	}
+}
+
+// element sanitizes c, a child of parent, and returns the node to
+// continue with.
+func (s *sanitizer) element(parent, c *html.Node) *html.Node {
+	next := c.NextSibling
+	tag := c.Data
+	switch {
+	case tag == "input" && attr(c, "type") == "checkbox" && hasAttr(c, "disabled"):
+		// A Markdown task list item; clients would drop the form control.
+		box := "☐"
+		if hasAttr(c, "checked") {
+			box = "☑"
+		}
+		parent.InsertBefore(&html.Node{Type: html.TextNode, Data: box}, c)
+		parent.RemoveChild(c)
+		s.changed = true
+		return next
+	case droppedTags[tag]:
+		parent.RemoveChild(c)
+		s.strip("<%s> removed with its content", tag)
+		return next
+	case !allowedTags[tag]:
+		// Unwrap: move the children up and look at them next.
+		first := c.FirstChild
+		for gc := c.FirstChild; gc != nil; {
+			after := gc.NextSibling
+			c.RemoveChild(gc)
+			parent.InsertBefore(gc, c)
+			gc = after
+		}
+		parent.RemoveChild(c)
+		s.strip("<%s> tag removed, content kept", tag)
+		if first != nil {
+			return first
+		}
+		return next
+	}
+
+	c.Attr = slices.DeleteFunc(c.Attr, func(a html.Attribute) bool {
+		key := strings.ToLower(a.Key)
+		switch {
+		case a.Namespace != "" || !allowedAttributes[key]:
+			s.strip("%s attribute removed from <%s>", key, tag)
+			return true
+		case allowedSchemes[key] != nil && !allowedURL(a.Val, allowedSchemes[key]):
+			s.strip("%s URL removed from <%s %s>", scheme(a.Val), tag, key)
+			return true
+		case key == "style" && unsafeStyle.MatchString(a.Val):
+			s.strip("unsafe style removed from <%s>", tag)
+			return true
+		}
+		return false
+	})
+	s.children(c)
+	return next
+}
+
+func allowedURL(raw string, schemes []string) bool {
+	sch := scheme(raw)
+	return sch == "" || slices.Contains(schemes, sch)
+}
+
+// scheme returns the lower-cased scheme of raw, or "" if it has none.
+// Control characters and spaces, which browsers ignore, do not hide one.
+func scheme(raw string) string {
+	cleaned := strings.Map(func(r rune) rune {
+		if r <= ' ' || r == 0x7f {
+			return -1
+		}
+		return r
+	}, raw)
+	u, err := url.Parse(cleaned)
+	if err != nil {
+		if i := strings.IndexByte(cleaned, ':'); i > 0 {
+			return strings.ToLower(cleaned[:i])
+		}
+		return ""
+	}
+	return strings.ToLower(u.Scheme)
+}
+
+func attr(n *html.Node, key string) string {
+	for _, a := range n.Attr {
+		if a.Key == key {
+			return a.Val
+		}
+	}
+	return ""
+}
+
+func hasAttr(n *html.Node, key string) bool {
+	return slices.ContainsFunc(n.Attr, func(a html.Attribute) bool { return a.Key == key })
+}
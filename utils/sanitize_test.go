@@ -0,0 +1,145 @@
+package utils
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestSanitizeHTML(t *testing.T) {
+	tests := []struct {
+		name     string
+		in       string
+		want     string // must appear in the output
+		gone     []string
+		stripped []string
+	}{
+		{
+			name:     "script",
+			in:       `<p>Hi</p><script>alert(1)</script>`,
+			want:     `<p>Hi</p>`,
+			gone:     []string{"<script", "alert"},
+			stripped: []string{"<script> removed with its content"},
+		},
+		{
+			name:     "script inside allowed element",
+			in:       `<div><p>a<script src="https://evil.example/x.js"></script></p></div>`,
+			want:     `<div><p>a</p></div>`,
+			gone:     []string{"script", "evil"},
+			stripped: []string{"<script> removed with its content"},
+		},
+		{
+			name:     "event handlers",
+			in:       `<img src="https://example.com/a.png" onerror="alert(1)"><p onclick="x()" OnMouseOver="y()">t</p>`,
+			want:     `<img src="https://example.com/a.png"/>`,
+			gone:     []string{"onerror", "onclick", "alert", "y()"},
+			stripped: []string{"onerror attribute removed from <img>", "onclick attribute removed from <p>", "onmouseover attribute removed from <p>"},
+		},
+		{
+			name:     "javascript URL",
+			in:       `<a href="javascript:alert(1)">x</a>`,
+			want:     `<a>x</a>`,
+			gone:     []string{"javascript"},
+			stripped: []string{"javascript URL removed from <a href>"},
+		},
+		{
+			name:     "obfuscated javascript URL",
+			in:       "<a href=\" JaVa\tScRiPt:alert(1)\">x</a><a href=\"java&#x0A;script:alert(2)\">y</a>",
+			gone:     []string{"alert"},
+			stripped: []string{"javascript URL removed from <a href> (2 times)"},
+		},
+		{
+			name:     "data URL image",
+			in:       `<img src="data:image/svg+xml;base64,PHN2Zz4=">`,
+			gone:     []string{"data:"},
+			stripped: []string{"data URL removed from <img src>"},
+		},
+		{
+			name:     "CSS expression",
+			in:       `<div style="width: expression(alert(1))">x</div><p style="color: red">y</p>`,
+			want:     `<div>x</div><p style="color: red">y</p>`,
+			gone:     []string{"expression"},
+			stripped: []string{"unsafe style removed from <div>"},
+		},
+		{
+			name:     "iframe and form",
+			in:       `<iframe src="https://example.com"></iframe><form><input name="q"><button>Go</button></form>`,
+			gone:     []string{"iframe", "form", "input", "button", "Go"},
+			stripped: []string{"<iframe> removed with its content", "<form> removed with its content"},
+		},
+		{
+			name:     "unknown tag is unwrapped",
+			in:       `<section><p>kept</p></section>`,
+			want:     `<p>kept</p>`,
+			gone:     []string{"section"},
+			stripped: []string{"<section> tag removed, content kept"},
+		},
+		{
+			name:     "conditional comment",
+			in:       `<!--[if mso]><table><tr><td><![endif]--><p>x</p>`,
+			want:     `<p>x</p>`,
+			gone:     []string{"mso"},
+			stripped: []string{"HTML comment removed"},
+		},
+		{
+			name: "merge tags in href",
+			in:   `<a href="{{unsubscribe_url}}">Unsubscribe</a> <a href="https://example.com/?id={{ref_id | default &#34;x&#34;}}">go</a>`,
+			want: `<a href="{{unsubscribe_url}}">Unsubscribe</a> <a href="https://example.com/?id={{ref_id | default &#34;x&#34;}}">go</a>`,
+		},
+		{
+			name:     "javascript URL around a merge tag",
+			in:       `<a href="javascript:{{first_name}}">x</a>`,
+			want:     `<a>x</a>`,
+			stripped: []string{"javascript URL removed from <a href>"},
+		},
+		{
+			name: "task list",
+			in:   `<ul><li><input checked="" disabled="" type="checkbox"/> done</li><li><input disabled="" type="checkbox"/> todo</li></ul>`,
+			want: `<li>☑ done</li><li>☐ todo</li>`,
+		},
+		{
+			name: "safe markup unchanged",
+			in:   `<p>A <a href="https://example.com" title="t">link</a> and <code>x</code></p>`,
+			want: `<p>A <a href="https://example.com" title="t">link</a> and <code>x</code></p>`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, stripped, err := SanitizeHTML(tt.in)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.Contains(got, tt.want) {
+				t.Errorf("SanitizeHTML(%q) = %q, want it to contain %q", tt.in, got, tt.want)
+			}
+			for _, gone := range tt.gone {
+				if strings.Contains(strings.ToLower(got), strings.ToLower(gone)) {
+					t.Errorf("SanitizeHTML(%q) = %q, still contains %q", tt.in, got, gone)
+				}
+			}
+			if !slices.Equal(stripped, tt.stripped) {
+				t.Errorf("stripped = %q, want %q", stripped, tt.stripped)
+			}
+		})
+	}
+}
+
+func TestSanitizedMergeTagsAreEscaped(t *testing.T) {
+	// A subscriber attribute cannot turn a sanitized link into script:
+	// html/template filters the URL when the merge tag is rendered.
+	body, _, err := SanitizeHTML(`<a href="{{website}}">site</a>`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl, err := ParseMergeTemplate("s", body, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, html, _, err := tmpl.Render(Contact{Attributes: map[string]any{"website": "javascript:alert(1)"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(html, "javascript") {
+		t.Errorf("rendered %q, want the javascript URL filtered", html)
+	}
+}
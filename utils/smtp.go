@@ -0,0 +1,131 @@
+package utils
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"time"
+)
+
+// smtpTimeout bounds a whole SMTP session when the caller's context has no
+// earlier deadline, so a server that stalls cannot hold a worker forever.
+const smtpTimeout = 60 * time.Second
+
+type SMTPConfig struct {
+	Host     string
+	Port     string
+	Username string
+	Password string
+}
+
+// SMTPSender delivers mail over SMTP. STARTTLS is mandatory and credentials,
+// when configured, are sent with AUTH PLAIN over the encrypted channel.
+type SMTPSender struct {
+	cfg     SMTPConfig
+	sender  Sender
+	timeout time.Duration
+}
+
+func NewSMTPSender(cfg SMTPConfig, sender Sender) (*SMTPSender, error) {
+	if cfg.Host == "" {
+		return nil, errors.New("SMTP_HOST is not set")
+	}
+	if cfg.Port == "" {
+		cfg.Port = "587"
+	}
+	if sender.Email == "" {
+		return nil, errors.New("sender email is not set")
+	}
+	return &SMTPSender{cfg: cfg, sender: sender, timeout: smtpTimeout}, nil
+}
+
+// Send delivers msg in its own SMTP session. Transient 4xx replies and
+// network failures before the message was handed over are returned as
+// retryable *SendErrors.
+func (s *SMTPSender) Send(ctx context.Context, msg Message) error {
+	raw, err := buildMIME(s.sender, msg)
+	if err != nil {
+		return fmt.Errorf("failed to build message: %w", err)
+	}
+
+	deadline := time.Now().Add(s.timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+
+	dialer := net.Dialer{Timeout: 15 * time.Second, Deadline: deadline}
+	conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(s.cfg.Host, s.cfg.Port))
+	if err != nil {
+		return smtpError(ctx, fmt.Errorf("failed to connect to SMTP server: %w", err))
+	}
+	conn.SetDeadline(deadline)
+
+	c, err := smtp.NewClient(conn, s.cfg.Host)
+	if err != nil {
+		conn.Close()
+		return smtpError(ctx, fmt.Errorf("failed to start SMTP session: %w", err))
+	}
+	defer c.Close()
+
+	if ok, _ := c.Extension("STARTTLS"); !ok {
+		return &SendError{Err: errors.New("SMTP server does not support STARTTLS")}
+	}
+	if err := c.StartTLS(&tls.Config{ServerName: s.cfg.Host}); err != nil {
+		return smtpError(ctx, fmt.Errorf("STARTTLS failed: %w", err))
+	}
+
+	if s.cfg.Username != "" {
+		auth := smtp.PlainAuth("", s.cfg.Username, s.cfg.Password, s.cfg.Host)
+		if err := c.Auth(auth); err != nil {
+			return smtpError(ctx, fmt.Errorf("SMTP auth failed: %w", err))
+		}
+	}
+
+	if err := c.Mail(s.sender.Email); err != nil {
+		return smtpError(ctx, fmt.Errorf("SMTP MAIL FROM failed: %w", err))
+	}
+	if err := c.Rcpt(msg.To); err != nil {
+		return smtpError(ctx, fmt.Errorf("SMTP RCPT TO failed: %w", err))
+	}
+
+	w, err := c.Data()
+	if err != nil {
+		return smtpError(ctx, fmt.Errorf("SMTP DATA failed: %w", err))
+	}
+	if _, err := w.Write(raw); err != nil {
+		return smtpError(ctx, fmt.Errorf("failed to write message: %w", err))
+	}
+	if err := w.Close(); err != nil {
+		var reply *textproto.Error
+		if !errors.As(err, &reply) {
+			// The whole message went out but its reply did not come
+			// back; the server may have accepted it.
+			return &SendError{Err: fmt.Errorf("SMTP server did not confirm message: %w", err), Unknown: true}
+		}
+		return smtpError(ctx, fmt.Errorf("SMTP server rejected message: %w", err))
+	}
+
+	// The message is accepted; a failed QUIT must not get it resent.
+	c.Quit()
+	return nil
+}
+
+// smtpError classifies a failed SMTP step before the message was accepted.
+// 4xx replies are transient by definition (RFC 5321, 4.2.1), as are
+// network failures unless the caller was cancelled.
+func smtpError(ctx context.Context, err error) *SendError {
+	var reply *textproto.Error
+	if errors.As(err, &reply) {
+		return &SendError{Err: err, StatusCode: reply.Code, Retryable: reply.Code/100 == 4}
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return &SendError{Err: err, Retryable: ctx.Err() == nil}
+	}
+	return &SendError{Err: err}
+}
@@ -0,0 +1,75 @@
+package utils
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+// smtpServer returns a sender for a local server that greets every
+// connection with greeting (or nothing when it is empty) and then stalls.
+func smtpServer(t *testing.T, greeting string) *SMTPSender {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			t.Cleanup(func() { conn.Close() })
+			if greeting != "" {
+				conn.Write([]byte(greeting + "\r\n"))
+			}
+		}
+	}()
+
+	host, port, _ := net.SplitHostPort(ln.Addr().String())
+	s, err := NewSMTPSender(SMTPConfig{Host: host, Port: port}, Sender{Email: "news@example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestSMTPReplyClassification(t *testing.T) {
+	tests := []struct {
+		greeting  string
+		retryable bool
+	}{
+		{"421 try again later", true},
+		{"451 local error", true},
+		{"554 no service", false},
+	}
+	for _, tt := range tests {
+		s := smtpServer(t, tt.greeting)
+		err := s.Send(context.Background(), Message{To: "a@example.com", HTML: "<p>Hi</p>"})
+		if err == nil || IsRetryable(err) != tt.retryable || OutcomeUnknown(err) {
+			t.Errorf("%q: err = %#v, want retryable %v", tt.greeting, err, tt.retryable)
+		}
+	}
+}
+
+func TestSMTPStalledServerTimesOut(t *testing.T) {
+	s := smtpServer(t, "")
+	s.timeout = 50 * time.Millisecond
+
+	done := make(chan error, 1)
+	go func() {
+		// No deadline on the context, as in the queue workers.
+		done <- s.Send(context.Background(), Message{To: "a@example.com", HTML: "<p>Hi</p>"})
+	}()
+	select {
+	case err := <-done:
+		if !IsRetryable(err) {
+			t.Errorf("err = %v, want retryable", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Send still blocked on a stalled server")
+	}
+}
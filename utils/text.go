@@ -0,0 +1,265 @@
+package utils
+
+import (
+	"fmt"
+	"html"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/extension"
+	extast "github.com/yuin/goldmark/extension/ast"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+)
+
+var (
+	invisiblePattern = regexp.MustCompile(`(?is)<head\b.*?</head>|<style\b.*?</style>|<script\b.*?</script>|<!--.*?-->`)
+	anchorPattern    = regexp.MustCompile(`(?is)<a\b[^>]*?\bhref\s*=\s*"([^"]*)"[^>]*>(.*?)</a>`)
+	altPattern       = regexp.MustCompile(`(?i)<img\b[^>]*?\balt\s*=\s*"([^"]*)"[^>]*>`)
+	breakPattern     = regexp.MustCompile(`(?i)<br\s*/?>|</(p|div|h[1-6]|li|tr|pre|blockquote|table)>`)
+	tagPattern       = regexp.MustCompile(`<[^>]*>`)
+	blankLines       = regexp.MustCompile(`\n{3,}`)
+)
+
+var textParser = goldmark.New(goldmark.WithExtensions(extension.GFM)).Parser()
+
+// MarkdownToText renders Markdown as the plain-text alternative of an
+// email: headings keep their # markers, links become numbered footnotes,
+// code blocks are kept verbatim and indented, tables are flattened to one
+// line per row and raw HTML is reduced to its text.
+func MarkdownToText(md string) string {
+	source := []byte(md)
+	t := &textRenderer{source: source}
+	body := t.blocks(textParser.Parse(text.NewReader(source)))
+
+	if len(t.links) > 0 {
+		var notes strings.Builder
+		for i, link := range t.links {
+			fmt.Fprintf(&notes, "[%d] %s\n", i+1, link)
+		}
+		body += "\n\n" + strings.TrimSuffix(notes.String(), "\n")
+	}
+	return strings.TrimSpace(blankLines.ReplaceAllString(body, "\n\n")) + "\n"
+}
+
+// MarkdownTemplateToText is MarkdownToText for Markdown that contains
+// merge tags; see MarkdownTemplateToHTML.
+func MarkdownTemplateToText(md string) string {
+	var g MergeTagGuard
+	md = literalCodeBraces(md)
+	return restoreCodeBraces(g.Restore(MarkdownToText(g.Protect(md))))
+}
+
+type textRenderer struct {
+	source []byte
+	links  []string
+}
+
+// footnote returns the reference for url, numbering each URL once.
+func (t *textRenderer) footnote(url string) string {
+	i := slices.Index(t.links, url)
+	if i < 0 {
+		t.links = append(t.links, url)
+		i = len(t.links) - 1
+	}
+	return fmt.Sprintf("[%d]", i+1)
+}
+
+// blocks renders the block children of n separated by blank lines.
+func (t *textRenderer) blocks(n ast.Node) string {
+	var out []string
+	for c := n.FirstChild(); c != nil; c = c.NextSibling() {
+		if s := t.block(c); s != "" {
+			out = append(out, s)
+		}
+	}
+	return strings.Join(out, "\n\n")
+}
+
+func (t *textRenderer) block(n ast.Node) string {
+	switch n := n.(type) {
+	case *ast.Paragraph, *ast.TextBlock:
+		return strings.TrimSpace(t.inlines(n))
+	case *ast.Heading:
+		// Underlines would not match the length of personalized text,
+		// so headings keep their Markdown markers.
+		return strings.Repeat("#", n.Level) + " " + strings.TrimSpace(t.inlines(n))
+	case *ast.ThematicBreak:
+		return "----"
+	case *ast.CodeBlock, *ast.FencedCodeBlock:
+		var b strings.Builder
+		lines := n.Lines()
+		for i := 0; i < lines.Len(); i++ {
+			seg := lines.At(i)
+			line := strings.TrimRight(string(seg.Value(t.source)), "\r\n")
+			if line != "" {
+				b.WriteString("    " + line)
+			}
+			b.WriteString("\n")
+		}
+		return strings.TrimRight(b.String(), "\n")
+	case *ast.Blockquote:
+		return prefixLines(t.blocks(n), "> ", "> ")
+	case *ast.List:
+		return t.list(n)
+	case *ast.HTMLBlock:
+		var b strings.Builder
+		lines := n.Lines()
+		for i := 0; i < lines.Len(); i++ {
+			seg := lines.At(i)
+			b.Write(seg.Value(t.source))
+		}
+		if n.HasClosure() {
+			b.Write(n.ClosureLine.Value(t.source))
+		}
+		return t.html(b.String())
+	case *extast.Table:
+		var rows []string
+		for row := n.FirstChild(); row != nil; row = row.NextSibling() {
+			var cells []string
+			for cell := row.FirstChild(); cell != nil; cell = cell.NextSibling() {
+				cells = append(cells, strings.TrimSpace(t.inlines(cell)))
+			}
+			rows = append(rows, strings.Join(cells, " | "))
+		}
+		return strings.Join(rows, "\n")
+	}
+	return t.blocks(n)
+}
+
+func (t *textRenderer) list(n *ast.List) string {
+	sep := "\n"
+	if !n.IsTight {
+		sep = "\n\n"
+	}
+	var items []string
+	number := n.Start
+	for item := n.FirstChild(); item != nil; item = item.NextSibling() {
+		marker := "- "
+		if n.IsOrdered() {
+			marker = fmt.Sprintf("%d. ", number)
+			number++
+		}
+		var parts []string
+		for c := item.FirstChild(); c != nil; c = c.NextSibling() {
+			if s := t.block(c); s != "" {
+				parts = append(parts, s)
+			}
+		}
+		items = append(items, prefixLines(strings.Join(parts, sep), marker, strings.Repeat(" ", len(marker))))
+	}
+	return strings.Join(items, sep)
+}
+
+// inlines renders the inline children of n.
+func (t *textRenderer) inlines(n ast.Node) string {
+	var b strings.Builder
+	for c := n.FirstChild(); c != nil; c = c.NextSibling() {
+		t.inline(&b, c)
+	}
+	return b.String()
+}
+
+func (t *textRenderer) inline(b *strings.Builder, n ast.Node) {
+	switch n := n.(type) {
+	case *ast.Text:
+		b.Write(resolveText(n.Value(t.source)))
+		if n.SoftLineBreak() || n.HardLineBreak() {
+			b.WriteString("\n")
+		}
+	case *ast.String:
+		b.Write(n.Value)
+	case *ast.CodeSpan:
+		b.WriteString("`")
+		for c := n.FirstChild(); c != nil; c = c.NextSibling() {
+			if s, ok := c.(*ast.Text); ok {
+				b.Write(s.Value(t.source))
+			} else {
+				t.inline(b, c)
+			}
+		}
+		b.WriteString("`")
+	case *ast.Link:
+		label := strings.TrimSpace(t.inlines(n))
+		url := string(n.Destination)
+		switch {
+		case label == "" || label == url:
+			b.WriteString(url)
+		case strings.HasPrefix(url, "#"):
+			b.WriteString(label)
+		default:
+			b.WriteString(label + " " + t.footnote(url))
+		}
+	case *ast.AutoLink:
+		b.Write(n.Label(t.source))
+	case *ast.Image:
+		b.WriteString(t.inlines(n))
+	case *ast.RawHTML:
+		var raw strings.Builder
+		for i := 0; i < n.Segments.Len(); i++ {
+			seg := n.Segments.At(i)
+			raw.Write(seg.Value(t.source))
+		}
+		if breakPattern.MatchString(raw.String()) {
+			b.WriteString("\n")
+		}
+	case *extast.TaskCheckBox:
+		if n.IsChecked {
+			b.WriteString("[x] ")
+		} else {
+			b.WriteString("[ ] ")
+		}
+	default:
+		b.WriteString(t.inlines(n))
+	}
+}
+
+// html reduces raw HTML to its text. Links become footnotes and images
+// their alt text.
+func (t *textRenderer) html(s string) string {
+	s = invisiblePattern.ReplaceAllString(s, "")
+	s = altPattern.ReplaceAllString(s, " $1 ")
+	s = anchorPattern.ReplaceAllStringFunc(s, func(a string) string {
+		m := anchorPattern.FindStringSubmatch(a)
+		label := strings.Join(strings.Fields(html.UnescapeString(tagPattern.ReplaceAllString(m[2], ""))), " ")
+		url := html.UnescapeString(m[1])
+		if label == "" || label == url {
+			return url
+		}
+		return label + " " + t.footnote(url)
+	})
+	s = breakPattern.ReplaceAllString(s, "\n")
+	s = html.UnescapeString(tagPattern.ReplaceAllString(s, ""))
+
+	lines := strings.Split(s, "\n")
+	for i, line := range lines {
+		lines[i] = strings.Join(strings.Fields(line), " ")
+	}
+	return strings.TrimSpace(blankLines.ReplaceAllString(strings.Join(lines, "\n"), "\n\n"))
+}
+
+// resolveText turns Markdown text into what a reader sees: backslash
+// escapes and character references are resolved.
+func resolveText(b []byte) []byte {
+	return util.ResolveEntityNames(util.ResolveNumericReferences(util.UnescapePunctuations(b)))
+}
+
+// prefixLines starts the first line of s with first and the others with
+// rest, leaving blank lines blank.
+func prefixLines(s, first, rest string) string {
+	lines := strings.Split(s, "\n")
+	for i, line := range lines {
+		p := rest
+		if i == 0 {
+			p = first
+		}
+		if line == "" {
+			p = strings.TrimRight(p, " ")
+		}
+		lines[i] = p + line
+	}
+	return strings.Join(lines, "\n")
+}
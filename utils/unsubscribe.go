@@ -0,0 +1,72 @@
+package utils
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"os"
+	"strings"
+)
+
+var ErrInvalidUnsubscribeToken = errors.New("invalid unsubscribe token")
+
+// UnsubscribeToken returns a token binding email to UNSUBSCRIBE_SECRET. It
+// carries the address itself, so no lookup table is needed, and cannot be
+// forged for another address without the secret.
+func UnsubscribeToken(email string) string {
+	payload := base64.RawURLEncoding.EncodeToString([]byte(email))
+	return payload + "." + base64.RawURLEncoding.EncodeToString(unsubscribeMAC(payload))
+}
+
+// ParseUnsubscribeToken verifies token and returns the email it was issued
+// for.
+func ParseUnsubscribeToken(token string) (string, error) {
+	payload, sig, ok := strings.Cut(token, ".")
+	if !ok || os.Getenv("UNSUBSCRIBE_SECRET") == "" {
+		return "", ErrInvalidUnsubscribeToken
+	}
+	got, err := base64.RawURLEncoding.DecodeString(sig)
+	if err != nil || !hmac.Equal(got, unsubscribeMAC(payload)) {
+		return "", ErrInvalidUnsubscribeToken
+	}
+	email, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil || len(email) == 0 {
+		return "", ErrInvalidUnsubscribeToken
+	}
+	return string(email), nil
+}
+
+// UnsubscribeURL is the one-click unsubscribe link for email, served by
+// this API under PUBLIC_URL.
+func UnsubscribeURL(email string) string {
+	return PublicURL() + "/unsubscribe/" + UnsubscribeToken(email)
+}
+
+// UnsubscribeHeaders returns the RFC 2369 / RFC 8058 headers that let mail
+// clients offer a native one-click unsubscribe button.
+func UnsubscribeHeaders(email string) map[string]string {
+	return map[string]string{
+		"List-Unsubscribe":      "<" + UnsubscribeURL(email) + ">",
+		"List-Unsubscribe-Post": "List-Unsubscribe=One-Click",
+	}
+}
+
+// PublicURL is the externally reachable base URL of this API.
+func PublicURL() string {
+	u := strings.TrimRight(os.Getenv("PUBLIC_URL"), "/")
+	if u == "" {
+		port := os.Getenv("PORT")
+		if port == "" {
+			port = "8080"
+		}
+		u = "http://localhost:" + port
+	}
+	return u
+}
+
+func unsubscribeMAC(payload string) []byte {
+	mac := hmac.New(sha256.New, []byte(os.Getenv("UNSUBSCRIBE_SECRET")))
+	mac.Write([]byte("unsubscribe:" + payload))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,70 @@
+package utils
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+)
+
+func TestUnsubscribeToken(t *testing.T) {
+	t.Setenv("UNSUBSCRIBE_SECRET", "secret")
+	token := UnsubscribeToken("ada@example.com")
+
+	email, err := ParseUnsubscribeToken(token)
+	if err != nil || email != "ada@example.com" {
+		t.Fatalf("ParseUnsubscribeToken(%q) = %q, %v", token, email, err)
+	}
+	if strings.ContainsAny(token, "+/=") {
+		t.Errorf("token %q is not URL safe", token)
+	}
+
+	// Another address under the same signature is a forgery.
+	_, sig, _ := strings.Cut(token, ".")
+	swapped := base64.RawURLEncoding.EncodeToString([]byte("bob@example.com")) + "." + sig
+
+	tests := []struct {
+		name, token string
+	}{
+		{"swapped address", swapped},
+		{"no signature", strings.Split(token, ".")[0]},
+		{"bad signature encoding", strings.Split(token, ".")[0] + ".!!"},
+		{"truncated signature", token[:len(token)-2]},
+		{"empty address", "." + base64.RawURLEncoding.EncodeToString(unsubscribeMAC(""))},
+		{"empty", ""},
+	}
+	for _, tt := range tests {
+		if email, err := ParseUnsubscribeToken(tt.token); err == nil {
+			t.Errorf("%s: ParseUnsubscribeToken(%q) = %q, want an error", tt.name, tt.token, email)
+		}
+	}
+}
+
+func TestUnsubscribeTokenSecret(t *testing.T) {
+	t.Setenv("UNSUBSCRIBE_SECRET", "secret")
+	token := UnsubscribeToken("ada@example.com")
+
+	t.Setenv("UNSUBSCRIBE_SECRET", "rotated")
+	if _, err := ParseUnsubscribeToken(token); err == nil {
+		t.Error("token signed with another secret accepted")
+	}
+
+	// Without a secret every token would be forgeable, so none is valid.
+	t.Setenv("UNSUBSCRIBE_SECRET", "")
+	if _, err := ParseUnsubscribeToken(UnsubscribeToken("ada@example.com")); err == nil {
+		t.Error("token accepted without UNSUBSCRIBE_SECRET")
+	}
+}
+
+func TestUnsubscribeHeaders(t *testing.T) {
+	t.Setenv("UNSUBSCRIBE_SECRET", "secret")
+	t.Setenv("PUBLIC_URL", "https://news.example.com/")
+	h := UnsubscribeHeaders("ada@example.com")
+
+	want := "<https://news.example.com/unsubscribe/" + UnsubscribeToken("ada@example.com") + ">"
+	if h["List-Unsubscribe"] != want {
+		t.Errorf("List-Unsubscribe = %q, want %q", h["List-Unsubscribe"], want)
+	}
+	if h["List-Unsubscribe-Post"] != "List-Unsubscribe=One-Click" {
+		t.Errorf("List-Unsubscribe-Post = %q", h["List-Unsubscribe-Post"])
+	}
+}
@@ -0,0 +1,71 @@
+package utils
+
+import (
+	"html"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+var (
+	hrefPattern    = regexp.MustCompile(`(?i)(<a\b[^>]*?\bhref\s*=\s*")([^"]*)(")`)
+	textURLPattern = regexp.MustCompile(`https?://[^\s<>"'()\[\]]+[^\s<>"'()\[\].,;:!?]`)
+)
+
+// AddUTMToHTML adds utm_source=newsletter, utm_medium=email and
+// utm_campaign=campaign to the http(s) links of an HTML document. Links
+// that contain merge tags or already carry UTM parameters are left alone,
+// as is everything when campaign is empty.
+func AddUTMToHTML(document, campaign string) string {
+	if campaign == "" {
+		return document
+	}
+	return hrefPattern.ReplaceAllStringFunc(document, func(a string) string {
+		m := hrefPattern.FindStringSubmatch(a)
+		tagged := addUTM(html.UnescapeString(m[2]), campaign)
+		return m[1] + html.EscapeString(tagged) + m[3]
+	})
+}
+
+// AddUTMToText is AddUTMToHTML for the URLs of a plain-text body.
+func AddUTMToText(text, campaign string) string {
+	if campaign == "" {
+		return text
+	}
+	return textURLPattern.ReplaceAllStringFunc(text, func(link string) string {
+		return addUTM(link, campaign)
+	})
+}
+
+// addUTM appends the UTM parameters to the query of link. The rest of the
+// link is kept byte for byte, so signed or order-sensitive query strings
+// still work.
+func addUTM(link, campaign string) string {
+	if strings.Contains(link, "{{") {
+		return link
+	}
+	u, err := url.Parse(link)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return link
+	}
+	for _, pair := range strings.FieldsFunc(u.RawQuery, func(r rune) bool { return r == '&' || r == ';' }) {
+		if key, _, _ := strings.Cut(pair, "="); strings.HasPrefix(strings.ToLower(key), "utm_") {
+			return link
+		}
+	}
+
+	utm := "utm_source=newsletter&utm_medium=email&utm_campaign=" + url.QueryEscape(campaign)
+	base, fragment, hasFragment := strings.Cut(link, "#")
+	switch {
+	case !strings.Contains(base, "?"):
+		base += "?" + utm
+	case strings.HasSuffix(base, "?") || strings.HasSuffix(base, "&"):
+		base += utm
+	default:
+		base += "&" + utm
+	}
+	if hasFragment {
+		return base + "#" + fragment
+	}
+	return base
+}
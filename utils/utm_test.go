@@ -0,0 +1,51 @@
+package utils
+
+import "testing"
+
+const utm = "utm_source=newsletter&utm_medium=email&utm_campaign=spring+launch"
+
+func TestAddUTM(t *testing.T) {
+	tests := []struct {
+		link, want string
+	}{
+		{"https://example.com", "https://example.com?" + utm},
+		{"https://example.com/post", "https://example.com/post?" + utm},
+		{"https://example.com/post#intro", "https://example.com/post?" + utm + "#intro"},
+		{"https://example.com/?", "https://example.com/?" + utm},
+		// Existing parameters keep their order and encoding.
+		{"https://example.com/dl?z=1&a=%2f&sig=Ab%2BC", "https://example.com/dl?z=1&a=%2f&sig=Ab%2BC&" + utm},
+		{"https://example.com/?b=2&a=1#top", "https://example.com/?b=2&a=1&" + utm + "#top"},
+		// Links that already carry UTM parameters are left alone.
+		{"https://example.com/?utm_source=twitter", "https://example.com/?utm_source=twitter"},
+		{"https://example.com/?x=1&UTM_Medium=social", "https://example.com/?x=1&UTM_Medium=social"},
+		// So are merge tags and other schemes.
+		{"{{unsubscribe_url}}", "{{unsubscribe_url}}"},
+		{"https://example.com/?id={{email}}", "https://example.com/?id={{email}}"},
+		{"mailto:me@example.com", "mailto:me@example.com"},
+		{"/relative", "/relative"},
+	}
+	for _, tt := range tests {
+		if got := addUTM(tt.link, "spring launch"); got != tt.want {
+			t.Errorf("addUTM(%q) = %q, want %q", tt.link, got, tt.want)
+		}
+	}
+}
+
+func TestAddUTMToHTML(t *testing.T) {
+	in := `<p><a href="https://example.com/?a=1&amp;b=2">x</a> <a href="#top">top</a></p>`
+	want := `<p><a href="https://example.com/?a=1&amp;b=2&amp;utm_source=newsletter&amp;utm_medium=email&amp;utm_campaign=spring+launch">x</a> <a href="#top">top</a></p>`
+	if got := AddUTMToHTML(in, "spring launch"); got != want {
+		t.Errorf("AddUTMToHTML = %q, want %q", got, want)
+	}
+	if got := AddUTMToHTML(in, ""); got != in {
+		t.Errorf("AddUTMToHTML without campaign = %q, want input unchanged", got)
+	}
+}
+
+func TestAddUTMToText(t *testing.T) {
+	in := "Read it at https://example.com/post. Or https://example.com/?utm_source=x!"
+	want := "Read it at https://example.com/post?" + utm + ". Or https://example.com/?utm_source=x!"
+	if got := AddUTMToText(in, "spring launch"); got != want {
+		t.Errorf("AddUTMToText = %q, want %q", got, want)
+	}
+}